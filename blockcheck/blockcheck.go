@@ -29,12 +29,12 @@ var AddressesUpdated time.Time
 var FlashbotsBlockCache map[int64]api.FlashbotsBlock = make(map[int64]api.FlashbotsBlock)
 
 type ErrorCounts struct {
-	FailedFlashbotsTx                  uint64
-	Failed0GasTx                       uint64
-	BundlePaysMoreThanPrevBundle       uint64
-	BundleHasLowerFeeThanLowestNonFbTx uint64
-	BundleHas0Fee                      uint64
-	BundleHasNegativeFee               uint64
+	FailedFlashbotsTx                  uint64 `json:"failed_flashbots_tx"`
+	Failed0GasTx                       uint64 `json:"failed_0gas_tx"`
+	BundlePaysMoreThanPrevBundle       uint64 `json:"bundle_pays_more_than_prev_bundle"`
+	BundleHasLowerFeeThanLowestNonFbTx uint64 `json:"bundle_lower_fee_than_lowest_non_fb_tx"`
+	BundleHas0Fee                      uint64 `json:"bundle_0_fee"`
+	BundleHasNegativeFee               uint64 `json:"bundle_negative_fee"`
 }
 
 func (ec *ErrorCounts) Add(counts ErrorCounts) {
@@ -46,6 +46,46 @@ func (ec *ErrorCounts) Add(counts ErrorCounts) {
 	ec.BundleHasNegativeFee += counts.BundleHasNegativeFee
 }
 
+func (ec *ErrorCounts) Sub(counts ErrorCounts) {
+	ec.FailedFlashbotsTx -= counts.FailedFlashbotsTx
+	ec.Failed0GasTx -= counts.Failed0GasTx
+	ec.BundlePaysMoreThanPrevBundle -= counts.BundlePaysMoreThanPrevBundle
+	ec.BundleHasLowerFeeThanLowestNonFbTx -= counts.BundleHasLowerFeeThanLowestNonFbTx
+	ec.BundleHas0Fee -= counts.BundleHas0Fee
+	ec.BundleHasNegativeFee -= counts.BundleHasNegativeFee
+}
+
+// Finding types, one for each field of ErrorCounts (used as metric labels and in alert configuration)
+const (
+	FindingFailedFlashbotsTx                  = "failed_flashbots_tx"
+	FindingFailed0GasTx                       = "failed_0gas_tx"
+	FindingBundlePaysMoreThanPrevBundle       = "bundle_pays_more_than_prev_bundle"
+	FindingBundleHasLowerFeeThanLowestNonFbTx = "bundle_lower_fee_than_lowest_non_fb_tx"
+	FindingBundleHas0Fee                      = "bundle_0_fee"
+	FindingBundleHasNegativeFee               = "bundle_negative_fee"
+)
+
+var FindingTypes = []string{
+	FindingFailedFlashbotsTx,
+	FindingFailed0GasTx,
+	FindingBundlePaysMoreThanPrevBundle,
+	FindingBundleHasLowerFeeThanLowestNonFbTx,
+	FindingBundleHas0Fee,
+	FindingBundleHasNegativeFee,
+}
+
+// ByType returns the counts indexed by finding type
+func (ec ErrorCounts) ByType() map[string]uint64 {
+	return map[string]uint64{
+		FindingFailedFlashbotsTx:                  ec.FailedFlashbotsTx,
+		FindingFailed0GasTx:                       ec.Failed0GasTx,
+		FindingBundlePaysMoreThanPrevBundle:       ec.BundlePaysMoreThanPrevBundle,
+		FindingBundleHasLowerFeeThanLowestNonFbTx: ec.BundleHasLowerFeeThanLowestNonFbTx,
+		FindingBundleHas0Fee:                      ec.BundleHas0Fee,
+		FindingBundleHasNegativeFee:               ec.BundleHasNegativeFee,
+	}
+}
+
 type BlockCheck struct {
 	Number           int64
 	Miner            string
@@ -462,6 +502,7 @@ func (b *BlockCheck) checkBlockForFailedTx() (failedTransactions []FailedTx) {
 				From:        fbTx.EoaAddress,
 				To:          fbTx.ToAddress,
 				Block:       uint64(fbTx.BlockNumber),
+				BlockHash:   b.EthBlock.Hash().Hex(),
 			}
 
 			msg := fmt.Sprintf("failed %s tx [%s](<https://etherscan.io/tx/%s>) in bundle %d (from [%s](<https://etherscan.io/address/%s>))\n", fbTx.BundleType, fbTx.Hash, fbTx.Hash, fbTx.BundleIndex, fbTx.EoaAddress, fbTx.EoaAddress)
@@ -499,6 +540,7 @@ func (b *BlockCheck) checkBlockForFailedTx() (failedTransactions []FailedTx) {
 					From:        from.String(),
 					To:          to,
 					Block:       uint64(b.Number),
+					BlockHash:   b.EthBlock.Hash().Hex(),
 				}
 
 				msg := fmt.Sprintf("failed 0-gas tx [%s](<https://etherscan.io/tx/%s>) from [%s](<https://etherscan.io/address/%s>)\n", tx.Hash(), tx.Hash(), from, from)
@@ -514,6 +556,19 @@ func (b *BlockCheck) checkBlockForFailedTx() (failedTransactions []FailedTx) {
 }
 
 func CacheFlashbotsBlocks(startBlock int64, endBlock int64) error {
+	cachedUntil, err := CacheAvailableFlashbotsBlocks(startBlock, endBlock)
+	if err != nil {
+		return err
+	}
+	if cachedUntil < endBlock {
+		return ErrFlashbotsApiDoesntHaveThatBlockYet
+	}
+	return nil
+}
+
+// CacheAvailableFlashbotsBlocks caches the Flashbots blocks of the range up to the latest block of the API, and returns
+// the last height of the range that the API covers (< startBlock if none)
+func CacheAvailableFlashbotsBlocks(startBlock int64, endBlock int64) (cachedUntil int64, err error) {
 	numBlocks := endBlock - startBlock
 	limit1 := int64(10_000)
 	if numBlocks < 10_000 {
@@ -528,16 +583,17 @@ func CacheFlashbotsBlocks(startBlock int64, endBlock int64) error {
 
 	flashbotsResponse, err := api.GetBlocks(&opts)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	// Return an error if API doesn't have the block yet
+	// The API doesn't have the later blocks yet
+	cachedUntil = endBlock
 	if flashbotsResponse.LatestBlockNumber < endBlock {
-		return ErrFlashbotsApiDoesntHaveThatBlockYet
+		cachedUntil = flashbotsResponse.LatestBlockNumber
 	}
 
 	// Cache now
-	lowestBlock := endBlock
+	lowestBlock := cachedUntil
 	for _, block := range flashbotsResponse.Blocks {
 		FlashbotsBlockCache[block.BlockNumber] = block
 		if block.BlockNumber < lowestBlock {
@@ -549,7 +605,7 @@ func CacheFlashbotsBlocks(startBlock int64, endBlock int64) error {
 	for {
 		// fmt.Println("check2. lowestCurrent:", lowestBlock, "start", startBlock)
 		if lowestBlock <= startBlock {
-			return nil
+			return cachedUntil, nil
 		}
 
 		numBlocks = lowestBlock - startBlock
@@ -565,7 +621,7 @@ func CacheFlashbotsBlocks(startBlock int64, endBlock int64) error {
 
 		flashbotsResponse, err = api.GetBlocks(&opts)
 		if err != nil {
-			return err
+			return 0, err
 		}
 
 		// fmt.Println("cached before", len(FlashbotsBlockCache))
@@ -18,19 +18,21 @@ func NewErrorSummary() ErrorSummary {
 	}
 }
 
-func (es *ErrorSummary) String() (ret string) {
-	// Get list of keys by number of errorBlocks
-	keys := make([]string, 0, len(es.MinerErrors))
-	for k := range es.MinerErrors {
-		keys = append(keys, k)
+// SortedMinerErrors returns the miner errors, sorted by number of errorBlocks (descending)
+func (es *ErrorSummary) SortedMinerErrors() []*MinerErrors {
+	res := make([]*MinerErrors, 0, len(es.MinerErrors))
+	for _, minerErrors := range es.MinerErrors {
+		res = append(res, minerErrors)
 	}
-	sort.Slice(keys, func(i, j int) bool {
-		return len(es.MinerErrors[keys[i]].Blocks) > len(es.MinerErrors[keys[j]].Blocks)
+	sort.Slice(res, func(i, j int) bool {
+		return len(res[i].Blocks) > len(res[j].Blocks)
 	})
+	return res
+}
 
-	for _, key := range keys {
-		minerErrors := es.MinerErrors[key]
-		minerId := key
+func (es *ErrorSummary) String() (ret string) {
+	for _, minerErrors := range es.SortedMinerErrors() {
+		minerId := minerErrors.MinerHash
 		if minerErrors.MinerName != "" {
 			minerId += fmt.Sprintf(" (%s)", minerErrors.MinerName)
 		}
@@ -59,6 +61,39 @@ func (es *ErrorSummary) AddCheckErrors(check *BlockCheck) {
 	es.AddErrorCounts(check.Miner, check.MinerName, check.Number, check.ErrorCounter)
 }
 
+// RemoveCheckErrors removes the errors of a check that was added before (eg. when the block was reorged out)
+func (es *ErrorSummary) RemoveCheckErrors(check *BlockCheck) {
+	minerErrors, found := es.MinerErrors[check.Miner]
+	if !found {
+		return
+	}
+
+	minerErrors.RemoveErrorCounts(check.Number, check.ErrorCounter)
+	if len(minerErrors.Blocks) == 0 {
+		delete(es.MinerErrors, check.Miner)
+	}
+}
+
+// Merge adds the errors of another summary (eg. of a disjoint block range checked by another process)
+func (es *ErrorSummary) Merge(other ErrorSummary) {
+	for minerHash, otherMinerErrors := range other.MinerErrors {
+		minerErrors, found := es.MinerErrors[minerHash]
+		if !found {
+			minerErrors = &MinerErrors{
+				MinerHash: minerHash,
+				MinerName: otherMinerErrors.MinerName,
+				Blocks:    make(map[int64]bool),
+			}
+			es.MinerErrors[minerHash] = minerErrors
+		}
+		minerErrors.Merge(otherMinerErrors)
+	}
+
+	if other.TimeStarted.Before(es.TimeStarted) {
+		es.TimeStarted = other.TimeStarted
+	}
+}
+
 func (es *ErrorSummary) Reset() {
 	es.TimeStarted = time.Now()
 	es.MinerErrors = make(map[string]*MinerErrors)
@@ -0,0 +1,34 @@
+package blockcheck
+
+import (
+	"testing"
+	"time"
+)
+
+func TestErrorSummaryMerge(t *testing.T) {
+	a := NewErrorSummary()
+	a.AddErrorCounts("0xa", "miner a", 1, ErrorCounts{FailedFlashbotsTx: 1})
+
+	b := NewErrorSummary()
+	b.TimeStarted = a.TimeStarted.Add(-time.Hour)
+	b.AddErrorCounts("0xa", "miner a", 5, ErrorCounts{FailedFlashbotsTx: 2, BundleHas0Fee: 1})
+	b.AddErrorCounts("0xb", "miner b", 6, ErrorCounts{Failed0GasTx: 1})
+
+	a.Merge(b)
+	if len(a.MinerErrors) != 2 {
+		t.Fatal("Expected 2 miners, got", len(a.MinerErrors))
+	}
+
+	minerA := a.MinerErrors["0xa"]
+	if len(minerA.Blocks) != 2 || minerA.ErrorCounts.FailedFlashbotsTx != 3 || minerA.ErrorCounts.BundleHas0Fee != 1 {
+		t.Error("Wrong merged errors of miner a:", minerA.Blocks, minerA.ErrorCounts)
+	}
+
+	if a.MinerErrors["0xb"].ErrorCounts.Failed0GasTx != 1 || a.MinerErrors["0xb"].MinerName != "miner b" {
+		t.Error("Wrong merged errors of miner b:", a.MinerErrors["0xb"])
+	}
+
+	if !a.TimeStarted.Equal(b.TimeStarted) {
+		t.Error("Merged summary should start at the earliest time")
+	}
+}
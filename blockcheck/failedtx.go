@@ -3,9 +3,11 @@ package blockcheck
 
 // FailedTx contains information about a failed 0-gas or Flashbots tx
 type FailedTx struct {
-	Hash        string
-	IsFlashbots bool
-	From        string
-	To          string
-	Block       uint64
+	Hash        string `json:"hash"`
+	IsFlashbots bool   `json:"is_flashbots"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Block       uint64 `json:"block"`
+	BlockHash   string `json:"block_hash"`
+	Orphaned    bool   `json:"orphaned"` // block was reorged out
 }
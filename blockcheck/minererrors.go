@@ -1,5 +1,7 @@
 package blockcheck
 
+import "sort"
+
 type MinerErrors struct {
 	MinerHash string
 	MinerName string
@@ -18,3 +20,31 @@ func (ec *MinerErrors) AddErrorCounts(block int64, counts ErrorCounts) {
 	ec.ErrorCounts.Add(counts)
 	ec.Blocks[block] = true
 }
+
+// RemoveErrorCounts undoes AddErrorCounts (eg. when the block was reorged out)
+func (ec *MinerErrors) RemoveErrorCounts(block int64, counts ErrorCounts) {
+	if !ec.Blocks[block] {
+		return
+	}
+	ec.ErrorCounts.Sub(counts)
+	delete(ec.Blocks, block)
+}
+
+// Merge adds the errors of another MinerErrors. Blocks already counted here are skipped, but since the counts are not
+// kept per block, their errors are still added (merge only disjoint block ranges).
+func (ec *MinerErrors) Merge(other *MinerErrors) {
+	ec.ErrorCounts.Add(other.ErrorCounts)
+	for block := range other.Blocks {
+		ec.Blocks[block] = true
+	}
+}
+
+// BlockNumbers returns the sorted list of blocks with errors
+func (ec *MinerErrors) BlockNumbers() []int64 {
+	res := make([]int64, 0, len(ec.Blocks))
+	for block := range ec.Blocks {
+		res = append(res, block)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
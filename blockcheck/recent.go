@@ -0,0 +1,171 @@
+package blockcheck
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// CheckSummary is a compact, JSON-friendly representation of a BlockCheck with findings (used in webserver)
+type CheckSummary struct {
+	Number           int64       `json:"number"`
+	Hash             string      `json:"hash"`
+	Time             time.Time   `json:"time"`
+	Miner            string      `json:"miner"`
+	MinerName        string      `json:"miner_name"`
+	NumTx            int         `json:"num_tx"`
+	NumFlashbotsTx   int         `json:"num_flashbots_tx"`
+	NumBundles       int         `json:"num_bundles"`
+	HasSeriousErrors bool        `json:"has_serious_errors"`
+	Orphaned         bool        `json:"orphaned"` // block was reorged out after the check
+	Errors           []string    `json:"errors"`
+	ErrorCounts      ErrorCounts `json:"error_counts"`
+	FailedTx         []FailedTx  `json:"failed_tx"`
+}
+
+func (b *BlockCheck) Summary() CheckSummary {
+	summary := CheckSummary{
+		Number:           b.Number,
+		Hash:             b.EthBlock.Hash().Hex(),
+		Time:             time.Unix(int64(b.EthBlock.Time()), 0).UTC(),
+		Miner:            b.Miner,
+		MinerName:        b.MinerName,
+		NumTx:            len(b.EthBlock.Transactions()),
+		NumFlashbotsTx:   len(b.FlashbotsTransactions),
+		NumBundles:       len(b.Bundles),
+		HasSeriousErrors: b.HasSeriousErrors(),
+		Errors:           make([]string, len(b.Errors)),
+		ErrorCounts:      b.ErrorCounter,
+		FailedTx:         make([]FailedTx, 0, len(b.FailedTx)),
+	}
+
+	for i, err := range b.Errors {
+		summary.Errors[i] = strings.TrimSpace(err)
+	}
+
+	for _, tx := range b.FailedTx {
+		summary.FailedTx = append(summary.FailedTx, *tx)
+	}
+
+	return summary
+}
+
+// ring is a fixed-size buffer which overwrites the oldest entry when full
+type ring struct {
+	items []interface{}
+	next  int
+	full  bool
+}
+
+func newRing(size int) *ring {
+	if size < 1 {
+		size = 1
+	}
+	return &ring{items: make([]interface{}, size)}
+}
+
+func (r *ring) add(item interface{}) {
+	r.items[r.next] = item
+	r.next = (r.next + 1) % len(r.items)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns the entries, newest first
+func (r *ring) list() []interface{} {
+	n := r.next
+	if r.full {
+		n = len(r.items)
+	}
+
+	res := make([]interface{}, 0, n)
+	for i := 1; i <= n; i++ {
+		idx := (r.next - i + len(r.items)) % len(r.items)
+		res = append(res, r.items[idx])
+	}
+	return res
+}
+
+// RecentDetections keeps the most recent block checks with findings and failed transactions in memory.
+// It is bounded (oldest entries are dropped) and safe for concurrent use.
+type RecentDetections struct {
+	lock     sync.RWMutex
+	checks   *ring
+	failedTx *ring
+}
+
+func NewRecentDetections(size int) *RecentDetections {
+	return &RecentDetections{
+		checks:   newRing(size),
+		failedTx: newRing(size),
+	}
+}
+
+// AddCheck stores the summary of a check with findings, and all its failed transactions
+func (r *RecentDetections) AddCheck(check *BlockCheck) {
+	if !check.HasErrors() {
+		return
+	}
+
+	summary := check.Summary()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.checks.add(summary)
+	for _, tx := range summary.FailedTx {
+		r.failedTx.add(tx)
+	}
+}
+
+// Checks returns the recent checks with findings, newest first (optionally filtered by miner address)
+func (r *RecentDetections) Checks(miner string) []CheckSummary {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	res := make([]CheckSummary, 0)
+	for _, item := range r.checks.list() {
+		summary := item.(CheckSummary)
+		if miner == "" || strings.EqualFold(summary.Miner, miner) {
+			res = append(res, summary)
+		}
+	}
+	return res
+}
+
+// MarkOrphaned flags the check and failed transactions of a block that was reorged out
+func (r *RecentDetections) MarkOrphaned(blockHash string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i, item := range r.checks.items {
+		if summary, ok := item.(CheckSummary); ok && summary.Hash == blockHash {
+			// Copy, the FailedTx of returned summaries share the backing array
+			summary.Orphaned = true
+			summary.FailedTx = append([]FailedTx{}, summary.FailedTx...)
+			for j := range summary.FailedTx {
+				summary.FailedTx[j].Orphaned = true
+			}
+			r.checks.items[i] = summary
+		}
+	}
+
+	for i, item := range r.failedTx.items {
+		if tx, ok := item.(FailedTx); ok && tx.BlockHash == blockHash {
+			tx.Orphaned = true
+			r.failedTx.items[i] = tx
+		}
+	}
+}
+
+// FailedTx returns the recent failed Flashbots and 0-gas transactions, newest first
+func (r *RecentDetections) FailedTx() []FailedTx {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	res := make([]FailedTx, 0)
+	for _, item := range r.failedTx.list() {
+		res = append(res, item.(FailedTx))
+	}
+	return res
+}
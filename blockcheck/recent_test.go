@@ -0,0 +1,39 @@
+package blockcheck
+
+import "testing"
+
+func TestRing(t *testing.T) {
+	r := newRing(3)
+	if len(r.list()) != 0 {
+		t.Error("Should be empty, is", r.list())
+	}
+
+	r.add(1)
+	r.add(2)
+	items := r.list()
+	if len(items) != 2 || items[0] != 2 || items[1] != 1 {
+		t.Error("Wrong items:", items)
+	}
+
+	r.add(3)
+	r.add(4)
+	items = r.list()
+	if len(items) != 3 || items[0] != 4 || items[1] != 3 || items[2] != 2 {
+		t.Error("Wrong items after overflow:", items)
+	}
+}
+
+func TestMarkOrphaned(t *testing.T) {
+	r := NewRecentDetections(3)
+	r.checks.add(CheckSummary{Hash: "0x1", FailedTx: []FailedTx{{BlockHash: "0x1"}}})
+	returned := r.Checks("")
+
+	r.MarkOrphaned("0x1")
+	checks := r.Checks("")
+	if !checks[0].Orphaned || !checks[0].FailedTx[0].Orphaned {
+		t.Error("Check and failed tx should be orphaned:", checks[0])
+	}
+	if returned[0].FailedTx[0].Orphaned {
+		t.Error("Previously returned summary should not be changed")
+	}
+}
@@ -0,0 +1,94 @@
+package blockstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/metachris/flashbots/api"
+)
+
+// exportRecord is one line of an export file: the canonical block at a height, with receipts and mev-blocks API data
+// if stored
+type exportRecord struct {
+	Number    int64               `json:"number"`
+	Block     hexutil.Bytes       `json:"block,omitempty"` // rlp
+	Receipts  []*types.Receipt    `json:"receipts,omitempty"`
+	Flashbots *api.FlashbotsBlock `json:"flashbots,omitempty"` // without miner if there is no Flashbots block
+}
+
+// Export writes the data of the canonical blocks from startBlock to endBlock (inclusive) as JSON lines, and returns the
+// number of written heights. Heights without any stored data are skipped.
+func (s *Store) Export(w io.Writer, startBlock int64, endBlock int64) (numRecords int, err error) {
+	enc := json.NewEncoder(w)
+	for number := startBlock; number <= endBlock; number++ {
+		record := exportRecord{Number: number}
+
+		block, err := s.BlockByNumber(number)
+		if err == nil {
+			if record.Block, err = rlp.EncodeToBytes(block); err != nil {
+				return numRecords, err
+			}
+			if record.Receipts, err = s.Receipts(block.Hash()); err != nil && !errors.Is(err, ErrNotFound) {
+				return numRecords, err
+			}
+		} else if !errors.Is(err, ErrNotFound) {
+			return numRecords, err
+		}
+
+		flashbotsBlock, _, err := s.FlashbotsBlock(number)
+		if err == nil {
+			record.Flashbots = &flashbotsBlock
+		} else if !errors.Is(err, ErrNotFound) {
+			return numRecords, err
+		}
+
+		if record.Block == nil && record.Flashbots == nil {
+			continue
+		}
+		if err := enc.Encode(record); err != nil {
+			return numRecords, err
+		}
+		numRecords += 1
+	}
+	return numRecords, nil
+}
+
+// Import reads an export file into the store, and returns the number of imported heights
+func (s *Store) Import(r io.Reader) (numRecords int, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 256*1024*1024) // blocks with receipts can be large
+	for scanner.Scan() {
+		var record exportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return numRecords, err
+		}
+
+		if record.Block != nil {
+			block := new(types.Block)
+			if err := rlp.DecodeBytes(record.Block, block); err != nil {
+				return numRecords, err
+			}
+			if record.Receipts != nil {
+				if err := s.PutReceipts(block.Hash(), record.Receipts); err != nil {
+					return numRecords, err
+				}
+			}
+			if err := s.PutBlock(block, true); err != nil {
+				return numRecords, err
+			}
+		}
+
+		if record.Flashbots != nil {
+			if err := s.PutFlashbotsBlocks(record.Number, record.Number, []api.FlashbotsBlock{*record.Flashbots}); err != nil {
+				return numRecords, err
+			}
+		}
+		numRecords += 1
+	}
+	return numRecords, scanner.Err()
+}
@@ -0,0 +1,148 @@
+package blockstore
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/flashbots/api"
+	"github.com/metachris/flashbots/blockcheck"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+// Blocks younger than this may still be reorged, and are not indexed by height
+const FinalityAge = 10 * time.Minute
+
+// Client is the part of ethclient.Client used to fetch blocks missing in the store
+type Client interface {
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+	BlockByHash(ctx context.Context, hash ethcommon.Hash) (*types.Block, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// Reader reads from the store first, and fetches (and stores) what is missing. Without Client and FetchWithReceipts it
+// only reads from the store (offline mode), and missing data is an ErrNotFound error.
+type Reader struct {
+	Store             *Store
+	Client            Client
+	FetchWithReceipts func(ctx context.Context, height int64) (*blockswithtx.BlockWithTxReceipts, error)
+}
+
+func isFinal(block *types.Block) bool {
+	return time.Since(time.Unix(int64(block.Time()), 0)) > FinalityAge
+}
+
+// BlockByNumber implements common.BlockByNumberReader
+func (r *Reader) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	if number != nil {
+		block, err := r.Store.BlockByNumber(number.Int64())
+		if !errors.Is(err, ErrNotFound) || r.Client == nil {
+			return block, err
+		}
+	} else if r.Client == nil {
+		latest, err := r.Store.Latest()
+		if err != nil {
+			return nil, err
+		}
+		return r.Store.BlockByNumber(latest)
+	}
+
+	block, err := r.Client.BlockByNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	if isFinal(block) {
+		if err := r.Store.PutBlock(block, true); err != nil {
+			return nil, err
+		}
+	}
+	return block, nil
+}
+
+// BlockByHash returns any stored block (also uncles), blocks fetched by hash are not indexed by height
+func (r *Reader) BlockByHash(ctx context.Context, hash ethcommon.Hash) (*types.Block, error) {
+	block, err := r.Store.BlockByHash(hash)
+	if !errors.Is(err, ErrNotFound) || r.Client == nil {
+		return block, err
+	}
+
+	block, err = r.Client.BlockByHash(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	return block, r.Store.PutBlock(block, false)
+}
+
+// HeaderByNumber implements common.HeaderByNumberReader. Headers are not stored, only read from stored blocks.
+func (r *Reader) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if r.Client == nil {
+		return r.Store.HeaderByNumber(ctx, number)
+	}
+	if number != nil {
+		if block, err := r.Store.BlockByNumber(number.Int64()); err == nil {
+			return block.Header(), nil
+		}
+	}
+	return r.Client.HeaderByNumber(ctx, number)
+}
+
+// GetBlockWithTxReceipts returns the canonical block at the given height with its receipts
+func (r *Reader) GetBlockWithTxReceipts(ctx context.Context, height int64) (*blockswithtx.BlockWithTxReceipts, error) {
+	block, err := r.Store.BlockWithTxReceipts(height)
+	if !errors.Is(err, ErrNotFound) || r.FetchWithReceipts == nil {
+		return block, err
+	}
+
+	block, err = r.FetchWithReceipts(ctx, height)
+	if err != nil {
+		return nil, err
+	}
+	if isFinal(block.Block) {
+		if err := r.Store.PutBlockWithTxReceipts(block); err != nil {
+			return nil, err
+		}
+	}
+	return block, nil
+}
+
+// CacheFlashbotsBlocks is like blockcheck.CacheFlashbotsBlocks, but uses the mev-blocks API data in the store if it
+// covers the whole range, and else stores the API response (up to the latest block of the API)
+func (r *Reader) CacheFlashbotsBlocks(startBlock int64, endBlock int64) error {
+	blocks, complete, err := r.Store.FlashbotsBlocks(startBlock, endBlock)
+	if err != nil {
+		return err
+	}
+
+	if !complete {
+		// Only heights the API already covers are stored, later ones may still get a Flashbots block
+		cachedUntil, err := blockcheck.CacheAvailableFlashbotsBlocks(startBlock, endBlock)
+		if err != nil {
+			return err
+		}
+
+		if cachedUntil >= startBlock {
+			blocks = make([]api.FlashbotsBlock, 0)
+			for number := startBlock; number <= cachedUntil; number++ {
+				if block, found := blockcheck.FlashbotsBlockCache[number]; found {
+					blocks = append(blocks, block)
+				}
+			}
+			if err := r.Store.PutFlashbotsBlocks(startBlock, cachedUntil, blocks); err != nil {
+				return err
+			}
+		}
+
+		if cachedUntil < endBlock {
+			return blockcheck.ErrFlashbotsApiDoesntHaveThatBlockYet
+		}
+		return nil
+	}
+
+	for _, block := range blocks {
+		blockcheck.FlashbotsBlockCache[block.BlockNumber] = block
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+// Package blockstore keeps blocks, receipts and mev-blocks API data in a local LevelDB database, so that repeated
+// analysis of historical block ranges does not download the same data again (and can run offline).
+package blockstore
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/metachris/flashbots/api"
+	"github.com/metachris/go-ethutils/blockswithtx"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var ErrNotFound = errors.New("not found in block store")
+
+// Key prefixes
+var (
+	prefixCanonical = []byte("h") // + number -> block hash
+	prefixBlock     = []byte("b") // + block hash -> block rlp
+	prefixReceipts  = []byte("r") // + block hash -> receipts json
+	prefixFlashbots = []byte("f") // + number -> mev-blocks api block json
+)
+
+func numberKey(prefix []byte, number int64) []byte {
+	key := make([]byte, len(prefix)+8)
+	copy(key, prefix)
+	binary.BigEndian.PutUint64(key[len(prefix):], uint64(number))
+	return key
+}
+
+func hashKey(prefix []byte, hash ethcommon.Hash) []byte {
+	return append(append([]byte{}, prefix...), hash.Bytes()...)
+}
+
+// Store keeps blocks by hash, with an index of the canonical block hashes by height. Receipts are stored per block
+// hash, mev-blocks API data by height. Safe for concurrent use.
+type Store struct {
+	db *leveldb.DB
+}
+
+// Open opens the store at the given path, and creates it if it doesn't exist
+func Open(path string) (*Store, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) get(key []byte) ([]byte, error) {
+	value, err := s.db.Get(key, nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+// PutBlock stores the block, and if canonical also indexes it by height
+func (s *Store) PutBlock(block *types.Block, canonical bool) error {
+	data, err := rlp.EncodeToBytes(block)
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Put(hashKey(prefixBlock, block.Hash()), data)
+	if canonical {
+		batch.Put(numberKey(prefixCanonical, block.Number().Int64()), block.Hash().Bytes())
+	}
+	return s.db.Write(batch, nil)
+}
+
+func (s *Store) BlockByHash(hash ethcommon.Hash) (*types.Block, error) {
+	data, err := s.get(hashKey(prefixBlock, hash))
+	if err != nil {
+		return nil, err
+	}
+
+	block := new(types.Block)
+	if err := rlp.DecodeBytes(data, block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// CanonicalHash returns the hash of the canonical block at the given height
+func (s *Store) CanonicalHash(number int64) (ethcommon.Hash, error) {
+	data, err := s.get(numberKey(prefixCanonical, number))
+	if err != nil {
+		return ethcommon.Hash{}, err
+	}
+	return ethcommon.BytesToHash(data), nil
+}
+
+func (s *Store) BlockByNumber(number int64) (*types.Block, error) {
+	hash, err := s.CanonicalHash(number)
+	if err != nil {
+		return nil, err
+	}
+	return s.BlockByHash(hash)
+}
+
+// Latest returns the height of the highest canonical block in the store
+func (s *Store) Latest() (int64, error) {
+	iter := s.db.NewIterator(util.BytesPrefix(prefixCanonical), nil)
+	defer iter.Release()
+	if !iter.Last() {
+		return 0, ErrNotFound
+	}
+	return int64(binary.BigEndian.Uint64(iter.Key()[len(prefixCanonical):])), iter.Error()
+}
+
+// HeaderByNumber implements common.HeaderByNumberReader with the canonical blocks in the store (nil = highest block),
+// to resolve block ranges offline
+func (s *Store) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	var height int64
+	if number == nil {
+		latest, err := s.Latest()
+		if err != nil {
+			return nil, err
+		}
+		height = latest
+	} else {
+		height = number.Int64()
+	}
+
+	block, err := s.BlockByNumber(height)
+	if err != nil {
+		return nil, err
+	}
+	return block.Header(), nil
+}
+
+func (s *Store) PutReceipts(blockHash ethcommon.Hash, receipts []*types.Receipt) error {
+	data, err := json.Marshal(receipts)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(hashKey(prefixReceipts, blockHash), data, nil)
+}
+
+func (s *Store) Receipts(blockHash ethcommon.Hash) (receipts []*types.Receipt, err error) {
+	data, err := s.get(hashKey(prefixReceipts, blockHash))
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &receipts)
+	return receipts, err
+}
+
+// PutBlockWithTxReceipts stores a canonical block with its receipts
+func (s *Store) PutBlockWithTxReceipts(block *blockswithtx.BlockWithTxReceipts) error {
+	receipts := make([]*types.Receipt, 0, len(block.TxReceipts))
+	for _, tx := range block.Block.Transactions() {
+		if receipt, found := block.TxReceipts[tx.Hash()]; found {
+			receipts = append(receipts, receipt)
+		}
+	}
+
+	// Receipts first, so a block is only found with receipts
+	if err := s.PutReceipts(block.Block.Hash(), receipts); err != nil {
+		return err
+	}
+	return s.PutBlock(block.Block, true)
+}
+
+// BlockWithTxReceipts returns the canonical block at the given height with its receipts
+func (s *Store) BlockWithTxReceipts(number int64) (*blockswithtx.BlockWithTxReceipts, error) {
+	block, err := s.BlockByNumber(number)
+	if err != nil {
+		return nil, err
+	}
+
+	receipts, err := s.Receipts(block.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	res := &blockswithtx.BlockWithTxReceipts{Block: block, TxReceipts: make(map[ethcommon.Hash]*types.Receipt)}
+	for _, receipt := range receipts {
+		res.TxReceipts[receipt.TxHash] = receipt
+	}
+	return res, nil
+}
+
+// PutFlashbotsBlocks stores the mev-blocks API data of a block range. Heights without Flashbots block are stored as
+// well (as block without miner), so later lookups know there is none. The range must not go past the latest block of
+// the API, which may still add Flashbots blocks there.
+func (s *Store) PutFlashbotsBlocks(startBlock int64, endBlock int64, blocks []api.FlashbotsBlock) error {
+	byNumber := make(map[int64]api.FlashbotsBlock)
+	for _, block := range blocks {
+		byNumber[block.BlockNumber] = block
+	}
+
+	batch := new(leveldb.Batch)
+	for number := startBlock; number <= endBlock; number++ {
+		block, found := byNumber[number]
+		if !found {
+			block = api.FlashbotsBlock{BlockNumber: number}
+		}
+		data, err := json.Marshal(block)
+		if err != nil {
+			return err
+		}
+		batch.Put(numberKey(prefixFlashbots, number), data)
+	}
+	return s.db.Write(batch, nil)
+}
+
+// FlashbotsBlock returns the stored mev-blocks API data of a height, with isFlashbotsBlock false if the API has no
+// block at this height. Returns ErrNotFound if the height was never stored.
+func (s *Store) FlashbotsBlock(number int64) (block api.FlashbotsBlock, isFlashbotsBlock bool, err error) {
+	data, err := s.get(numberKey(prefixFlashbots, number))
+	if err != nil {
+		return block, false, err
+	}
+	err = json.Unmarshal(data, &block)
+	return block, block.Miner != "", err
+}
+
+// FlashbotsBlocks returns the stored Flashbots blocks of a range, and whether all heights of the range are stored
+func (s *Store) FlashbotsBlocks(startBlock int64, endBlock int64) (blocks []api.FlashbotsBlock, complete bool, err error) {
+	iter := s.db.NewIterator(&util.Range{Start: numberKey(prefixFlashbots, startBlock), Limit: numberKey(prefixFlashbots, endBlock+1)}, nil)
+	defer iter.Release()
+
+	numHeights := int64(0)
+	for iter.Next() {
+		numHeights += 1
+		var block api.FlashbotsBlock
+		if err := json.Unmarshal(iter.Value(), &block); err != nil {
+			return nil, false, err
+		}
+		if block.Miner != "" {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks, numHeights == endBlock-startBlock+1, iter.Error()
+}
@@ -0,0 +1,119 @@
+package blockstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/metachris/flashbots/api"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+func newTestStore(t *testing.T) *Store {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func newTestBlock(number int64, timestamp time.Time) *blockswithtx.BlockWithTxReceipts {
+	tx := types.NewTransaction(uint64(number), ethcommon.Address{1}, big.NewInt(1), 21000, big.NewInt(1), nil)
+	receipt := &types.Receipt{Status: 1, CumulativeGasUsed: 21000, GasUsed: 21000, TxHash: tx.Hash(), Logs: []*types.Log{}}
+	header := &types.Header{Number: big.NewInt(number), Difficulty: big.NewInt(1), Time: uint64(timestamp.Unix())}
+	block := types.NewBlock(header, []*types.Transaction{tx}, nil, []*types.Receipt{receipt}, trie.NewStackTrie(nil))
+	receipt.BlockHash = block.Hash()
+	receipt.BlockNumber = block.Number()
+	return &blockswithtx.BlockWithTxReceipts{Block: block, TxReceipts: map[ethcommon.Hash]*types.Receipt{tx.Hash(): receipt}}
+}
+
+func TestStoreExportImport(t *testing.T) {
+	store := newTestStore(t)
+	oldTime := time.Now().Add(-time.Hour)
+	for number := int64(10); number <= 12; number++ {
+		if err := store.PutBlockWithTxReceipts(newTestBlock(number, oldTime)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flashbotsBlock := api.FlashbotsBlock{BlockNumber: 11, Miner: "0x1", MinerReward: "1000"}
+	if err := store.PutFlashbotsBlocks(10, 12, []api.FlashbotsBlock{flashbotsBlock}); err != nil {
+		t.Fatal(err)
+	}
+
+	if latest, err := store.Latest(); err != nil || latest != 12 {
+		t.Errorf("expected latest 12, got %d (%v)", latest, err)
+	}
+	if _, complete, _ := store.FlashbotsBlocks(9, 12); complete {
+		t.Error("flashbots blocks 9 ... 12 should be incomplete")
+	}
+
+	var buf bytes.Buffer
+	if numRecords, err := store.Export(&buf, 9, 12); err != nil || numRecords != 3 {
+		t.Fatalf("expected 3 exported records, got %d (%v)", numRecords, err)
+	}
+
+	imported := newTestStore(t)
+	if numRecords, err := imported.Import(&buf); err != nil || numRecords != 3 {
+		t.Fatalf("expected 3 imported records, got %d (%v)", numRecords, err)
+	}
+
+	for number := int64(10); number <= 12; number++ {
+		expected, _ := store.BlockWithTxReceipts(number)
+		block, err := imported.BlockWithTxReceipts(number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.Block.Hash() != expected.Block.Hash() || len(block.TxReceipts) != 1 {
+			t.Errorf("block %d differs after import", number)
+		}
+		for hash, receipt := range block.TxReceipts {
+			if receipt.GasUsed != expected.TxReceipts[hash].GasUsed || receipt.BlockHash != expected.Block.Hash() {
+				t.Errorf("receipt %s differs after import", hash)
+			}
+		}
+	}
+
+	blocks, complete, err := imported.FlashbotsBlocks(10, 12)
+	if err != nil || !complete || len(blocks) != 1 || blocks[0].MinerReward != "1000" {
+		t.Errorf("unexpected flashbots blocks after import: %v complete=%v (%v)", blocks, complete, err)
+	}
+}
+
+func TestReaderFetchThrough(t *testing.T) {
+	store := newTestStore(t)
+	numFetches := 0
+	reader := &Reader{Store: store, FetchWithReceipts: func(ctx context.Context, height int64) (*blockswithtx.BlockWithTxReceipts, error) {
+		numFetches += 1
+		if height == 2 {
+			return newTestBlock(height, time.Now()), nil // too recent to be stored
+		}
+		return newTestBlock(height, time.Now().Add(-time.Hour)), nil
+	}}
+
+	for i := 0; i < 2; i++ {
+		for height := int64(1); height <= 2; height++ {
+			if _, err := reader.GetBlockWithTxReceipts(context.Background(), height); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if numFetches != 3 {
+		t.Errorf("expected 3 fetches, got %d", numFetches)
+	}
+
+	// Offline
+	offline := &Reader{Store: store}
+	if _, err := offline.GetBlockWithTxReceipts(context.Background(), 1); err != nil {
+		t.Error(err)
+	}
+	if _, err := offline.GetBlockWithTxReceipts(context.Background(), 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
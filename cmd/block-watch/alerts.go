@@ -0,0 +1,149 @@
+// Alerts for block checks and error summaries, delivered by the configured notifiers
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/metachris/flashbots/blockcheck"
+	"github.com/metachris/flashbots/common"
+	"github.com/metachris/flashbots/notify"
+)
+
+var notifier = notify.NewDispatcher()
+
+func init() {
+	notifier.OnError = func(name string, err error) {
+		log.Printf("alert delivery error (%s): %s\n", name, err)
+		metricAlertDeliveryFailures.WithLabelValues(name).Inc()
+	}
+}
+
+// checkSeverity returns critical for serious errors, warning for less serious errors, and info otherwise
+func checkSeverity(check *blockcheck.BlockCheck) notify.Severity {
+	if check.HasSeriousErrors() {
+		return notify.SeverityCritical
+	} else if check.HasLessSeriousErrors() {
+		return notify.SeverityWarning
+	}
+	return notify.SeverityInfo
+}
+
+func checkFindingTypes(check *blockcheck.BlockCheck) []string {
+	res := make([]string, 0)
+	counts := check.ErrorCounter.ByType()
+	for _, findingType := range blockcheck.FindingTypes {
+		if counts[findingType] > 0 {
+			res = append(res, findingType)
+		}
+	}
+	return res
+}
+
+// bundleField describes a bundle in one message field
+func bundleField(bundle *common.Bundle) notify.Field {
+	name := fmt.Sprintf("Bundle %d", bundle.Index)
+	if bundle.IsOutOfOrder || bundle.IsPayingLessThanLowestTx || bundle.Is0EffectiveGasPrice || bundle.IsNegativeEffectiveGasPrice {
+		name += " ⚠️"
+	}
+
+	value := fmt.Sprintf("tx: %d, gasUsed: %d\ncoinbase_transfer: %s\ntotal_miner_reward: %s\ncoinbase/gasused: %s\nreward/gasused: %s", len(bundle.Transactions), bundle.TotalGasUsed, common.BigIntToEString(bundle.TotalCoinbaseTransfer, 4), common.BigIntToEString(bundle.TotalMinerReward, 4), common.BigIntToEString(bundle.CoinbaseDivGasUsed, 4), common.BigIntToEString(bundle.RewardDivGasUsed, 4))
+	if bundle.PercentPriceDiff.Sign() == 1 {
+		value += fmt.Sprintf(" (+%s%%)", bundle.PercentPriceDiff.Text('f', 2))
+	} else if bundle.PercentPriceDiff.Sign() == -1 {
+		value += fmt.Sprintf(" (%s%%)", bundle.PercentPriceDiff.Text('f', 2))
+	}
+	return notify.Field{Name: name, Value: value}
+}
+
+func checkToMessage(check *blockcheck.BlockCheck) notify.Message {
+	minerId := check.Miner
+	if check.MinerName != "" {
+		minerId = check.MinerName
+	}
+
+	msg := notify.Message{
+		Title:        fmt.Sprintf("Block %d - miner %s", check.Number, minerId),
+		Url:          fmt.Sprintf("https://etherscan.io/block/%d", check.Number),
+		Severity:     checkSeverity(check),
+		FindingTypes: checkFindingTypes(check),
+		Block:        check.Number,
+		BlockHash:    check.EthBlock.Hash().Hex(),
+		Miner:        check.Miner,
+		MinerName:    check.MinerName,
+	}
+
+	if len(check.Errors) == 1 && check.HasBundleWith0EffectiveGasPrice {
+		// Short message if only 1 error and that is a 0-effective-gas-price
+		msg.Text = check.SprintHeader(false, true) + " - Error: " + check.Errors[0]
+		return msg
+	}
+
+	msg.Text = check.Sprint(false, true, false)
+	if !check.TriggerAlertOnFailedTx { // failed tx alerts don't need the bundle details
+		for _, bundle := range check.Bundles {
+			msg.Fields = append(msg.Fields, bundleField(bundle))
+		}
+	}
+	return msg
+}
+
+// alertManager deduplicates and groups check alerts before they are sent to the notifiers
+var alertManager *notify.AlertManager
+
+// handleCheckAlerts passes serious errors and failed transactions to the alert manager, and reports blocks without
+// them as clean (which eventually resolves an open incident of the miner)
+func handleCheckAlerts(check *blockcheck.BlockCheck) {
+	if alertManager == nil {
+		return
+	}
+
+	if check.HasSeriousErrors() || check.TriggerAlertOnFailedTx {
+		alertManager.Alert(checkToMessage(check)) // delivery errors are handled by notifier.OnError
+	} else {
+		alertManager.Clean(check.Miner, check.Number)
+	}
+
+	// Send grouped alerts of other miners whose group interval has passed
+	alertManager.Flush()
+}
+
+// retractCheckAlert notifies that a block with an alert was reorged out (same routes as the original alert), through
+// the alert manager
+func retractCheckAlert(check *blockcheck.BlockCheck) {
+	if alertManager == nil {
+		return
+	}
+
+	if !check.HasSeriousErrors() && !check.TriggerAlertOnFailedTx {
+		return
+	}
+
+	original := checkToMessage(check)
+	alertManager.Retract(notify.Message{ // delivery errors are handled by notifier.OnError
+		Title:        "Retracted: " + original.Title,
+		Url:          original.Url,
+		Text:         fmt.Sprintf("Block %d %s was reorged out, its findings are no longer relevant.", check.Number, check.EthBlock.Hash()),
+		Severity:     original.Severity,
+		FindingTypes: original.FindingTypes,
+		Block:        original.Block,
+		BlockHash:    original.BlockHash,
+		Miner:        original.Miner,
+		MinerName:    original.MinerName,
+	})
+}
+
+// sendSummary sends a daily or weekly miner error summary to the notifiers
+func sendSummary(title string, summary string) {
+	if summary == "" {
+		return
+	}
+
+	fmt.Println(summary)
+	notifier.Send(notify.Message{
+		Title:        title,
+		Text:         "```" + summary + "```",
+		Severity:     notify.SeverityInfo,
+		FindingTypes: []string{notify.TypeSummary},
+	})
+}
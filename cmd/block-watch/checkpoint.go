@@ -0,0 +1,110 @@
+// Checkpoints of the watch state (-state), saved periodically and on shutdown, and restored on start
+package main
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/metachris/flashbots/blockcheck"
+	"github.com/metachris/flashbots/common"
+	"github.com/metachris/flashbots/nodepool"
+	"github.com/metachris/flashbots/watcher"
+)
+
+const checkpointInterval = time.Minute
+
+var stateFile string
+
+type watchState struct {
+	SavedAt             time.Time               `json:"saved_at"`
+	LastBlock           watcher.BlockRef        `json:"last_block"` // latest block added to the backlog
+	LastProcessedHeight int64                   `json:"last_processed_height"`
+	Backlog             []watcher.BlockRef      `json:"backlog"`
+	DailyErrorSummary   blockcheck.ErrorSummary `json:"daily_error_summary"`
+	WeeklyErrorSummary  blockcheck.ErrorSummary `json:"weekly_error_summary"`
+}
+
+// Height of the latest checked block (protected by checkedBlocksLock)
+var lastProcessedHeight int64
+
+func saveCheckpoint() {
+	if stateFile == "" {
+		return
+	}
+
+	state := watchState{
+		SavedAt:   time.Now().UTC(),
+		LastBlock: chainTracker.HeadBlock(),
+		Backlog:   blockBacklog.Refs(),
+	}
+
+	checkedBlocksLock.Lock()
+	state.LastProcessedHeight = lastProcessedHeight
+	checkedBlocksLock.Unlock()
+
+	summaryLock.RLock()
+	state.DailyErrorSummary = dailyErrorSummary
+	state.WeeklyErrorSummary = weeklyErrorSummary
+	err := common.WriteJsonFile(stateFile, state)
+	summaryLock.RUnlock()
+
+	if err != nil {
+		log.Println("Error saving checkpoint:", err)
+	}
+}
+
+// restoreCheckpoint restores the summaries and the backlog, and sets the chain tracker head to the last block, so
+// that blocks produced while block-watch was down are backfilled with the first new header
+func restoreCheckpoint(pool *nodepool.Pool) {
+	if stateFile == "" {
+		return
+	}
+
+	var state watchState
+	err := common.ReadJsonFile(stateFile, &state)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Fatal("Error reading checkpoint: ", err)
+	}
+
+	log.Printf("Restoring checkpoint from %s: last block %s, last processed %d, %d blocks in backlog\n", state.SavedAt.Format(time.RFC3339), state.LastBlock, state.LastProcessedHeight, len(state.Backlog))
+
+	summaryLock.Lock()
+	if state.DailyErrorSummary.MinerErrors != nil {
+		dailyErrorSummary = state.DailyErrorSummary
+	}
+	if state.WeeklyErrorSummary.MinerErrors != nil {
+		weeklyErrorSummary = state.WeeklyErrorSummary
+	}
+	summaryLock.Unlock()
+
+	lastProcessedHeight = state.LastProcessedHeight
+	for _, block := range state.Backlog {
+		queueBlock(pool, canonicalBlock(pool, block))
+	}
+
+	if state.LastBlock.Number > 0 {
+		chainTracker.Restore(state.LastBlock)
+	}
+}
+
+// canonicalBlock returns the current canonical block at the height of a restored block, which may have been
+// reorged out while block-watch was down
+func canonicalBlock(pool *nodepool.Pool, block watcher.BlockRef) watcher.BlockRef {
+	header, err := pool.HeaderByNumber(context.Background(), big.NewInt(block.Number))
+	if err != nil {
+		log.Printf("Error verifying restored block %s: %s\n", block, err)
+		return block
+	}
+
+	if header.Hash() != block.Hash {
+		canonical := watcher.BlockRef{Number: block.Number, Hash: header.Hash()}
+		log.Printf("Restored block %s was reorged out, using %s\n", block, canonical)
+		return canonical
+	}
+	return block
+}
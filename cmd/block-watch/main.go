@@ -12,35 +12,48 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"time"
+	"os/signal"
+	"sync"
+	"syscall"
 
-	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/metachris/flashbots/api"
 	"github.com/metachris/flashbots/blockcheck"
+	"github.com/metachris/flashbots/nodepool"
+	"github.com/metachris/flashbots/notify"
+	"github.com/metachris/flashbots/resultsdb"
 	"github.com/metachris/go-ethutils/blockswithtx"
 	"github.com/metachris/go-ethutils/utils"
-	"github.com/pkg/errors"
 )
 
 var silent bool
-var sendErrorsToDiscord bool
-
-// Backlog of new blocks that are not yet present in the mev-blocks API (it has ~5 blocks delay)
-var BlockBacklog map[int64]*blockswithtx.BlockWithTxReceipts = make(map[int64]*blockswithtx.BlockWithTxReceipts)
 
 var dailyErrorSummary blockcheck.ErrorSummary = blockcheck.NewErrorSummary()
 var weeklyErrorSummary blockcheck.ErrorSummary = blockcheck.NewErrorSummary()
+var summaryLock sync.RWMutex // protects the error summaries (read by the webserver)
+
+var resultsDb *resultsdb.DB // optional, stores all check results
 
 func main() {
 	log.SetOutput(os.Stdout)
 
-	ethUri := flag.String("eth", os.Getenv("ETH_NODE"), "Ethereum node URI")
+	ethUri := flag.String("eth", os.Getenv("ETH_NODE"), "Ethereum node URI (or several, comma separated, for failover)")
+	ethCrossCheckPtr := flag.Bool("eth-crosscheck", false, "cross-check block hashes between the eth nodes")
 	// recentBundleOrdersPtr := flag.Bool("recentBundleOrder", false, "check recent bundle orders blocks")
 	blockHeightPtr := flag.Int64("block", 0, "specific block to check")
 	watchPtr := flag.Bool("watch", false, "watch and process new blocks")
 	silentPtr := flag.Bool("silent", false, "don't print info about every block")
-	discordPtr := flag.Bool("discord", false, "send errors to Discord")
+	discordPtr := flag.Bool("discord", false, "send errors to Discord (DISCORD_WEBHOOK env var)")
+	notifyConfigPtr := flag.String("notify", "", "notifier config file (JSON)")
+	alertConfig := notify.DefaultAlertManagerConfig()
+	flag.DurationVar(&alertConfig.GroupInterval, "alert-group-interval", alertConfig.GroupInterval, "send further alerts of a miner with an open incident at most once per interval")
+	flag.IntVar(&alertConfig.ResolveAfterBlocks, "alert-resolve-blocks", alertConfig.ResolveAfterBlocks, "resolve a miner incident after this many clean blocks")
+	flag.IntVar(&alertConfig.MinerRateLimit, "alert-miner-limit", alertConfig.MinerRateLimit, "max alerts per miner and hour (0 = unlimited)")
+	flag.IntVar(&alertConfig.GlobalRateLimit, "alert-global-limit", alertConfig.GlobalRateLimit, "max alerts per hour (0 = unlimited)")
+	metricsAddrPtr := flag.String("metrics", "", "serve Prometheus metrics at this address (eg. localhost:9090)")
+	flag.DurationVar(&backlogMaxWait, "backlog-max-wait", backlogMaxWait, "drop blocks waiting longer than this for the mev-blocks API (0 = no limit)")
+	flag.DurationVar(&pollInterval, "poll-interval", pollInterval, "poll for new blocks at this interval if the node does not support subscriptions (eg. HTTP)")
+	flag.StringVar(&stateFile, "state", "", "save a checkpoint to this file (periodically and on shutdown), and resume from it on start")
+	resultsDbPath := flag.String("results-db", "", "store the results of all checked blocks in this SQLite database")
+	serveAddrPtr := flag.String("serve", "", "watch and serve recent detections at this address (eg. localhost:8080)")
 	flag.Parse()
 
 	silent = *silentPtr
@@ -49,7 +62,31 @@ func main() {
 		if len(os.Getenv("DISCORD_WEBHOOK")) == 0 {
 			log.Fatal("No DISCORD_WEBHOOK environment variable found!")
 		}
-		sendErrorsToDiscord = true
+		notifier.AddRoute(&notify.Route{
+			Notifier:    notify.NewDiscordNotifier("discord", os.Getenv("DISCORD_WEBHOOK")),
+			MinSeverity: notify.SeverityInfo,
+		})
+	}
+
+	if *notifyConfigPtr != "" {
+		config, err := notify.LoadConfig(*notifyConfigPtr)
+		utils.Perror(err)
+		dispatcher, err := notify.NewDispatcherFromConfig(config)
+		utils.Perror(err)
+		for _, route := range dispatcher.Routes {
+			notifier.AddRoute(route)
+		}
+	}
+
+	if notifier.HasRoutes() {
+		alertManager = notify.NewAlertManager(notifier, alertConfig)
+	}
+
+	if *resultsDbPath != "" {
+		db, err := resultsdb.Open(*resultsDbPath)
+		utils.Perror(err)
+		defer db.Close()
+		resultsDb = db
 	}
 
 	// Connect to the geth node and start the BlockCheckService
@@ -57,14 +94,23 @@ func main() {
 		log.Fatal("Pass a valid eth node with -eth argument or ETH_NODE env var.")
 	}
 
-	fmt.Printf("Connecting to %s ...", *ethUri)
-	client, err := ethclient.Dial(*ethUri)
+	uris := nodepool.ParseUris(*ethUri)
+	fmt.Printf("Connecting to %d eth node(s) ...", len(uris))
+	pool, err := nodepool.Dial(uris)
 	utils.Perror(err)
 	fmt.Printf(" ok\n")
 
+	if *ethCrossCheckPtr {
+		pool.CrossCheckConfirmations = 2
+	}
+
 	if *blockHeightPtr != 0 {
 		// get block with receipts
-		block, err := blockswithtx.GetBlockWithTxReceipts(client, *blockHeightPtr)
+		var block *blockswithtx.BlockWithTxReceipts
+		err := pool.DoNode(context.Background(), func(node *nodepool.Node) (err error) {
+			block, err = node.Fetcher.GetBlockWithTxReceipts(context.Background(), *blockHeightPtr)
+			return err
+		})
 		utils.Perror(err)
 
 		// check the block
@@ -76,163 +122,25 @@ func main() {
 		print(msg)
 	}
 
-	if *watchPtr {
-		log.Println("Start watching...")
-		watch(client)
-	}
-}
-
-func watch(client *ethclient.Client) {
-	headers := make(chan *types.Header)
-	sub, err := client.SubscribeNewHead(context.Background(), headers)
-	utils.Perror(err)
+	if *watchPtr || *serveAddrPtr != "" {
+		if *metricsAddrPtr != "" {
+			startMetricsServer(*metricsAddrPtr)
+		}
 
-	var errorCountSerious int
-	var errorCountNonSerious int
-
-	for {
-		select {
-		case err := <-sub.Err():
-			log.Fatal(err)
-		case header := <-headers:
-			// New block header received. Download block with tx-receipts
-			b, err := blockswithtx.GetBlockWithTxReceipts(client, header.Number.Int64())
-			if err != nil {
-				err = errors.Wrap(err, "error in GetBlockWithTxReceipts")
-				log.Printf("%+v\n", err)
-				continue
-			}
-
-			if !silent {
-				fmt.Println("Queueing new block", b.Block.Number())
-			}
-
-			// Add to backlog, because it can only be processed when the Flashbots API has caught up
-			BlockBacklog[header.Number.Int64()] = b
-
-			// Query flashbots API to get latest block it has processed
-			opts := api.GetBlocksOptions{BlockNumber: header.Number.Int64()}
-			flashbotsResponse, err := api.GetBlocks(&opts)
-			if err != nil {
-				log.Println("Flashbots API error:", err)
-				continue
-			}
-
-			// Go through block-backlog, and process those within Flashbots API range
-			for height, blockFromBacklog := range BlockBacklog {
-				if height <= flashbotsResponse.LatestBlockNumber {
-					if !silent {
-						utils.PrintBlock(blockFromBacklog.Block)
-					}
-
-					check, err := blockcheck.CheckBlock(blockFromBacklog, false)
-					if err != nil {
-						log.Println("CheckBlock from backlog error:", err, "block:", blockFromBacklog.Block.Number())
-						break
-					}
-
-					// no checking error, can process and remove from backlog
-					delete(BlockBacklog, blockFromBacklog.Block.Number().Int64())
-
-					// Handle errors in the bundle (print, Discord, etc.)
-					if check.HasErrors() {
-						if check.HasSeriousErrors() { // only serious errors are printed and sent to Discord
-							errorCountSerious += 1
-							msg := check.Sprint(true, false, true)
-							fmt.Println(msg)
-
-							// if sendErrorsToDiscord {
-							// 	if len(check.Errors) == 1 && check.HasBundleWith0EffectiveGasPrice {
-							// 		// Short message if only 1 error and that is a 0-effective-gas-price
-							// 		msg := check.SprintHeader(false, true)
-							// 		msg += " - Error: " + check.Errors[0]
-							// 		SendToDiscord(msg)
-							// 	} else {
-							// 		SendToDiscord(check.Sprint(false, true))
-							// 	}
-							// }
-							fmt.Println("")
-						} else if check.HasLessSeriousErrors() { // less serious errors are only counted
-							errorCountNonSerious += 1
-						}
-
-						// Send failed TX to Discord
-						// if sendErrorsToDiscord && check.TriggerAlertOnFailedTx {
-						// 	SendToDiscord(check.Sprint(false, true, false))
-						// }
-
-						// Count errors
-						if check.HasSeriousErrors() || check.HasLessSeriousErrors() { // update and print miner error count on serious and less-serious errors
-							log.Printf("stats - 50p_errors: %d, 25p_errors: %d\n", errorCountSerious, errorCountNonSerious)
-							weeklyErrorSummary.AddCheckErrors(check)
-							dailyErrorSummary.AddCheckErrors(check)
-							fmt.Println(dailyErrorSummary.String())
-						}
-					}
-
-					// IS IT TIME TO RESET DAILY & WEEKLY ERRORS?
-					now := time.Now()
-
-					// Daily summary at 3pm ET
-					dailySummaryTriggerHourUtc := 19 // 3pm ET
-					// log.Println(now.UTC().Hour(), dailySummaryTriggerHourUtc, time.Since(dailyErrorSummary.TimeStarted).Hours())
-					if now.UTC().Hour() == dailySummaryTriggerHourUtc && time.Since(dailyErrorSummary.TimeStarted).Hours() >= 2 {
-						log.Println("trigger daily summary")
-						if sendErrorsToDiscord {
-							msg := dailyErrorSummary.String()
-							if msg != "" {
-								fmt.Println(msg)
-								SendToDiscord("Daily miner summary: ```" + msg + "```")
-							}
-						}
-
-						// reset daily summery
-						dailyErrorSummary.Reset()
-					}
-
-					// Weekly summary on Friday at 10am ET
-					weeklySummaryTriggerHourUtc := 14 // 10am ET
-					if now.UTC().Weekday() == time.Friday && now.UTC().Hour() == weeklySummaryTriggerHourUtc && time.Since(weeklyErrorSummary.TimeStarted).Hours() >= 2 {
-						log.Println("trigger weekly summary")
-						if sendErrorsToDiscord {
-							msg := weeklyErrorSummary.String()
-							if msg != "" {
-								fmt.Println(msg)
-								SendToDiscord("Weekly miner summary: ```" + msg + "```")
-							}
-						}
-
-						// reset weekly summery
-						weeklyErrorSummary.Reset()
-					}
-
-					// // -------- Send daily summary to Discord ---------
-					// if sendErrorsToDiscord {
-					// 	// Check if it's time to send to Discord: first block after 3pm ET (7pm UTC)
-					// 	// triggerHourUtc := 19
-
-					// 	// dateLastSent := lastSummarySentToDiscord.Format("01-02-2006")
-					// 	// dateToday := now.Format("01-02-2006")
-
-					// 	// For testing, send at specific interval
-					// 	if time.Since(dailyErrorSummary.TimeStarted).Hours() >= 3 {
-					// 		// if dateToday != dateLastSent && now.UTC().Hour() == triggerHourUtc {
-					// 		log.Println("Sending summary to Discord:")
-					// 		msg := dailyErrorSummary.String()
-					// 		if msg != "" {
-					// 			fmt.Println(msg)
-					// 			SendToDiscord("```" + msg + "```")
-					// 		}
-
-					// 		// Reset errors
-					// 		dailyErrorSummary.Reset()
-					// 		log.Println("Done, errors are reset.")
-					// 	}
-					// }
-
-					time.Sleep(1 * time.Second)
-				}
-			}
+		if *serveAddrPtr != "" {
+			startWebserver(*serveAddrPtr)
 		}
+
+		// Shut down gracefully on Ctrl-C / SIGTERM (a second signal exits right away)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-ctx.Done()
+			stop()
+		}()
+
+		go pool.Run(ctx, nodeHealthCheckInterval)
+
+		log.Println("Start watching...")
+		watch(ctx, pool)
 	}
 }
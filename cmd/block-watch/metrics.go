@@ -0,0 +1,133 @@
+// Prometheus metrics, served at /metrics when started with -metrics
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/metachris/flashbots/blockcheck"
+	"github.com/metachris/flashbots/watcher"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "blockwatch"
+
+var (
+	metricBlocksChecked = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "blocks_checked_total",
+		Help:      "Number of checked blocks, by miner.",
+	}, []string{"miner", "miner_name"})
+
+	metricFindings = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "findings_total",
+		Help:      "Number of findings, by finding type and miner.",
+	}, []string{"type", "miner", "miner_name"})
+
+	metricBacklogDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "backlog_dropped_total",
+		Help:      "Number of blocks dropped from the backlog after waiting too long.",
+	})
+
+	metricChainHead = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "chain_head_block",
+		Help:      "Latest block number received from the eth node.",
+	})
+
+	metricReorgs = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reorgs_total",
+		Help:      "Number of detected reorgs.",
+	})
+
+	metricReorgedBlocks = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reorged_blocks_total",
+		Help:      "Number of blocks that were replaced by reorgs.",
+	})
+
+	metricApiLag = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "mevblocks_api_lag_blocks",
+		Help:      "Difference between chain head and the mev-blocks API latest_block_number.",
+	})
+
+	metricApiLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "mevblocks_api_request_duration_seconds",
+		Help:      "Latency of mev-blocks API requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	metricRpcLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "eth_rpc_request_duration_seconds",
+		Help:      "Latency of eth node requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	metricAlertDeliveryFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "alert_delivery_failures_total",
+		Help:      "Number of alerts that could not be delivered, by notifier.",
+	}, []string{"notifier"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		metricBlocksChecked,
+		metricFindings,
+		metricBacklogDropped,
+		metricChainHead,
+		metricReorgs,
+		metricReorgedBlocks,
+		metricApiLag,
+		metricApiLatency,
+		metricRpcLatency,
+		metricAlertDeliveryFailures,
+	)
+}
+
+// registerBacklogMetric registers the backlog size gauge, which reads the length of the queue
+func registerBacklogMetric(queue *watcher.BlockQueue) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "backlog_size",
+		Help:      "Number of blocks waiting for the mev-blocks API to catch up.",
+	}, func() float64 {
+		return float64(queue.Len())
+	}))
+}
+
+// startMetricsServer serves the Prometheus metrics at http://<addr>/metrics (in the background). An error of the
+// metrics server is logged, but does not stop watching.
+func startMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		log.Println("Serving metrics at", addr+"/metrics")
+		err := http.ListenAndServe(addr, mux)
+		log.Println("Metrics server error:", err)
+	}()
+}
+
+// observeCheck updates the block and finding counters with the results of a block check
+func observeCheck(check *blockcheck.BlockCheck) {
+	metricBlocksChecked.WithLabelValues(check.Miner, check.MinerName).Inc()
+	for findingType, count := range check.ErrorCounter.ByType() {
+		if count > 0 {
+			metricFindings.WithLabelValues(findingType, check.Miner, check.MinerName).Add(float64(count))
+		}
+	}
+}
+
+// observeDuration records the time elapsed since start in the histogram
+func observeDuration(histogram prometheus.Observer, start time.Time) {
+	histogram.Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/flashbots/api"
+	"github.com/metachris/flashbots/blockcheck"
+	"github.com/metachris/flashbots/nodepool"
+	"github.com/metachris/flashbots/watcher"
+	"github.com/metachris/go-ethutils/blockswithtx"
+	"github.com/metachris/go-ethutils/utils"
+	"github.com/pkg/errors"
+)
+
+// Number of recent blocks for which reorgs are detected (and checks are kept to retract their findings)
+const reorgTrackingDepth = 64
+
+// Backlog of new blocks that are not yet present in the mev-blocks API (it has ~5 blocks delay)
+var blockBacklog *watcher.BlockQueue
+
+// Blocks waiting in the backlog for longer than this are dropped (eg. if the mev-blocks API stops advancing)
+var backlogMaxWait = time.Hour
+
+// Interval of eth node health checks (head height, latency and optional block hash cross-check)
+const nodeHealthCheckInterval = 15 * time.Second
+
+// Interval to poll for new blocks, if the node does not support subscriptions
+var pollInterval = 3 * time.Second
+
+// Recently checked blocks by hash, to retract their findings if they are reorged out (nil while the check is in progress)
+var checkedBlocks map[ethcommon.Hash]*blockcheck.BlockCheck = make(map[ethcommon.Hash]*blockcheck.BlockCheck)
+var checkedBlocksLock sync.Mutex
+
+var chainTracker *watcher.ChainTracker
+
+var errorCountSerious int
+var errorCountNonSerious int
+
+// watch checks new blocks until the context is cancelled, then finishes in-flight checks, sends pending alerts and
+// saves a checkpoint
+func watch(ctx context.Context, pool *nodepool.Pool) {
+	chainTracker = watcher.NewChainTracker(pool, reorgTrackingDepth)
+
+	blockBacklog = watcher.NewBlockQueue(backlogMaxWait, processBlock)
+	blockBacklog.OnError = func(block *watcher.QueuedBlock, err error) {
+		log.Printf("CheckBlock error: %v, block: %d (attempt %d)\n", err, block.Number(), block.Attempts)
+	}
+	blockBacklog.OnDrop = func(block *watcher.QueuedBlock) {
+		log.Printf("Dropping block %d %s from backlog after waiting %s (%d attempts)\n", block.Number(), block.Block.Block.Hash(), time.Since(block.Added).Round(time.Second), block.Attempts)
+		metricBacklogDropped.Inc()
+	}
+	registerBacklogMetric(blockBacklog)
+
+	restoreCheckpoint(pool)
+
+	backlogDone := make(chan struct{})
+	go func() {
+		blockBacklog.Run(ctx)
+		close(backlogDone)
+	}()
+
+	headers := make(chan *types.Header)
+	headSource := watcher.NewHeadSource(ctx, pool, pollInterval)
+	go headSource.Run(ctx, headers)
+
+	checkpointTicker := time.NewTicker(checkpointInterval)
+	defer checkpointTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Shutting down, waiting for in-flight checks...")
+			<-backlogDone
+			if alertManager != nil {
+				if err := alertManager.FlushAll(); err != nil {
+					log.Println(err)
+				}
+			}
+			saveCheckpoint()
+			log.Println("Shutdown complete")
+			return
+
+		case <-checkpointTicker.C:
+			saveCheckpoint()
+
+		case header := <-headers:
+			metricChainHead.Set(float64(header.Number.Int64()))
+
+			// Detect reorgs, and get all blocks that became canonical with this header (including missed blocks)
+			update, err := chainTracker.AddHeader(ctx, header)
+			if err != nil {
+				log.Println("Chain tracker error:", err)
+				update = watcher.ChainUpdate{NewBlocks: []watcher.BlockRef{{Number: header.Number.Int64(), Hash: header.Hash()}}}
+			}
+
+			if update.SkippedTo > 0 {
+				log.Printf("Skipped blocks %d to %d: gap larger than %d blocks\n", update.SkippedFrom, update.SkippedTo, chainTracker.MaxGap)
+			}
+
+			if update.IsReorg() {
+				handleReorg(update)
+			}
+
+			for _, block := range update.NewBlocks {
+				queueBlock(pool, block)
+			}
+
+			// Query flashbots API to get latest block it has processed
+			opts := api.GetBlocksOptions{BlockNumber: header.Number.Int64()}
+			timeRequestStart := time.Now()
+			flashbotsResponse, err := api.GetBlocks(&opts)
+			observeDuration(metricApiLatency.WithLabelValues("blocks"), timeRequestStart)
+			if err != nil {
+				log.Println("Flashbots API error:", err)
+				continue
+			}
+			metricApiLag.Set(float64(header.Number.Int64() - flashbotsResponse.LatestBlockNumber))
+
+			// Blocks up to here can be processed by the backlog
+			blockBacklog.SetReadyHeight(flashbotsResponse.LatestBlockNumber)
+		}
+	}
+}
+
+// queueBlock downloads a new canonical block with tx-receipts and adds it to the backlog
+func queueBlock(pool *nodepool.Pool, block watcher.BlockRef) {
+	var b *blockswithtx.BlockWithTxReceipts
+	timeRequestStart := time.Now()
+	err := pool.DoNode(context.Background(), func(node *nodepool.Node) (err error) {
+		b, err = node.Fetcher.GetBlockWithTxReceiptsByHash(context.Background(), block.Hash)
+		return err
+	})
+	observeDuration(metricRpcLatency.WithLabelValues("get_block_with_receipts"), timeRequestStart)
+	if err != nil {
+		err = errors.Wrap(err, "error in GetBlockWithTxReceiptsByHash")
+		log.Printf("%+v\n", err)
+		return
+	}
+
+	if !silent {
+		fmt.Println("Queueing new block", b.Block.Number(), b.Block.Hash())
+	}
+
+	// Add to backlog, because it can only be processed when the Flashbots API has caught up
+	blockBacklog.Add(b)
+}
+
+// handleReorg removes replaced blocks from the backlog, and retracts the findings of replaced blocks that were
+// already checked. The new canonical blocks at these heights are queued by the caller.
+func handleReorg(update watcher.ChainUpdate) {
+	log.Printf("Reorg detected: %d blocks replaced, new head %s\n", len(update.ReplacedBlocks), update.NewBlocks[len(update.NewBlocks)-1])
+	metricReorgs.Inc()
+	metricReorgedBlocks.Add(float64(len(update.ReplacedBlocks)))
+
+	for _, block := range update.ReplacedBlocks {
+		blockBacklog.Remove(block.Hash)
+
+		if resultsDb != nil {
+			if err := resultsDb.MarkOrphaned(block.Hash.Hex()); err != nil {
+				log.Println("Error marking block as orphaned in results db:", err)
+			}
+		}
+
+		checkedBlocksLock.Lock()
+		check, found := checkedBlocks[block.Hash]
+		delete(checkedBlocks, block.Hash)
+		checkedBlocksLock.Unlock()
+
+		if found && check != nil { // nil: the check is in progress, its result is dropped
+			retractCheck(check)
+		}
+	}
+}
+
+// retractCheck removes the findings of a block which was reorged out from the summaries, and retracts its alert
+func retractCheck(check *blockcheck.BlockCheck) {
+	if !check.HasErrors() {
+		return
+	}
+
+	log.Printf("Retracting findings of reorged block %d %s\n", check.Number, check.EthBlock.Hash())
+
+	summaryLock.Lock()
+	dailyErrorSummary.RemoveCheckErrors(check)
+	weeklyErrorSummary.RemoveCheckErrors(check)
+	summaryLock.Unlock()
+
+	recentDetections.MarkOrphaned(check.EthBlock.Hash().Hex())
+	retractCheckAlert(check)
+}
+
+// processBlock checks a block from the backlog (called by the backlog in height order, once the Flashbots API has
+// caught up). Returning an error keeps the block in the backlog to be retried.
+func processBlock(block *blockswithtx.BlockWithTxReceipts) error {
+	hash := block.Block.Hash()
+	isCanonical := func() bool {
+		return chainTracker.IsCanonical(block.Block.Number().Int64(), hash)
+	}
+
+	// Register the block before the check, so that a reorg during the check removes it
+	checkedBlocksLock.Lock()
+	if !isCanonical() {
+		checkedBlocksLock.Unlock()
+		return nil // reorged out in the meantime
+	}
+	checkedBlocks[hash] = nil
+	checkedBlocksLock.Unlock()
+
+	if !silent {
+		utils.PrintBlock(block.Block)
+	}
+
+	check, err := blockcheck.CheckBlock(block, false)
+
+	// The lock is held until the check is processed, so that a reorg retracts the findings only after they were added
+	checkedBlocksLock.Lock()
+	defer checkedBlocksLock.Unlock()
+	if _, found := checkedBlocks[hash]; !found || !isCanonical() {
+		return nil // reorged out during the check
+	}
+	if err != nil {
+		delete(checkedBlocks, hash)
+		return err
+	}
+
+	if resultsDb != nil {
+		if err := resultsDb.SaveCheck(check); err != nil {
+			log.Println("Error saving check to results db:", err)
+		}
+	}
+
+	checkedBlocks[hash] = check
+	if check.Number > lastProcessedHeight {
+		lastProcessedHeight = check.Number
+	}
+
+	// Forget checks of blocks that can no longer be reorged out
+	for checkedHash, checked := range checkedBlocks {
+		if checked != nil && checked.Number <= chainTracker.Head()-reorgTrackingDepth {
+			delete(checkedBlocks, checkedHash)
+		}
+	}
+
+	processCheck(check)
+	return nil
+}
+
+// processCheck handles the result of a block check (print, summaries, alerts, etc.)
+func processCheck(check *blockcheck.BlockCheck) {
+	observeCheck(check)
+	recentDetections.AddCheck(check)
+
+	// Handle errors in the bundle (print, Discord, etc.)
+	if check.HasErrors() {
+		if check.HasSeriousErrors() { // only serious errors are printed
+			errorCountSerious += 1
+			msg := check.Sprint(true, false, true)
+			fmt.Println(msg)
+			fmt.Println("")
+		} else if check.HasLessSeriousErrors() { // less serious errors are only counted
+			errorCountNonSerious += 1
+		}
+
+		// Count errors
+		if check.HasSeriousErrors() || check.HasLessSeriousErrors() { // update and print miner error count on serious and less-serious errors
+			log.Printf("stats - 50p_errors: %d, 25p_errors: %d\n", errorCountSerious, errorCountNonSerious)
+			summaryLock.Lock()
+			weeklyErrorSummary.AddCheckErrors(check)
+			dailyErrorSummary.AddCheckErrors(check)
+			fmt.Println(dailyErrorSummary.String())
+			summaryLock.Unlock()
+		}
+	}
+
+	// Send serious errors and failed TX to the notifiers
+	handleCheckAlerts(check)
+
+	// IS IT TIME TO RESET DAILY & WEEKLY ERRORS?
+	now := time.Now()
+
+	// Daily summary at 3pm ET
+	dailySummaryTriggerHourUtc := 19 // 3pm ET
+	if now.UTC().Hour() == dailySummaryTriggerHourUtc && time.Since(dailyErrorSummary.TimeStarted).Hours() >= 2 {
+		log.Println("trigger daily summary")
+		summaryLock.Lock()
+		msg := dailyErrorSummary.String()
+		dailyErrorSummary.Reset() // reset daily summery
+		summaryLock.Unlock()
+
+		if notifier.HasRoutes() {
+			sendSummary("Daily miner summary", msg)
+		}
+	}
+
+	// Weekly summary on Friday at 10am ET
+	weeklySummaryTriggerHourUtc := 14 // 10am ET
+	if now.UTC().Weekday() == time.Friday && now.UTC().Hour() == weeklySummaryTriggerHourUtc && time.Since(weeklyErrorSummary.TimeStarted).Hours() >= 2 {
+		log.Println("trigger weekly summary")
+		summaryLock.Lock()
+		msg := weeklyErrorSummary.String()
+		weeklyErrorSummary.Reset() // reset weekly summery
+		summaryLock.Unlock()
+
+		if notifier.HasRoutes() {
+			sendSummary("Weekly miner summary", msg)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Webserver that serves recent detections (failed Flashbots and 0-gas tx, blocks with findings, miner error summaries)
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/metachris/flashbots/blockcheck"
+)
+
+// Number of recent checks and failed tx kept in memory
+const recentDetectionsSize = 1000
+
+var recentDetections = blockcheck.NewRecentDetections(recentDetectionsSize)
+
+type minerErrorsView struct {
+	Miner       string                 `json:"miner"`
+	MinerName   string                 `json:"miner_name"`
+	NumBlocks   int                    `json:"num_blocks"`
+	Blocks      []int64                `json:"blocks"`
+	ErrorCounts blockcheck.ErrorCounts `json:"error_counts"`
+}
+
+type errorSummaryView struct {
+	TimeStarted time.Time         `json:"time_started"`
+	Miners      []minerErrorsView `json:"miners"`
+}
+
+type minerDetailView struct {
+	Miner        string                    `json:"miner"`
+	Daily        *minerErrorsView          `json:"daily"`
+	Weekly       *minerErrorsView          `json:"weekly"`
+	RecentChecks []blockcheck.CheckSummary `json:"recent_checks"`
+}
+
+func newMinerErrorsView(minerErrors *blockcheck.MinerErrors) minerErrorsView {
+	return minerErrorsView{
+		Miner:       minerErrors.MinerHash,
+		MinerName:   minerErrors.MinerName,
+		NumBlocks:   len(minerErrors.Blocks),
+		Blocks:      minerErrors.BlockNumbers(),
+		ErrorCounts: minerErrors.ErrorCounts,
+	}
+}
+
+// newErrorSummaryView must be called with summaryLock held
+func newErrorSummaryView(summary *blockcheck.ErrorSummary) errorSummaryView {
+	view := errorSummaryView{
+		TimeStarted: summary.TimeStarted,
+		Miners:      make([]minerErrorsView, 0, len(summary.MinerErrors)),
+	}
+	for _, minerErrors := range summary.SortedMinerErrors() {
+		view.Miners = append(view.Miners, newMinerErrorsView(minerErrors))
+	}
+	return view
+}
+
+// findMinerErrors must be called with summaryLock held
+func findMinerErrors(summary *blockcheck.ErrorSummary, miner string) *minerErrorsView {
+	for hash, minerErrors := range summary.MinerErrors {
+		if strings.EqualFold(hash, miner) {
+			view := newMinerErrorsView(minerErrors)
+			return &view
+		}
+	}
+	return nil
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("webserver json encode error:", err)
+	}
+}
+
+func handleFailedTx(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, recentDetections.FailedTx())
+}
+
+func handleChecks(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, recentDetections.Checks(r.URL.Query().Get("miner")))
+}
+
+func handleSummary(w http.ResponseWriter, r *http.Request) {
+	summaryLock.RLock()
+	defer summaryLock.RUnlock()
+
+	writeJson(w, map[string]errorSummaryView{
+		"daily":  newErrorSummaryView(&dailyErrorSummary),
+		"weekly": newErrorSummaryView(&weeklyErrorSummary),
+	})
+}
+
+func handleMiner(w http.ResponseWriter, r *http.Request) {
+	miner := strings.TrimPrefix(r.URL.Path, "/api/miners/")
+	if miner == "" {
+		http.Error(w, "missing miner address", http.StatusBadRequest)
+		return
+	}
+
+	view := minerDetailView{
+		Miner:        miner,
+		RecentChecks: recentDetections.Checks(miner),
+	}
+
+	summaryLock.RLock()
+	view.Daily = findMinerErrors(&dailyErrorSummary, miner)
+	view.Weekly = findMinerErrors(&weeklyErrorSummary, miner)
+	summaryLock.RUnlock()
+
+	writeJson(w, view)
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Flashbots block-watch</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+.serious { color: #b00; }
+.orphaned { color: #999; text-decoration: line-through; }
+</style>
+</head>
+<body>
+<h1>Flashbots block-watch</h1>
+<p>JSON API: <a href="/api/failed-tx">/api/failed-tx</a>, <a href="/api/checks">/api/checks</a>, <a href="/api/summary">/api/summary</a>, /api/miners/&lt;address&gt;</p>
+
+<h2>Failed Flashbots and 0-gas transactions</h2>
+<table>
+<tr><th>Block</th><th>Hash</th><th>Flashbots</th><th>From</th><th>To</th></tr>
+{{range .FailedTx}}<tr{{if .Orphaned}} class="orphaned"{{end}}>
+<td><a href="https://etherscan.io/block/{{.Block}}">{{.Block}}</a>{{if .Orphaned}} (reorged){{end}}</td>
+<td><a href="https://etherscan.io/tx/{{.Hash}}">{{.Hash}}</a></td>
+<td>{{.IsFlashbots}}</td>
+<td><a href="https://etherscan.io/address/{{.From}}">{{.From}}</a></td>
+<td><a href="https://etherscan.io/address/{{.To}}">{{.To}}</a></td>
+</tr>{{end}}
+</table>
+
+<h2>Blocks with findings</h2>
+<table>
+<tr><th>Block</th><th>Time</th><th>Miner</th><th>tx / fb-tx / bundles</th><th>Findings</th></tr>
+{{range .Checks}}<tr{{if .Orphaned}} class="orphaned"{{else if .HasSeriousErrors}} class="serious"{{end}}>
+<td><a href="https://etherscan.io/block/{{.Number}}">{{.Number}}</a>{{if .Orphaned}} (reorged){{end}}</td>
+<td>{{.Time.Format "2006-01-02 15:04:05"}}</td>
+<td><a href="/api/miners/{{.Miner}}">{{if .MinerName}}{{.MinerName}}{{else}}{{.Miner}}{{end}}</a></td>
+<td>{{.NumTx}} / {{.NumFlashbotsTx}} / {{.NumBundles}}</td>
+<td>{{range .Errors}}{{.}}<br>{{end}}</td>
+</tr>{{end}}
+</table>
+</body>
+</html>
+`))
+
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := struct {
+		FailedTx []blockcheck.FailedTx
+		Checks   []blockcheck.CheckSummary
+	}{
+		FailedTx: recentDetections.FailedTx(),
+		Checks:   recentDetections.Checks(""),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, data); err != nil {
+		log.Println("webserver template error:", err)
+	}
+}
+
+// startWebserver serves the recent detections at http://<addr>/ (in the background)
+func startWebserver(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleIndex)
+	mux.HandleFunc("/api/failed-tx", handleFailedTx)
+	mux.HandleFunc("/api/checks", handleChecks)
+	mux.HandleFunc("/api/summary", handleSummary)
+	mux.HandleFunc("/api/miners/", handleMiner)
+
+	go func() {
+		log.Println("Serving recent detections at", addr)
+		err := http.ListenAndServe(addr, mux)
+		log.Fatal("webserver error: ", err)
+	}()
+}
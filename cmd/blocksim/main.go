@@ -3,33 +3,32 @@
 //
 // Example arguments:
 //
-//     $ go run cmd/blocksim/main.go -mevgeth http://xxx.xxx.xxx.xxx:8545 -number 13100622
-//     $ go run cmd/blocksim/main.go -mevgeth http://xxx.xxx.xxx.xxx:8545 -hash 0x662f81506bd1d1f7cbefa308261ba94ee63438998cdf085c95081448aaf4cc81
+//	$ go run cmd/blocksim/*.go -mevgeth http://xxx.xxx.xxx.xxx:8545 -number 13100622
+//	$ go run cmd/blocksim/*.go -mevgeth http://xxx.xxx.xxx.xxx:8545 -hash 0x662f81506bd1d1f7cbefa308261ba94ee63438998cdf085c95081448aaf4cc81
+//	$ go run cmd/blocksim/*.go -mevgeth http://xxx.xxx.xxx.xxx:8545 -start 2021-08-26 -end 1d
 //
 // Example output:
 //
-//     Connected to http://xxx.xxx.xxx.xxx:8545
-//     Block 13100622 0x662f81506bd1d1f7cbefa308261ba94ee63438998cdf085c95081448aaf4cc81        2021-08-26 11:14:55 +0000 UTC   tx=99           gas=13854382    uncles=0
-//     Simulation result:
-//     - CoinbaseDiff:           67391709273784431     0.0674 ETH
-//     - GasFees:                67391709273784431     0.0674 ETH
-//     - EthSentToCoinbase:                      0     0.0000 ETH
-//
+//	Connected to http://xxx.xxx.xxx.xxx:8545
+//	Block 13100622 0x662f81506bd1d1f7cbefa308261ba94ee63438998cdf085c95081448aaf4cc81        2021-08-26 11:14:55 +0000 UTC   tx=99           gas=13854382    uncles=0
+//	Simulation result:
+//	- CoinbaseDiff:           67391709273784431     0.0674 ETH
+//	- GasFees:                67391709273784431     0.0674 ETH
+//	- EthSentToCoinbase:                      0     0.0000 ETH
 package main
 
 import (
 	"context"
+	"encoding/csv"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math/big"
-	"sort"
-	"strings"
-	"time"
+	"os"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
 	flashbotsrpc "github.com/metachris/flashbots-rpc"
 	fbcommon "github.com/metachris/flashbots/common"
@@ -38,13 +37,26 @@ import (
 )
 
 var addressLookup *addresslookup.AddressLookupService
+var mevGethRpc *flashbotsrpc.FlashbotsRPC
+var gethClient *ethclient.Client
+
+// Progress messages go to stderr with json and csv output, to keep stdout parseable
+var progress io.Writer = os.Stdout
 
 func main() {
 	mevGethUriPtr := flag.String("mevgeth", "", "mev-geth node URI")
 	gethUriPtr := flag.String("geth", "", "geth node URI for tx lookup")
 	blockHash := flag.String("hash", "", "hash of block to simulate")
 	blockNumber := flag.Int64("number", -1, "number of block to simulate")
+	startSpec := flag.String("start", "", "range mode start: "+fbcommon.BlockRangeHelp)
+	endSpec := flag.String("end", "", "range mode end (optional, default latest block): same as start, or duration relative to start (eg. 1d12h)")
+	concurrencyPtr := flag.Int("concurrency", 5, "range mode: number of blocks to simulate in parallel")
+	retriesPtr := flag.Int("retries", 3, "range mode: retries for failed downloads and simulations, before the block is skipped")
+	reconcilePtr := flag.Bool("reconcile", false, "compare the simulated earnings with the coinbase balance diff (needs an archive node)")
+	earningsCachePtr := flag.String("earnings-cache", "", "reconcile: file to keep the balance earnings of blocks across runs")
+	siblingPtr := flag.Bool("sibling", false, "if non-canonical block, also simulate the canonical sibling and compare transactions and bundles")
 	checkTxPtr := flag.Bool("checktx", false, "if non-canonical block, additional transaction checks")
+	outputPtr := flag.String("output", "text", "output format: text, json or csv")
 	debugPtr := flag.Bool("debug", false, "print debug information")
 	flag.Parse()
 
@@ -52,31 +64,59 @@ func main() {
 		log.Fatal("No mev geth URI provided")
 	}
 
-	if *blockHash == "" && *blockNumber == -1 {
-		log.Fatal("Either block number or hash is needed")
+	if *blockHash == "" && *blockNumber == -1 && *startSpec == "" {
+		log.Fatal("Either block number, hash or start is needed")
+	}
+
+	if *siblingPtr && (*blockHash == "" || *outputPtr == "csv") {
+		log.Fatal("-sibling needs -hash, and text or json output")
+	}
+
+	switch *outputPtr {
+	case "text":
+	case "json", "csv":
+		progress = os.Stderr
+		log.SetOutput(os.Stderr)
+	default:
+		log.Fatalf("Invalid output format: %s", *outputPtr)
 	}
 
 	mevGethClient, err := ethclient.Dial(*mevGethUriPtr)
 	utils.Perror(err)
-	fmt.Println("Connected to", *mevGethUriPtr)
-	gethClient := mevGethClient
+	fmt.Fprintln(progress, "Connected to", *mevGethUriPtr)
+	gethClient = mevGethClient
 
 	if *gethUriPtr != "" && *gethUriPtr != *mevGethUriPtr {
 		gethClient, err = ethclient.Dial(*gethUriPtr)
 		utils.Perror(err)
-		fmt.Println("Connected to", *gethUriPtr)
+		fmt.Fprintln(progress, "Connected to", *gethUriPtr)
 	}
 
 	addressLookup = addresslookup.NewAddressLookupService(gethClient)
 	err = addressLookup.AddAllAddresses()
 	if err != nil {
-		fmt.Println("addresslookup error:", err)
+		fmt.Fprintln(progress, "addresslookup error:", err)
 	}
 
-	fmt.Println("Downloading block...")
+	if *reconcilePtr {
+		earningsService, err = fbcommon.NewEarningsServiceWithCache(gethClient, fbcommon.DefaultEarningsCacheSize, *earningsCachePtr)
+		utils.Perror(err)
+	}
+
+	mevGethRpc = flashbotsrpc.NewFlashbotsRPC(*mevGethUriPtr)
+	mevGethRpc.Debug = *debugPtr
+
+	if *startSpec != "" {
+		startBlock, endBlock, err := fbcommon.ResolveBlockRange(context.Background(), mevGethClient, *startSpec, *endSpec)
+		utils.Perror(err)
+		simulateRangeAndPrint(mevGethClient, startBlock, endBlock, *concurrencyPtr, *retriesPtr, *outputPtr)
+		saveEarningsCache()
+		return
+	}
+
+	fmt.Fprintln(progress, "Downloading block...")
 	var block *types.Block
-	var canonicalBlock *types.Block
-	isCanonicalBlock := true
+	var canonicalBlock *types.Block // only set if block is not canonical
 
 	if *blockHash != "" {
 		hash := common.HexToHash(*blockHash)
@@ -86,8 +126,8 @@ func main() {
 		// Get block by number, to check if the block is from canonical chain or was reorg'ed
 		canonicalBlock, err = mevGethClient.BlockByNumber(context.Background(), block.Number())
 		utils.Perror(err)
-		if block.Hash() != canonicalBlock.Hash() {
-			isCanonicalBlock = false
+		if block.Hash() == canonicalBlock.Hash() {
+			canonicalBlock = nil
 		}
 
 	} else {
@@ -95,116 +135,84 @@ func main() {
 		utils.Perror(err)
 	}
 
-	fmt.Println("")
-	printBlock(block)
-	if !isCanonicalBlock {
-		fmt.Print("- Block is not in canonical chain. Was replaced by:\n  ")
-		printBlock(canonicalBlock)
+	if *siblingPtr && canonicalBlock == nil {
+		log.Fatal("Block is canonical, it has no sibling")
 	}
 
-	if len(block.Transactions()) == 0 {
-		fmt.Println("No transactions in this block")
-		return
+	fmt.Fprintln(progress, "")
+	printBlock(progress, block)
+	if canonicalBlock != nil {
+		fmt.Fprint(progress, "- Block is not in canonical chain. Was replaced by:\n  ")
+		printBlock(progress, canonicalBlock)
 	}
 
-	rpc := flashbotsrpc.NewFlashbotsRPC(*mevGethUriPtr)
-	rpc.Debug = *debugPtr
-
-	fmt.Println("\nSimulating block...")
-	privateKey, _ := crypto.GenerateKey()
-	result, err := rpc.FlashbotsSimulateBlock(privateKey, block, 0)
-	utils.Perror(err)
-	fmt.Println("Simulation result:")
-	fmt.Printf("- CoinbaseDiff:      %22s %10s ETH\n", result.CoinbaseDiff, weiStrToEthStr(result.CoinbaseDiff, 4))
-	fmt.Printf("- GasFees:           %22s %10s ETH\n", result.GasFees, weiStrToEthStr(result.GasFees, 4))
-	fmt.Printf("- EthSentToCoinbase: %22s %10s ETH\n", result.EthSentToCoinbase, weiStrToEthStr(result.EthSentToCoinbase, 4))
-
-	blockCbDiffWei := big.NewFloat(0)
-	blockCbDiffWei, _ = blockCbDiffWei.SetString(result.CoinbaseDiff)
-
-	// sort transactions by coinbasediff
-	sort.Slice(result.Results, func(i, j int) bool {
-		a := fbcommon.StrToBigInt(result.Results[i].CoinbaseDiff)
-		b := fbcommon.StrToBigInt(result.Results[j].CoinbaseDiff)
-		return a.Cmp(b) == 1
-	})
-
-	numTxNeededFor80PercentValue := 0
-	currentValue := big.NewFloat(0)
-
-	// If an address is receiving at least 2 tx, load the address info
-	_addressUsed := make(map[string]bool)
-	for _, entry := range result.Results {
-		if _addressUsed[entry.ToAddress] {
-			addressLookup.GetAddressDetail(entry.ToAddress)
-		}
-		_addressUsed[entry.ToAddress] = true
-	}
-
-	fmt.Println("\nTransactions:")
-	txInclusionBlocks := make(map[uint64]int)
-	for i, entry := range result.Results {
-		_incl := ""
-		if *checkTxPtr && !isCanonicalBlock {
-			// Where was this tx included?
-			r, err := gethClient.TransactionReceipt(context.Background(), common.HexToHash(entry.TxHash))
-			if err != nil {
-				_incl = fmt.Sprintf("included-in: - (%s)", err)
-				txInclusionBlocks[0] += 1
-			} else {
-				_incl = fmt.Sprintf("included-in: %s", r.BlockNumber)
-				txInclusionBlocks[r.BlockNumber.Uint64()] += 1
-			}
-		}
-
-		_to := entry.ToAddress
-		if detail, found := addressLookup.Cache[strings.ToLower(entry.ToAddress)]; found && len(detail.Name) > 0 {
-			_to = fmt.Sprintf("%s (%s)", _to, detail.Name)
+	var result *blockSimResult
+	if len(block.Transactions()) == 0 {
+		fmt.Fprintln(progress, "No transactions in this block")
+		if !*siblingPtr {
+			return
 		}
-		fmt.Printf("%4d %s \t cbD=%8s, gasFee=%8s, ethSentToCb=%8s \t to=%-64s   %s\n", i+1, entry.TxHash, weiStrToEthStr(entry.CoinbaseDiff, 4), weiStrToEthStr(entry.GasFees, 4), weiStrToEthStr(entry.EthSentToCoinbase, 4), _to, _incl)
-
-		cbDiffWei := new(big.Float)
-		cbDiffWei, _ = cbDiffWei.SetString(entry.CoinbaseDiff)
+		result = emptySimResult(block, canonicalBlock) // the sibling is still compared with the empty uncle
+	} else {
+		fmt.Fprintln(progress, "\nSimulating block...")
+		result, err = simulateBlock(block, canonicalBlock, *checkTxPtr)
+		utils.Perror(err)
+	}
 
-		currentValue = new(big.Float).Add(currentValue, cbDiffWei)
+	if earningsService != nil {
+		result.Reconciliation, err = reconcileBlock(context.Background(), result)
+		utils.Perror(err)
+		saveEarningsCache()
+	}
 
-		percentValueReached := new(big.Float).Quo(currentValue, blockCbDiffWei)
-		// fmt.Println(percentValueReached.Text('f', 4))
-		if numTxNeededFor80PercentValue == 0 && percentValueReached.Cmp(big.NewFloat(0.8)) > -1 {
-			numTxNeededFor80PercentValue = i
+	if *siblingPtr {
+		fmt.Fprintln(progress, "Simulating canonical sibling...")
+		comparison, err := compareSiblings(context.Background(), result)
+		utils.Perror(err)
+		if *outputPtr == "json" {
+			utils.Perror(writeSiblingJson(os.Stdout, comparison))
+		} else {
+			printSiblingComparison(os.Stdout, comparison)
 		}
+		return
 	}
 
-	fmt.Printf("\n%d/%d tx needed for 80%% of miner value.\n", numTxNeededFor80PercentValue, len(result.Results))
+	switch *outputPtr {
+	case "json":
+		utils.Perror(writeJson(os.Stdout, result))
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		utils.Perror(w.Write(csvHeader))
+		utils.Perror(writeCsv(w, result))
+	default:
+		printResult(os.Stdout, result)
+	}
+}
 
-	if *checkTxPtr && !isCanonicalBlock {
-		fmt.Println("\nTransactions included in these blocks:")
-		for blockNum, count := range txInclusionBlocks {
-			_blockNum := fmt.Sprintf("%d", blockNum)
-			if blockNum == 0 {
-				_blockNum = "missing"
-			}
+func simulateRangeAndPrint(client *ethclient.Client, startBlock int64, endBlock int64, concurrency int, retries int, output string) {
+	fmt.Fprintf(progress, "Simulating blocks %d ... %d\n", startBlock, endBlock)
 
-			if blockNum == block.NumberU64() {
-				_blockNum += " (sibling)"
-			}
+	var csvWriter *csv.Writer
+	if output == "csv" {
+		csvWriter = csv.NewWriter(os.Stdout)
+		utils.Perror(csvWriter.Write(csvRangeHeader))
+	}
 
-			fmt.Printf("- %-8s: %3d\n", _blockNum, count)
+	summary := simulateRange(context.Background(), client, startBlock, endBlock, concurrency, retries, func(res *blockSimResult) {
+		fmt.Fprintf(progress, "Block %d: tx=%d, cbD=%s ETH, %d tx for 80%%\n", res.Block.NumberU64(), len(res.Txs), weiStrToEthStr(res.Sim.CoinbaseDiff, 4), res.NumTxNeededFor80Percent)
+		if csvWriter != nil {
+			utils.Perror(writeRangeCsvRow(csvWriter, res))
 		}
-	}
-}
+	})
 
-func printBlock(block *types.Block) {
-	t := time.Unix(int64(block.Header().Time), 0).UTC()
-	miner := block.Coinbase().Hex()
-	if details, found := addressLookup.GetAddressDetail(block.Coinbase().Hex()); found {
-		miner += " (" + details.Name + ")"
+	switch output {
+	case "json":
+		utils.Perror(writeRangeJson(os.Stdout, summary))
+	case "csv":
+		// Rows were written per block, the summary goes to stderr
+		printRangeSummary(progress, summary)
+	default:
+		fmt.Println("")
+		printRangeSummary(os.Stdout, summary)
 	}
-	fmt.Printf("Block %d %s \t %s \t tx=%d, uncles=%d, miner: %s\n", block.NumberU64(), block.Hash(), t, len(block.Transactions()), len(block.Uncles()), miner)
-}
-
-func weiStrToEthStr(weiStr string, decimals int) string {
-	i := new(big.Int)
-	i.SetString(weiStr, 10)
-	return utils.WeiBigIntToEthString(i, decimals)
 }
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/go-ethutils/utils"
+)
+
+func printBlock(w io.Writer, block *types.Block) {
+	t := time.Unix(int64(block.Header().Time), 0).UTC()
+	miner := block.Coinbase().Hex()
+	if name := addressName(miner, true); name != "" {
+		miner += " (" + name + ")"
+	}
+	fmt.Fprintf(w, "Block %d %s \t %s \t tx=%d, uncles=%d, miner: %s\n", block.NumberU64(), block.Hash(), t, len(block.Transactions()), len(block.Uncles()), miner)
+}
+
+func weiStrToEthStr(weiStr string, decimals int) string {
+	i := new(big.Int)
+	i.SetString(weiStr, 10)
+	return utils.WeiBigIntToEthString(i, decimals)
+}
+
+func weiStrToEth(weiStr string) float64 {
+	wei, ok := new(big.Float).SetString(weiStr)
+	if !ok {
+		return 0
+	}
+	eth, _ := new(big.Float).Quo(wei, big.NewFloat(1e18)).Float64()
+	return eth
+}
+
+func printResult(w io.Writer, res *blockSimResult) {
+	fmt.Fprintln(w, "Simulation result:")
+	fmt.Fprintf(w, "- CoinbaseDiff:      %22s %10s ETH\n", res.Sim.CoinbaseDiff, weiStrToEthStr(res.Sim.CoinbaseDiff, 4))
+	fmt.Fprintf(w, "- GasFees:           %22s %10s ETH\n", res.Sim.GasFees, weiStrToEthStr(res.Sim.GasFees, 4))
+	fmt.Fprintf(w, "- EthSentToCoinbase: %22s %10s ETH\n", res.Sim.EthSentToCoinbase, weiStrToEthStr(res.Sim.EthSentToCoinbase, 4))
+
+	fmt.Fprintln(w, "\nTransactions:")
+	for i, tx := range res.Txs {
+		_to := tx.ToAddress
+		if tx.ToName != "" {
+			_to = fmt.Sprintf("%s (%s)", _to, tx.ToName)
+		}
+
+		_incl := ""
+		if res.TxInclusionChecked {
+			if tx.IncludedInErr != nil {
+				_incl = fmt.Sprintf("included-in: - (%s)", tx.IncludedInErr)
+			} else {
+				_incl = fmt.Sprintf("included-in: %d", tx.IncludedIn)
+			}
+		}
+
+		fmt.Fprintf(w, "%4d %s \t cbD=%8s, gasFee=%8s, ethSentToCb=%8s \t to=%-64s   %s\n", i+1, tx.TxHash, weiStrToEthStr(tx.CoinbaseDiff, 4), weiStrToEthStr(tx.GasFees, 4), weiStrToEthStr(tx.EthSentToCoinbase, 4), _to, _incl)
+	}
+
+	fmt.Fprintf(w, "\n%d/%d tx needed for 80%% of miner value.\n", res.NumTxNeededFor80Percent, len(res.Txs))
+
+	if res.TxInclusionChecked {
+		fmt.Fprintln(w, "\nTransactions included in these blocks:")
+		for blockNum, count := range res.TxInclusionBlocks {
+			_blockNum := fmt.Sprintf("%d", blockNum)
+			if blockNum == 0 {
+				_blockNum = "missing"
+			}
+
+			if blockNum == res.Block.NumberU64() {
+				_blockNum += " (sibling)"
+			}
+
+			fmt.Fprintf(w, "- %-8s: %3d\n", _blockNum, count)
+		}
+	}
+
+	if res.Reconciliation != nil {
+		fmt.Fprintln(w, "")
+		printReconciliation(w, res.Reconciliation)
+	}
+}
+
+type jsonBlock struct {
+	Number        uint64 `json:"number"`
+	Hash          string `json:"hash"`
+	Timestamp     uint64 `json:"timestamp"`
+	Miner         string `json:"miner"`
+	MinerName     string `json:"miner_name,omitempty"`
+	NumTx         int    `json:"num_tx"`
+	NumUncles     int    `json:"num_uncles"`
+	IsCanonical   bool   `json:"is_canonical"`
+	CanonicalHash string `json:"canonical_hash,omitempty"` // hash of the canonical block at this height, if not canonical
+}
+
+type jsonTx struct {
+	Rank                 int     `json:"rank"` // by coinbase diff, 1 = highest
+	Hash                 string  `json:"hash"`
+	From                 string  `json:"from"`
+	To                   string  `json:"to"`
+	ToName               string  `json:"to_name,omitempty"`
+	GasUsed              int64   `json:"gas_used"`
+	GasPrice             string  `json:"gas_price"`
+	CoinbaseDiff         string  `json:"coinbase_diff"`
+	CoinbaseDiffEth      float64 `json:"coinbase_diff_eth"`
+	GasFees              string  `json:"gas_fees"`
+	GasFeesEth           float64 `json:"gas_fees_eth"`
+	EthSentToCoinbase    string  `json:"eth_sent_to_coinbase"`
+	EthSentToCoinbaseEth float64 `json:"eth_sent_to_coinbase_eth"`
+	IncludedIn           *uint64 `json:"included_in,omitempty"` // with -checktx on non-canonical blocks, 0 if not included
+}
+
+type jsonResult struct {
+	Block                   jsonBlock           `json:"block"`
+	CoinbaseDiff            string              `json:"coinbase_diff"`
+	CoinbaseDiffEth         float64             `json:"coinbase_diff_eth"`
+	GasFees                 string              `json:"gas_fees"`
+	GasFeesEth              float64             `json:"gas_fees_eth"`
+	EthSentToCoinbase       string              `json:"eth_sent_to_coinbase"`
+	EthSentToCoinbaseEth    float64             `json:"eth_sent_to_coinbase_eth"`
+	TotalGasUsed            int64               `json:"total_gas_used"`
+	NumTxNeededFor80Percent int                 `json:"num_tx_needed_for_80_percent"`
+	Transactions            []jsonTx            `json:"transactions"`
+	TxInclusionBlocks       map[uint64]int      `json:"tx_inclusion_blocks,omitempty"`
+	Reconciliation          *jsonReconciliation `json:"reconciliation,omitempty"`
+}
+
+func toJsonResult(res *blockSimResult) jsonResult {
+	block := res.Block
+	out := jsonResult{
+		Block: jsonBlock{
+			Number:      block.NumberU64(),
+			Hash:        block.Hash().Hex(),
+			Timestamp:   block.Time(),
+			Miner:       block.Coinbase().Hex(),
+			NumTx:       len(block.Transactions()),
+			NumUncles:   len(block.Uncles()),
+			MinerName:   addressName(block.Coinbase().Hex(), true),
+			IsCanonical: res.IsCanonical(),
+		},
+		CoinbaseDiff:            res.Sim.CoinbaseDiff,
+		CoinbaseDiffEth:         weiStrToEth(res.Sim.CoinbaseDiff),
+		GasFees:                 res.Sim.GasFees,
+		GasFeesEth:              weiStrToEth(res.Sim.GasFees),
+		EthSentToCoinbase:       res.Sim.EthSentToCoinbase,
+		EthSentToCoinbaseEth:    weiStrToEth(res.Sim.EthSentToCoinbase),
+		TotalGasUsed:            res.Sim.TotalGasUsed,
+		NumTxNeededFor80Percent: res.NumTxNeededFor80Percent,
+		Transactions:            make([]jsonTx, 0, len(res.Txs)),
+		Reconciliation:          toJsonReconciliation(res.Reconciliation),
+	}
+	if !res.IsCanonical() {
+		out.Block.CanonicalHash = res.CanonicalBlock.Hash().Hex()
+	}
+	if res.TxInclusionChecked {
+		out.TxInclusionBlocks = res.TxInclusionBlocks
+	}
+
+	for i, tx := range res.Txs {
+		jtx := jsonTx{
+			Rank:                 i + 1,
+			Hash:                 tx.TxHash,
+			From:                 tx.FromAddress,
+			To:                   tx.ToAddress,
+			ToName:               tx.ToName,
+			GasUsed:              tx.GasUsed,
+			GasPrice:             tx.GasPrice,
+			CoinbaseDiff:         tx.CoinbaseDiff,
+			CoinbaseDiffEth:      weiStrToEth(tx.CoinbaseDiff),
+			GasFees:              tx.GasFees,
+			GasFeesEth:           weiStrToEth(tx.GasFees),
+			EthSentToCoinbase:    tx.EthSentToCoinbase,
+			EthSentToCoinbaseEth: weiStrToEth(tx.EthSentToCoinbase),
+		}
+		if res.TxInclusionChecked {
+			includedIn := tx.IncludedIn
+			jtx.IncludedIn = &includedIn
+		}
+		out.Transactions = append(out.Transactions, jtx)
+	}
+	return out
+}
+
+func writeJson(w io.Writer, res *blockSimResult) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(toJsonResult(res))
+}
+
+var csvHeader = []string{
+	"block_number", "block_hash", "block_timestamp", "miner", "miner_name", "block_is_canonical",
+	"block_coinbase_diff", "block_gas_fees", "block_eth_sent_to_coinbase", "block_num_tx_needed_for_80_percent",
+	"tx_rank", "tx_hash", "from", "to", "to_name", "gas_used", "gas_price",
+	"coinbase_diff", "coinbase_diff_eth", "gas_fees", "gas_fees_eth", "eth_sent_to_coinbase", "eth_sent_to_coinbase_eth", "included_in",
+}
+
+// writeCsv writes one row per transaction, with the block metadata and aggregates in every row
+func writeCsv(w *csv.Writer, res *blockSimResult) error {
+	r := toJsonResult(res)
+	for _, tx := range r.Transactions {
+		includedIn := ""
+		if tx.IncludedIn != nil {
+			includedIn = strconv.FormatUint(*tx.IncludedIn, 10)
+		}
+
+		err := w.Write([]string{
+			strconv.FormatUint(r.Block.Number, 10), r.Block.Hash, strconv.FormatUint(r.Block.Timestamp, 10), r.Block.Miner, r.Block.MinerName, strconv.FormatBool(r.Block.IsCanonical),
+			r.CoinbaseDiff, r.GasFees, r.EthSentToCoinbase, strconv.Itoa(r.NumTxNeededFor80Percent),
+			strconv.Itoa(tx.Rank), tx.Hash, tx.From, tx.To, tx.ToName, strconv.FormatInt(tx.GasUsed, 10), tx.GasPrice,
+			tx.CoinbaseDiff, formatEth(tx.CoinbaseDiffEth), tx.GasFees, formatEth(tx.GasFeesEth), tx.EthSentToCoinbase, formatEth(tx.EthSentToCoinbaseEth), includedIn,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func formatEth(eth float64) string {
+	return strconv.FormatFloat(eth, 'f', -1, 64)
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	fbcommon "github.com/metachris/flashbots/common"
+)
+
+// earnings are the simulated miner earnings of a number of blocks
+type earnings struct {
+	NumBlocks         int
+	CoinbaseDiff      *big.Int
+	GasFees           *big.Int
+	EthSentToCoinbase *big.Int
+}
+
+func newEarnings() *earnings {
+	return &earnings{CoinbaseDiff: new(big.Int), GasFees: new(big.Int), EthSentToCoinbase: new(big.Int)}
+}
+
+func (e *earnings) add(res *blockSimResult) {
+	e.NumBlocks += 1
+	e.CoinbaseDiff.Add(e.CoinbaseDiff, fbcommon.StrToBigInt(res.Sim.CoinbaseDiff))
+	e.GasFees.Add(e.GasFees, fbcommon.StrToBigInt(res.Sim.GasFees))
+	e.EthSentToCoinbase.Add(e.EthSentToCoinbase, fbcommon.StrToBigInt(res.Sim.EthSentToCoinbase))
+}
+
+// rangeSummary aggregates the simulation results of a block range
+type rangeSummary struct {
+	StartBlock int64
+	EndBlock   int64
+	NumEmpty   int // blocks without transactions (not simulated)
+
+	Total    *earnings
+	PerMiner map[common.Address]*earnings
+	PerDay   map[string]*earnings // by UTC date (2006-01-02)
+
+	// Per simulated block with positive coinbase diff: number of tx for 80% of the value, and the same as % of the tx
+	NumTxNeededFor80Percent     []int
+	PercentTxNeededFor80Percent []float64
+
+	Failed []fbcommon.BlockError // blocks that could not be downloaded or simulated
+
+	Reconciliation *reconciliation // sum of all blocks, with -reconcile
+}
+
+func newRangeSummary(startBlock int64, endBlock int64) *rangeSummary {
+	return &rangeSummary{
+		StartBlock: startBlock,
+		EndBlock:   endBlock,
+		Total:      newEarnings(),
+		PerMiner:   make(map[common.Address]*earnings),
+		PerDay:     make(map[string]*earnings),
+	}
+}
+
+func (s *rangeSummary) add(res *blockSimResult) {
+	s.Total.add(res)
+	if res.Reconciliation != nil {
+		if s.Reconciliation == nil {
+			s.Reconciliation = newReconciliation()
+		}
+		s.Reconciliation.add(res.Reconciliation)
+	}
+
+	miner := res.Block.Coinbase()
+	if s.PerMiner[miner] == nil {
+		s.PerMiner[miner] = newEarnings()
+	}
+	s.PerMiner[miner].add(res)
+
+	day := time.Unix(int64(res.Block.Time()), 0).UTC().Format("2006-01-02")
+	if s.PerDay[day] == nil {
+		s.PerDay[day] = newEarnings()
+	}
+	s.PerDay[day].add(res)
+
+	if res.NumTxNeededFor80Percent > 0 {
+		s.NumTxNeededFor80Percent = append(s.NumTxNeededFor80Percent, res.NumTxNeededFor80Percent)
+		s.PercentTxNeededFor80Percent = append(s.PercentTxNeededFor80Percent, float64(res.NumTxNeededFor80Percent)*100/float64(len(res.Txs)))
+	}
+}
+
+// distribution summarizes values by percentiles
+type distribution struct {
+	Count  int     `json:"count"`
+	Mean   float64 `json:"mean"`
+	Min    float64 `json:"min"`
+	P25    float64 `json:"p25"`
+	Median float64 `json:"median"`
+	P75    float64 `json:"p75"`
+	P90    float64 `json:"p90"`
+	Max    float64 `json:"max"`
+}
+
+func newDistribution(values []float64) distribution {
+	d := distribution{Count: len(values)}
+	if len(values) == 0 {
+		return d
+	}
+
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	percentile := func(p float64) float64 {
+		return sorted[int(p*float64(len(sorted)-1)+0.5)]
+	}
+
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+	d.Mean = sum / float64(len(sorted))
+	d.Min, d.P25, d.Median, d.P75, d.P90, d.Max = sorted[0], percentile(0.25), percentile(0.5), percentile(0.75), percentile(0.9), sorted[len(sorted)-1]
+	return d
+}
+
+func intsToFloats(values []int) []float64 {
+	res := make([]float64, len(values))
+	for i, v := range values {
+		res[i] = float64(v)
+	}
+	return res
+}
+
+// simulateWithRetries simulates the block, and retries failed simulations with exponential backoff
+func simulateWithRetries(ctx context.Context, block *types.Block, retries int, backoff time.Duration) (res *blockSimResult, err error) {
+	for attempt := 0; ; attempt++ {
+		res, err = simulateBlock(block, nil, false)
+		if err == nil || attempt >= retries {
+			return res, err
+		}
+
+		log.Printf("Error simulating block %d (attempt %d): %v\n", block.NumberU64(), attempt+1, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// simulateRange downloads and simulates all blocks from startBlock to endBlock (inclusive), with at most concurrency
+// simulations in parallel. onBlock is called for each simulated block (not concurrently).
+func simulateRange(ctx context.Context, client fbcommon.BlockByNumberReader, startBlock int64, endBlock int64, concurrency int, retries int, onBlock func(res *blockSimResult)) *rangeSummary {
+	summary := newRangeSummary(startBlock, endBlock)
+	var summaryLock sync.Mutex
+
+	blockChan := make(chan *types.Block, 100)
+	var workerWg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		workerWg.Add(1)
+		go func() {
+			defer workerWg.Done()
+			for block := range blockChan {
+				if len(block.Transactions()) == 0 {
+					summaryLock.Lock()
+					summary.NumEmpty += 1
+					summaryLock.Unlock()
+					continue
+				}
+
+				res, err := simulateWithRetries(ctx, block, retries, time.Second)
+				if err == nil && earningsService != nil {
+					res.Reconciliation, err = reconcileBlock(ctx, res)
+				}
+
+				summaryLock.Lock()
+				if err != nil {
+					log.Printf("Skipping block %d: %v\n", block.NumberU64(), err)
+					summary.Failed = append(summary.Failed, fbcommon.BlockError{Height: block.Number().Int64(), Err: err})
+				} else {
+					summary.add(res)
+					if onBlock != nil {
+						onBlock(res)
+					}
+				}
+				summaryLock.Unlock()
+			}
+		}()
+	}
+
+	opts := fbcommon.DefaultGetBlocksOptions()
+	opts.MaxRetries = retries
+	result, err := fbcommon.GetBlocks(ctx, blockChan, client, startBlock, endBlock, opts)
+	close(blockChan)
+	workerWg.Wait()
+
+	if err != nil {
+		log.Println("Error downloading blocks:", err)
+	}
+	summary.Failed = append(summary.Failed, result.Failed...)
+	sort.Slice(summary.Failed, func(i, j int) bool { return summary.Failed[i].Height < summary.Failed[j].Height })
+	return summary
+}
+
+func weiToEthStr(wei *big.Int) string {
+	return weiStrToEthStr(wei.String(), 4)
+}
+
+func printRangeSummary(w io.Writer, s *rangeSummary) {
+	fmt.Fprintf(w, "Blocks %d ... %d: %d simulated, %d without transactions, %d failed\n", s.StartBlock, s.EndBlock, s.Total.NumBlocks, s.NumEmpty, len(s.Failed))
+	fmt.Fprintf(w, "- CoinbaseDiff:      %10s ETH\n", weiToEthStr(s.Total.CoinbaseDiff))
+	fmt.Fprintf(w, "- GasFees:           %10s ETH\n", weiToEthStr(s.Total.GasFees))
+	fmt.Fprintf(w, "- EthSentToCoinbase: %10s ETH\n", weiToEthStr(s.Total.EthSentToCoinbase))
+
+	fmt.Fprintln(w, "\nPer miner:")
+	miners := make([]common.Address, 0, len(s.PerMiner))
+	for miner := range s.PerMiner {
+		miners = append(miners, miner)
+	}
+	sort.Slice(miners, func(i, j int) bool {
+		return s.PerMiner[miners[i]].CoinbaseDiff.Cmp(s.PerMiner[miners[j]].CoinbaseDiff) == 1
+	})
+	for _, miner := range miners {
+		e := s.PerMiner[miner]
+		fmt.Fprintf(w, "%s %-20s blocks=%5d \t cbD=%10s, gasFees=%10s, ethSentToCb=%10s ETH\n", miner.Hex(), addressName(miner.Hex(), true), e.NumBlocks, weiToEthStr(e.CoinbaseDiff), weiToEthStr(e.GasFees), weiToEthStr(e.EthSentToCoinbase))
+	}
+
+	fmt.Fprintln(w, "\nPer day:")
+	days := make([]string, 0, len(s.PerDay))
+	for day := range s.PerDay {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	for _, day := range days {
+		e := s.PerDay[day]
+		fmt.Fprintf(w, "%s blocks=%5d \t cbD=%10s, gasFees=%10s, ethSentToCb=%10s ETH\n", day, e.NumBlocks, weiToEthStr(e.CoinbaseDiff), weiToEthStr(e.GasFees), weiToEthStr(e.EthSentToCoinbase))
+	}
+
+	d := newDistribution(intsToFloats(s.NumTxNeededFor80Percent))
+	dp := newDistribution(s.PercentTxNeededFor80Percent)
+	fmt.Fprintf(w, "\nTx needed for 80%% of miner value (%d blocks):\n", d.Count)
+	fmt.Fprintf(w, "- number of tx:  mean=%.1f, min=%.0f, p25=%.0f, median=%.0f, p75=%.0f, p90=%.0f, max=%.0f\n", d.Mean, d.Min, d.P25, d.Median, d.P75, d.P90, d.Max)
+	fmt.Fprintf(w, "- %% of block tx: mean=%.1f, min=%.1f, p25=%.1f, median=%.1f, p75=%.1f, p90=%.1f, max=%.1f\n", dp.Mean, dp.Min, dp.P25, dp.Median, dp.P75, dp.P90, dp.Max)
+
+	if s.Reconciliation != nil {
+		fmt.Fprintln(w, "")
+		printReconciliation(w, s.Reconciliation)
+	}
+
+	if len(s.Failed) > 0 {
+		fmt.Fprintf(w, "\n%d blocks failed:\n", len(s.Failed))
+		for _, blockErr := range s.Failed {
+			fmt.Fprintf(w, "- %s\n", blockErr)
+		}
+	}
+}
+
+type jsonEarnings struct {
+	NumBlocks            int     `json:"num_blocks"`
+	CoinbaseDiff         string  `json:"coinbase_diff"`
+	CoinbaseDiffEth      float64 `json:"coinbase_diff_eth"`
+	GasFees              string  `json:"gas_fees"`
+	GasFeesEth           float64 `json:"gas_fees_eth"`
+	EthSentToCoinbase    string  `json:"eth_sent_to_coinbase"`
+	EthSentToCoinbaseEth float64 `json:"eth_sent_to_coinbase_eth"`
+}
+
+func toJsonEarnings(e *earnings) jsonEarnings {
+	return jsonEarnings{
+		NumBlocks:            e.NumBlocks,
+		CoinbaseDiff:         e.CoinbaseDiff.String(),
+		CoinbaseDiffEth:      weiStrToEth(e.CoinbaseDiff.String()),
+		GasFees:              e.GasFees.String(),
+		GasFeesEth:           weiStrToEth(e.GasFees.String()),
+		EthSentToCoinbase:    e.EthSentToCoinbase.String(),
+		EthSentToCoinbaseEth: weiStrToEth(e.EthSentToCoinbase.String()),
+	}
+}
+
+type jsonMinerEarnings struct {
+	Miner     string `json:"miner"`
+	MinerName string `json:"miner_name,omitempty"`
+	jsonEarnings
+}
+
+type jsonDayEarnings struct {
+	Day string `json:"day"`
+	jsonEarnings
+}
+
+type jsonFailedBlock struct {
+	Number int64  `json:"number"`
+	Error  string `json:"error"`
+}
+
+type jsonRangeSummary struct {
+	StartBlock                  int64               `json:"start_block"`
+	EndBlock                    int64               `json:"end_block"`
+	NumEmptyBlocks              int                 `json:"num_empty_blocks"`
+	Total                       jsonEarnings        `json:"total"`
+	PerMiner                    []jsonMinerEarnings `json:"per_miner"` // by coinbase diff, highest first
+	PerDay                      []jsonDayEarnings   `json:"per_day"`
+	NumTxNeededFor80Percent     distribution        `json:"num_tx_needed_for_80_percent"`
+	PercentTxNeededFor80Percent distribution        `json:"percent_tx_needed_for_80_percent"`
+	FailedBlocks                []jsonFailedBlock   `json:"failed_blocks"`
+	Reconciliation              *jsonReconciliation `json:"reconciliation,omitempty"`
+}
+
+func writeRangeJson(w io.Writer, s *rangeSummary) error {
+	out := jsonRangeSummary{
+		StartBlock:                  s.StartBlock,
+		EndBlock:                    s.EndBlock,
+		NumEmptyBlocks:              s.NumEmpty,
+		Total:                       toJsonEarnings(s.Total),
+		PerMiner:                    make([]jsonMinerEarnings, 0, len(s.PerMiner)),
+		PerDay:                      make([]jsonDayEarnings, 0, len(s.PerDay)),
+		NumTxNeededFor80Percent:     newDistribution(intsToFloats(s.NumTxNeededFor80Percent)),
+		PercentTxNeededFor80Percent: newDistribution(s.PercentTxNeededFor80Percent),
+		FailedBlocks:                make([]jsonFailedBlock, 0, len(s.Failed)),
+		Reconciliation:              toJsonReconciliation(s.Reconciliation),
+	}
+
+	for miner, e := range s.PerMiner {
+		out.PerMiner = append(out.PerMiner, jsonMinerEarnings{Miner: miner.Hex(), MinerName: addressName(miner.Hex(), true), jsonEarnings: toJsonEarnings(e)})
+	}
+	sort.Slice(out.PerMiner, func(i, j int) bool {
+		return s.PerMiner[common.HexToAddress(out.PerMiner[i].Miner)].CoinbaseDiff.Cmp(s.PerMiner[common.HexToAddress(out.PerMiner[j].Miner)].CoinbaseDiff) == 1
+	})
+
+	for day, e := range s.PerDay {
+		out.PerDay = append(out.PerDay, jsonDayEarnings{Day: day, jsonEarnings: toJsonEarnings(e)})
+	}
+	sort.Slice(out.PerDay, func(i, j int) bool { return out.PerDay[i].Day < out.PerDay[j].Day })
+
+	for _, blockErr := range s.Failed {
+		out.FailedBlocks = append(out.FailedBlocks, jsonFailedBlock{Number: blockErr.Height, Error: blockErr.Err.Error()})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+var csvRangeHeader = []string{
+	"block_number", "block_hash", "block_timestamp", "miner", "miner_name", "num_tx",
+	"coinbase_diff", "coinbase_diff_eth", "gas_fees", "gas_fees_eth", "eth_sent_to_coinbase", "eth_sent_to_coinbase_eth", "num_tx_needed_for_80_percent",
+}
+
+// writeRangeCsvRow writes the aggregated simulation result of a block (in range mode, csv has one row per block)
+func writeRangeCsvRow(w *csv.Writer, res *blockSimResult) error {
+	r := toJsonResult(res)
+	err := w.Write([]string{
+		strconv.FormatUint(r.Block.Number, 10), r.Block.Hash, strconv.FormatUint(r.Block.Timestamp, 10), r.Block.Miner, r.Block.MinerName, strconv.Itoa(r.Block.NumTx),
+		r.CoinbaseDiff, formatEth(r.CoinbaseDiffEth), r.GasFees, formatEth(r.GasFeesEth), r.EthSentToCoinbase, formatEth(r.EthSentToCoinbaseEth), strconv.Itoa(r.NumTxNeededFor80Percent),
+	})
+	if err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
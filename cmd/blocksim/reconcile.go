@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	fbcommon "github.com/metachris/flashbots/common"
+)
+
+var earningsService *fbcommon.EarningsService // set with -reconcile
+
+func saveEarningsCache() {
+	if earningsService == nil {
+		return
+	}
+	if err := earningsService.SaveCache(); err != nil {
+		log.Println("Error saving earnings cache:", err)
+	}
+}
+
+// reconciliation explains the difference between the coinbase earnings from balance diffs (fbcommon.EarningsService)
+// and the simulated coinbase diff. BalanceEarnings = SimulatedEarnings + the differences by category.
+type reconciliation struct {
+	BalanceEarnings   *big.Int
+	SimulatedEarnings *big.Int
+
+	// Differences
+	BlockReward             *big.Int
+	UncleInclusionRewards   *big.Int
+	UncleMinerRewards       *big.Int // the coinbase also mined included uncles
+	TxToCoinbaseFees        *big.Int // priority fees of tx to the coinbase (not simulated)
+	TxFromCoinbaseBurntFees *big.Int // negative: base fee burnt by tx of the coinbase (not simulated)
+	Unexplained             *big.Int
+
+	// Counted by both, not differences
+	InternalTransfers *big.Int // ETH sent to the coinbase by contract calls
+	BurntFees         *big.Int // base fee of all tx in the block, earned by neither
+}
+
+func newReconciliation() *reconciliation {
+	return &reconciliation{
+		BalanceEarnings:         new(big.Int),
+		SimulatedEarnings:       new(big.Int),
+		BlockReward:             new(big.Int),
+		UncleInclusionRewards:   new(big.Int),
+		UncleMinerRewards:       new(big.Int),
+		TxToCoinbaseFees:        new(big.Int),
+		TxFromCoinbaseBurntFees: new(big.Int),
+		Unexplained:             new(big.Int),
+		InternalTransfers:       new(big.Int),
+		BurntFees:               new(big.Int),
+	}
+}
+
+type reconciliationCategory struct {
+	Name        string
+	Amount      *big.Int
+	Explanation string
+}
+
+// differences returns the categories that add up to BalanceEarnings - SimulatedEarnings
+func (r *reconciliation) differences() []reconciliationCategory {
+	return []reconciliationCategory{
+		{"block_reward", r.BlockReward, "static block reward, paid outside of transactions and not part of the simulation"},
+		{"uncle_inclusion_rewards", r.UncleInclusionRewards, "1/32 of the block reward per included uncle, not part of the simulation"},
+		{"uncle_miner_rewards", r.UncleMinerRewards, "rewards for included uncles that were mined by the same coinbase"},
+		{"tx_to_coinbase_fees", r.TxToCoinbaseFees, "priority fees of transactions sent directly to the coinbase, which are skipped by the simulation (their value is removed by both)"},
+		{"tx_from_coinbase_burnt_fees", r.TxFromCoinbaseBurntFees, "base fee burnt by transactions of the coinbase itself, which are skipped by the simulation (their value is added back by the balance diff)"},
+		{"unexplained", r.Unexplained, "remaining difference, eg. contract calls that behave differently without the skipped coinbase transactions, or state differences of the simulation node"},
+	}
+}
+
+// notes returns amounts that are counted by both (or neither), and explain why the numbers may be off from the true earnings
+func (r *reconciliation) notes() []reconciliationCategory {
+	return []reconciliationCategory{
+		{"internal_transfers", r.InternalTransfers, "ETH sent to the coinbase via contract calls (eg. bundle payments), counted as earnings by both, while direct transfers are not"},
+		{"burnt_fees", r.BurntFees, "base fee of all transactions, burnt and earned by neither"},
+	}
+}
+
+func (r *reconciliation) add(other *reconciliation) {
+	r.BalanceEarnings.Add(r.BalanceEarnings, other.BalanceEarnings)
+	r.SimulatedEarnings.Add(r.SimulatedEarnings, other.SimulatedEarnings)
+	for i, c := range r.differences() {
+		c.Amount.Add(c.Amount, other.differences()[i].Amount)
+	}
+	for i, c := range r.notes() {
+		c.Amount.Add(c.Amount, other.notes()[i].Amount)
+	}
+}
+
+// reconcileBlock gets the coinbase earnings of the simulated block from balance diffs, and explains the difference
+func reconcileBlock(ctx context.Context, res *blockSimResult) (*reconciliation, error) {
+	block := res.Block
+	header := block.Header()
+	r := newReconciliation()
+
+	balanceEarnings, err := earningsService.GetBlockCoinbaseEarnings(ctx, block)
+	if err != nil {
+		return nil, fmt.Errorf("balance earnings: %w", err)
+	}
+	r.BalanceEarnings.Set(balanceEarnings)
+	r.SimulatedEarnings.Set(fbcommon.StrToBigInt(res.Sim.CoinbaseDiff))
+
+	r.BlockReward.Set(fbcommon.BlockReward(block.Number()))
+	uncleInclusionReward, uncleMinerRewards := fbcommon.UncleRewards(header, block.Uncles())
+	r.UncleInclusionRewards.Set(uncleInclusionReward)
+	if reward, found := uncleMinerRewards[block.Coinbase()]; found {
+		r.UncleMinerRewards.Set(reward)
+	}
+
+	// Transactions from and to the coinbase are skipped by the simulation, their gas payments need the receipts
+	for _, tx := range block.Transactions() {
+		from, fromErr := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+		txIsFromCoinbase := fromErr == nil && from == block.Coinbase()
+		txIsToCoinbase := tx.To() != nil && *tx.To() == block.Coinbase()
+		if !txIsFromCoinbase && !txIsToCoinbase {
+			continue
+		}
+
+		receipt, err := gethClient.TransactionReceipt(ctx, tx.Hash())
+		if err != nil {
+			return nil, fmt.Errorf("receipt of %s: %w", tx.Hash(), err)
+		}
+		gasUsed := new(big.Int).SetUint64(receipt.GasUsed)
+
+		if txIsFromCoinbase {
+			// The coinbase pays the gas and receives the tip, only the base fee is lost
+			if header.BaseFee != nil {
+				r.TxFromCoinbaseBurntFees.Sub(r.TxFromCoinbaseBurntFees, new(big.Int).Mul(header.BaseFee, gasUsed))
+			}
+		} else {
+			r.TxToCoinbaseFees.Add(r.TxToCoinbaseFees, new(big.Int).Mul(tx.EffectiveGasTipValue(header.BaseFee), gasUsed))
+		}
+	}
+
+	explained := new(big.Int).Add(r.BlockReward, r.UncleInclusionRewards)
+	explained.Add(explained, r.UncleMinerRewards)
+	explained.Add(explained, r.TxToCoinbaseFees)
+	explained.Add(explained, r.TxFromCoinbaseBurntFees)
+	r.Unexplained.Sub(r.BalanceEarnings, r.SimulatedEarnings)
+	r.Unexplained.Sub(r.Unexplained, explained)
+
+	r.InternalTransfers.Set(fbcommon.StrToBigInt(res.Sim.EthSentToCoinbase))
+	if header.BaseFee != nil {
+		r.BurntFees.Mul(header.BaseFee, new(big.Int).SetUint64(block.GasUsed()))
+	}
+	return r, nil
+}
+
+func printReconciliation(w io.Writer, r *reconciliation) {
+	fmt.Fprintln(w, "Reconciliation with coinbase balance diff:")
+	fmt.Fprintf(w, "- Balance earnings:   %10s ETH\n", weiToEthStr(r.BalanceEarnings))
+	fmt.Fprintf(w, "- Simulated earnings: %10s ETH\n", weiToEthStr(r.SimulatedEarnings))
+	fmt.Fprintf(w, "- Difference:         %10s ETH\n", weiToEthStr(new(big.Int).Sub(r.BalanceEarnings, r.SimulatedEarnings)))
+	for _, c := range r.differences() {
+		fmt.Fprintf(w, "  %-28s %10s ETH \t %s\n", c.Name, weiToEthStr(c.Amount), c.Explanation)
+	}
+	fmt.Fprintln(w, "- Not differences:")
+	for _, c := range r.notes() {
+		fmt.Fprintf(w, "  %-28s %10s ETH \t %s\n", c.Name, weiToEthStr(c.Amount), c.Explanation)
+	}
+}
+
+type jsonReconciliationCategory struct {
+	Amount      string  `json:"amount"`
+	AmountEth   float64 `json:"amount_eth"`
+	Explanation string  `json:"explanation"`
+}
+
+type jsonReconciliation struct {
+	BalanceEarnings      string                                `json:"balance_earnings"`
+	BalanceEarningsEth   float64                               `json:"balance_earnings_eth"`
+	SimulatedEarnings    string                                `json:"simulated_earnings"`
+	SimulatedEarningsEth float64                               `json:"simulated_earnings_eth"`
+	Differences          map[string]jsonReconciliationCategory `json:"differences"` // add up to balance - simulated earnings
+	Notes                map[string]jsonReconciliationCategory `json:"notes"`
+}
+
+func toJsonReconciliation(r *reconciliation) *jsonReconciliation {
+	if r == nil {
+		return nil
+	}
+
+	toMap := func(categories []reconciliationCategory) map[string]jsonReconciliationCategory {
+		res := make(map[string]jsonReconciliationCategory)
+		for _, c := range categories {
+			res[c.Name] = jsonReconciliationCategory{Amount: c.Amount.String(), AmountEth: weiStrToEth(c.Amount.String()), Explanation: c.Explanation}
+		}
+		return res
+	}
+
+	return &jsonReconciliation{
+		BalanceEarnings:      r.BalanceEarnings.String(),
+		BalanceEarningsEth:   weiStrToEth(r.BalanceEarnings.String()),
+		SimulatedEarnings:    r.SimulatedEarnings.String(),
+		SimulatedEarningsEth: weiStrToEth(r.SimulatedEarnings.String()),
+		Differences:          toMap(r.differences()),
+		Notes:                toMap(r.notes()),
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/metachris/flashbots/api"
+	fbcommon "github.com/metachris/flashbots/common"
+)
+
+// bundleComparison is a Flashbots bundle (from the mev-blocks API) with the number of its tx in the uncle and the sibling
+type bundleComparison struct {
+	Block            int64 // block the bundle was mined in
+	Index            int64
+	Type             string
+	TxHashes         []string
+	TotalMinerReward *big.Int
+	NumTxInUncle     int
+	NumTxInSibling   int
+}
+
+func (b *bundleComparison) status() string {
+	switch {
+	case b.NumTxInUncle == len(b.TxHashes) && b.NumTxInSibling == len(b.TxHashes):
+		return "both"
+	case b.NumTxInSibling == len(b.TxHashes) && b.NumTxInUncle == 0:
+		return "sibling only"
+	case b.NumTxInUncle == len(b.TxHashes) && b.NumTxInSibling == 0:
+		return "uncle only"
+	default:
+		return "partial"
+	}
+}
+
+// siblingComparison compares the simulation of a non-canonical block with its canonical sibling at the same height.
+// Tx from and to the coinbase are not simulated and not compared.
+type siblingComparison struct {
+	Uncle   *blockSimResult
+	Sibling *blockSimResult
+
+	NumCommonTxs   int
+	UncleOnlyTxs   []txResult // by coinbase diff, highest first
+	SiblingOnlyTxs []txResult
+
+	// Bundles of the sibling, and bundles with tx of the uncle that were mined in later blocks
+	Bundles []*bundleComparison
+}
+
+// CoinbaseDiffDelta is how much more the sibling earned than the uncle (negative if the uncle was more valuable)
+func (c *siblingComparison) CoinbaseDiffDelta() *big.Int {
+	return new(big.Int).Sub(fbcommon.StrToBigInt(c.Sibling.Sim.CoinbaseDiff), fbcommon.StrToBigInt(c.Uncle.Sim.CoinbaseDiff))
+}
+
+func uniqueTxs(txs []txResult, other []txResult) (unique []txResult) {
+	otherHashes := make(map[string]bool)
+	for _, tx := range other {
+		otherHashes[tx.TxHash] = true
+	}
+	for _, tx := range txs {
+		if !otherHashes[tx.TxHash] {
+			unique = append(unique, tx)
+		}
+	}
+	return unique
+}
+
+// compareSiblings simulates the canonical sibling of the uncle, and compares their transactions and Flashbots bundles
+func compareSiblings(ctx context.Context, uncle *blockSimResult) (*siblingComparison, error) {
+	sibling := emptySimResult(uncle.CanonicalBlock, nil)
+	if len(uncle.CanonicalBlock.Transactions()) > 0 {
+		var err error
+		sibling, err = simulateBlock(uncle.CanonicalBlock, nil, false)
+		if err != nil {
+			return nil, fmt.Errorf("simulating sibling: %w", err)
+		}
+	}
+
+	c := &siblingComparison{
+		Uncle:          uncle,
+		Sibling:        sibling,
+		UncleOnlyTxs:   uniqueTxs(uncle.Txs, sibling.Txs),
+		SiblingOnlyTxs: uniqueTxs(sibling.Txs, uncle.Txs),
+	}
+	c.NumCommonTxs = len(uncle.Txs) - len(c.UncleOnlyTxs)
+
+	// Flashbots blocks by number, from the mev-blocks API (nil if not a Flashbots block)
+	flashbotsBlocks := make(map[int64]*api.FlashbotsBlock)
+	getFlashbotsBlock := func(number int64) (*api.FlashbotsBlock, error) {
+		if block, found := flashbotsBlocks[number]; found {
+			return block, nil
+		}
+		res, err := api.GetBlocks(&api.GetBlocksOptions{BlockNumber: number})
+		if err != nil {
+			return nil, fmt.Errorf("mev-blocks api: %w", err)
+		}
+		var block *api.FlashbotsBlock
+		if len(res.Blocks) == 1 {
+			block = &res.Blocks[0]
+		}
+		flashbotsBlocks[number] = block
+		return block, nil
+	}
+
+	bundles := make(map[[2]int64]*bundleComparison) // by block and bundle index
+	addBundle := func(block *api.FlashbotsBlock, bundleIndex int64) {
+		key := [2]int64{block.BlockNumber, bundleIndex}
+		if bundles[key] != nil {
+			return
+		}
+		bundle := &bundleComparison{Block: block.BlockNumber, Index: bundleIndex, TotalMinerReward: new(big.Int)}
+		for _, tx := range block.Transactions {
+			if tx.BundleIndex == bundleIndex {
+				bundle.Type = tx.BundleType
+				bundle.TxHashes = append(bundle.TxHashes, tx.Hash)
+				bundle.TotalMinerReward.Add(bundle.TotalMinerReward, fbcommon.StrToBigInt(tx.TotalMinerReward))
+			}
+		}
+		bundles[key] = bundle
+	}
+
+	// Bundles of the sibling
+	flashbotsBlock, err := getFlashbotsBlock(uncle.Block.Number().Int64())
+	if err != nil {
+		return nil, err
+	}
+	if flashbotsBlock != nil {
+		for _, tx := range flashbotsBlock.Transactions {
+			addBundle(flashbotsBlock, tx.BundleIndex)
+		}
+	}
+
+	// Bundles with tx of the uncle that were mined later (the API only knows canonical blocks)
+	for _, tx := range c.UncleOnlyTxs {
+		includedIn := tx.IncludedIn
+		if !uncle.TxInclusionChecked {
+			receipt, err := gethClient.TransactionReceipt(ctx, common.HexToHash(tx.TxHash))
+			if err != nil {
+				continue // not mined
+			}
+			includedIn = receipt.BlockNumber.Uint64()
+		}
+		if includedIn == 0 {
+			continue
+		}
+
+		flashbotsBlock, err := getFlashbotsBlock(int64(includedIn))
+		if err != nil {
+			return nil, err
+		}
+		if flashbotsBlock == nil {
+			continue
+		}
+		for _, fbTx := range flashbotsBlock.Transactions {
+			if fbTx.Hash == tx.TxHash {
+				addBundle(flashbotsBlock, fbTx.BundleIndex)
+			}
+		}
+	}
+
+	inUncle := make(map[string]bool)
+	for _, tx := range uncle.Txs {
+		inUncle[tx.TxHash] = true
+	}
+	inSibling := make(map[string]bool)
+	for _, tx := range sibling.Txs {
+		inSibling[tx.TxHash] = true
+	}
+
+	for _, bundle := range bundles {
+		for _, hash := range bundle.TxHashes {
+			if inUncle[hash] {
+				bundle.NumTxInUncle += 1
+			}
+			if inSibling[hash] {
+				bundle.NumTxInSibling += 1
+			}
+		}
+		c.Bundles = append(c.Bundles, bundle)
+	}
+	sort.Slice(c.Bundles, func(i, j int) bool {
+		if c.Bundles[i].Block != c.Bundles[j].Block {
+			return c.Bundles[i].Block < c.Bundles[j].Block
+		}
+		return c.Bundles[i].Index < c.Bundles[j].Index
+	})
+	return c, nil
+}
+
+func printSiblingComparison(w io.Writer, c *siblingComparison) {
+	fmt.Fprint(w, "Uncle:   ")
+	printBlock(w, c.Uncle.Block)
+	fmt.Fprint(w, "Sibling: ")
+	printBlock(w, c.Sibling.Block)
+
+	fmt.Fprintf(w, "\n%-20s %10s %10s %10s\n", "", "uncle", "sibling", "difference")
+	printRow := func(name string, uncleWei string, siblingWei string) {
+		delta := new(big.Int).Sub(fbcommon.StrToBigInt(siblingWei), fbcommon.StrToBigInt(uncleWei))
+		fmt.Fprintf(w, "%-20s %10s %10s %10s ETH\n", name, weiStrToEthStr(uncleWei, 4), weiStrToEthStr(siblingWei, 4), weiToEthStr(delta))
+	}
+	printRow("CoinbaseDiff", c.Uncle.Sim.CoinbaseDiff, c.Sibling.Sim.CoinbaseDiff)
+	printRow("GasFees", c.Uncle.Sim.GasFees, c.Sibling.Sim.GasFees)
+	printRow("EthSentToCoinbase", c.Uncle.Sim.EthSentToCoinbase, c.Sibling.Sim.EthSentToCoinbase)
+	fmt.Fprintf(w, "%-20s %10d %10d\n", "Transactions", len(c.Uncle.Txs), len(c.Sibling.Txs))
+	fmt.Fprintf(w, "%-20s %10d\n", "Common transactions", c.NumCommonTxs)
+
+	printTxs := func(title string, txs []txResult) {
+		fmt.Fprintf(w, "\n%s: %d\n", title, len(txs))
+		for _, tx := range txs {
+			_to := tx.ToAddress
+			if tx.ToName != "" {
+				_to = fmt.Sprintf("%s (%s)", _to, tx.ToName)
+			}
+			fmt.Fprintf(w, "- %s \t cbD=%8s, ethSentToCb=%8s \t to=%s\n", tx.TxHash, weiStrToEthStr(tx.CoinbaseDiff, 4), weiStrToEthStr(tx.EthSentToCoinbase, 4), _to)
+		}
+	}
+	printTxs("Transactions only in the uncle", c.UncleOnlyTxs)
+	printTxs("Transactions only in the sibling", c.SiblingOnlyTxs)
+
+	fmt.Fprintf(w, "\nFlashbots bundles: %d\n", len(c.Bundles))
+	for _, bundle := range c.Bundles {
+		fmt.Fprintf(w, "- block %d bundle %d (%s) \t tx=%d, minerReward=%8s ETH \t %-12s (uncle: %d tx, sibling: %d tx)\n", bundle.Block, bundle.Index, bundle.Type, len(bundle.TxHashes), weiToEthStr(bundle.TotalMinerReward), bundle.status(), bundle.NumTxInUncle, bundle.NumTxInSibling)
+	}
+}
+
+type jsonBundleComparison struct {
+	Block               int64    `json:"block"`
+	Index               int64    `json:"bundle_index"`
+	Type                string   `json:"bundle_type"`
+	TxHashes            []string `json:"tx_hashes"`
+	TotalMinerReward    string   `json:"total_miner_reward"`
+	TotalMinerRewardEth float64  `json:"total_miner_reward_eth"`
+	NumTxInUncle        int      `json:"num_tx_in_uncle"`
+	NumTxInSibling      int      `json:"num_tx_in_sibling"`
+	Status              string   `json:"status"` // both, sibling only, uncle only, partial
+}
+
+type jsonSiblingComparison struct {
+	Uncle                jsonResult             `json:"uncle"`
+	Sibling              jsonResult             `json:"sibling"`
+	CoinbaseDiffDelta    string                 `json:"coinbase_diff_delta"` // sibling - uncle
+	CoinbaseDiffDeltaEth float64                `json:"coinbase_diff_delta_eth"`
+	NumCommonTxs         int                    `json:"num_common_txs"`
+	UncleOnlyTxHashes    []string               `json:"uncle_only_tx_hashes"`
+	SiblingOnlyTxHashes  []string               `json:"sibling_only_tx_hashes"`
+	FlashbotsBundles     []jsonBundleComparison `json:"flashbots_bundles"`
+}
+
+func writeSiblingJson(w io.Writer, c *siblingComparison) error {
+	delta := c.CoinbaseDiffDelta()
+	out := jsonSiblingComparison{
+		Uncle:                toJsonResult(c.Uncle),
+		Sibling:              toJsonResult(c.Sibling),
+		CoinbaseDiffDelta:    delta.String(),
+		CoinbaseDiffDeltaEth: weiStrToEth(delta.String()),
+		NumCommonTxs:         c.NumCommonTxs,
+		UncleOnlyTxHashes:    make([]string, 0, len(c.UncleOnlyTxs)),
+		SiblingOnlyTxHashes:  make([]string, 0, len(c.SiblingOnlyTxs)),
+		FlashbotsBundles:     make([]jsonBundleComparison, 0, len(c.Bundles)),
+	}
+	for _, tx := range c.UncleOnlyTxs {
+		out.UncleOnlyTxHashes = append(out.UncleOnlyTxHashes, tx.TxHash)
+	}
+	for _, tx := range c.SiblingOnlyTxs {
+		out.SiblingOnlyTxHashes = append(out.SiblingOnlyTxHashes, tx.TxHash)
+	}
+	for _, bundle := range c.Bundles {
+		out.FlashbotsBundles = append(out.FlashbotsBundles, jsonBundleComparison{
+			Block:               bundle.Block,
+			Index:               bundle.Index,
+			Type:                bundle.Type,
+			TxHashes:            bundle.TxHashes,
+			TotalMinerReward:    bundle.TotalMinerReward.String(),
+			TotalMinerRewardEth: weiStrToEth(bundle.TotalMinerReward.String()),
+			NumTxInUncle:        bundle.NumTxInUncle,
+			NumTxInSibling:      bundle.NumTxInSibling,
+			Status:              bundle.status(),
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
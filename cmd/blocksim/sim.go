@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	flashbotsrpc "github.com/metachris/flashbots-rpc"
+	fbcommon "github.com/metachris/flashbots/common"
+)
+
+// txResult is the simulation result of a transaction, with the name of the receiving address if known
+type txResult struct {
+	flashbotsrpc.FlashbotsCallBundleResult
+	ToName string
+
+	// Block the tx was included in (only checked for non-canonical blocks with -checktx), 0 if not found
+	IncludedIn    uint64
+	IncludedInErr error
+}
+
+type blockSimResult struct {
+	Block          *types.Block
+	CanonicalBlock *types.Block // block at the same height in the canonical chain, if Block is not canonical
+	Sim            flashbotsrpc.FlashbotsCallBundleResponse
+
+	Txs                     []txResult // by coinbase diff, highest first
+	NumTxNeededFor80Percent int        // number of most valuable tx that make up 80% of the coinbase diff
+	TxInclusionChecked      bool
+	TxInclusionBlocks       map[uint64]int // number of tx by the block they were included in (0 = not included)
+
+	Reconciliation *reconciliation // with -reconcile
+}
+
+func (r *blockSimResult) IsCanonical() bool {
+	return r.CanonicalBlock == nil
+}
+
+var addressLookupLock sync.Mutex // the address lookup service is not safe for concurrent use (range mode)
+
+// addressName returns the name of an address if known. With lookup, addresses not in the cache are looked up (slow).
+func addressName(address string, lookup bool) string {
+	addressLookupLock.Lock()
+	defer addressLookupLock.Unlock()
+
+	if lookup {
+		detail, _ := addressLookup.GetAddressDetail(address)
+		return detail.Name
+	}
+	return addressLookup.Cache[strings.ToLower(address)].Name
+}
+
+// sortByCoinbaseDiff sorts the results by coinbase diff, highest first
+func sortByCoinbaseDiff(results []flashbotsrpc.FlashbotsCallBundleResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		a := fbcommon.StrToBigInt(results[i].CoinbaseDiff)
+		b := fbcommon.StrToBigInt(results[j].CoinbaseDiff)
+		return a.Cmp(b) == 1
+	})
+}
+
+// numTxNeededForValue returns how many of the results (sorted by coinbase diff) are needed to reach the percentage
+// of the total coinbase diff. Returns 0 if the total is not positive.
+func numTxNeededForValue(results []flashbotsrpc.FlashbotsCallBundleResult, total *big.Int, percent int64) int {
+	if total.Sign() <= 0 {
+		return 0
+	}
+
+	// Compare value*100 with total*percent, to avoid float rounding
+	target := new(big.Int).Mul(total, big.NewInt(percent))
+	currentValue := new(big.Int)
+	for i, entry := range results {
+		currentValue.Add(currentValue, fbcommon.StrToBigInt(entry.CoinbaseDiff))
+		if new(big.Int).Mul(currentValue, big.NewInt(100)).Cmp(target) >= 0 {
+			return i + 1
+		}
+	}
+	return len(results)
+}
+
+// emptySimResult is the result of a block without transactions, which cannot be simulated
+func emptySimResult(block *types.Block, canonicalBlock *types.Block) *blockSimResult {
+	return &blockSimResult{
+		Block:          block,
+		CanonicalBlock: canonicalBlock,
+		Sim: flashbotsrpc.FlashbotsCallBundleResponse{
+			BundleGasPrice:    "0",
+			CoinbaseDiff:      "0",
+			EthSentToCoinbase: "0",
+			GasFees:           "0",
+		},
+		TxInclusionBlocks: make(map[uint64]int),
+	}
+}
+
+// simulateBlock simulates all transactions of the block with eth_callBundle at mev-geth. With checkTx, the inclusion
+// block of each tx of a non-canonical block is looked up.
+func simulateBlock(block *types.Block, canonicalBlock *types.Block, checkTx bool) (*blockSimResult, error) {
+	privateKey, _ := crypto.GenerateKey()
+	sim, err := mevGethRpc.FlashbotsSimulateBlock(privateKey, block, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &blockSimResult{
+		Block:             block,
+		CanonicalBlock:    canonicalBlock,
+		Sim:               sim,
+		TxInclusionBlocks: make(map[uint64]int),
+	}
+
+	sortByCoinbaseDiff(sim.Results)
+	res.NumTxNeededFor80Percent = numTxNeededForValue(sim.Results, fbcommon.StrToBigInt(sim.CoinbaseDiff), 80)
+
+	// If an address is receiving at least 2 tx, load the address info
+	_addressUsed := make(map[string]bool)
+	for _, entry := range sim.Results {
+		if _addressUsed[entry.ToAddress] {
+			addressName(entry.ToAddress, true)
+		}
+		_addressUsed[entry.ToAddress] = true
+	}
+
+	res.TxInclusionChecked = checkTx && !res.IsCanonical()
+	for _, entry := range sim.Results {
+		tx := txResult{FlashbotsCallBundleResult: entry, ToName: addressName(entry.ToAddress, false)}
+
+		if res.TxInclusionChecked {
+			// Where was this tx included?
+			r, err := gethClient.TransactionReceipt(context.Background(), common.HexToHash(entry.TxHash))
+			if err != nil {
+				tx.IncludedInErr = err
+			} else {
+				tx.IncludedIn = r.BlockNumber.Uint64()
+			}
+			res.TxInclusionBlocks[tx.IncludedIn] += 1
+		}
+
+		res.Txs = append(res.Txs, tx)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+	flashbotsrpc "github.com/metachris/flashbots-rpc"
+	"github.com/metachris/flashbots/mevgethtest"
+	"github.com/metachris/go-ethutils/addresslookup"
+)
+
+var testCoinbase = common.HexToAddress("0xc0")
+
+// newTestBlock returns a block with a tx per gas price (in gwei), and a tx to the coinbase
+func newTestBlock(t *testing.T, extra string, gasPricesGwei ...int64) *types.Block {
+	key, _ := crypto.GenerateKey()
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+	to := common.HexToAddress("0x01")
+
+	var txs []*types.Transaction
+	sign := func(nonce uint64, to common.Address, gasPrice int64) {
+		tx, err := types.SignNewTx(key, signer, &types.LegacyTx{Nonce: nonce, To: &to, Gas: 21000, GasPrice: big.NewInt(gasPrice * params.GWei)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+	}
+	for i, gasPrice := range gasPricesGwei {
+		sign(uint64(i), to, gasPrice)
+	}
+	sign(uint64(len(gasPricesGwei)), testCoinbase, 100)
+
+	header := &types.Header{
+		Number:     big.NewInt(100),
+		ParentHash: common.HexToHash("0x99"),
+		Coinbase:   testCoinbase,
+		GasLimit:   30_000_000,
+		Difficulty: big.NewInt(1),
+		BaseFee:    big.NewInt(0),
+		Extra:      []byte(extra),
+	}
+	return types.NewBlock(header, txs, nil, nil, trie.NewStackTrie(nil))
+}
+
+func startTestServer(t *testing.T) *mevgethtest.Server {
+	server := mevgethtest.NewServer()
+	t.Cleanup(server.Close)
+
+	var err error
+	mevGethRpc = flashbotsrpc.NewFlashbotsRPC(server.URL)
+	gethClient, err = ethclient.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addressLookup = addresslookup.NewAddressLookupService(nil)
+	return server
+}
+
+func TestNumTxNeededForValue(t *testing.T) {
+	results := []flashbotsrpc.FlashbotsCallBundleResult{{CoinbaseDiff: "10"}, {CoinbaseDiff: "50"}, {CoinbaseDiff: "30"}, {CoinbaseDiff: "10"}}
+	sortByCoinbaseDiff(results)
+	if results[0].CoinbaseDiff != "50" || results[1].CoinbaseDiff != "30" || results[3].CoinbaseDiff != "10" {
+		t.Fatalf("wrong order: %v", results)
+	}
+
+	for _, tc := range []struct {
+		total   string
+		percent int64
+		want    int
+	}{
+		{"100", 80, 2}, // 50+30 = exactly 80%
+		{"100", 81, 3},
+		{"100", 50, 1},
+		{"100", 100, 4},
+		{"0", 80, 0},
+		{"-5", 80, 0},
+	} {
+		total, _ := new(big.Int).SetString(tc.total, 10)
+		if got := numTxNeededForValue(results, total, tc.percent); got != tc.want {
+			t.Errorf("total %s, %d%%: got %d, want %d", tc.total, tc.percent, got, tc.want)
+		}
+	}
+}
+
+func TestSimulateBlock(t *testing.T) {
+	server := startTestServer(t)
+
+	block := newTestBlock(t, "uncle", 10, 50, 30, 5)
+	canonicalBlock := newTestBlock(t, "canonical", 1)
+	txs := block.Transactions()
+	server.AddReceipt(txs[1].Hash(), 100) // in the canonical block
+	server.AddReceipt(txs[2].Hash(), 101)
+
+	res, err := simulateBlock(block, canonicalBlock, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The tx to the coinbase is not simulated, the rest is sorted by coinbase diff
+	if len(res.Txs) != 4 {
+		t.Fatalf("expected 4 simulated tx, got %d", len(res.Txs))
+	}
+	for i, txIndex := range []int{1, 2, 0, 3} {
+		if res.Txs[i].TxHash != txs[txIndex].Hash().Hex() {
+			t.Errorf("tx %d: expected tx %d of the block", i, txIndex)
+		}
+	}
+	if res.Sim.CoinbaseDiff != big.NewInt(95*21000*params.GWei).String() {
+		t.Errorf("wrong coinbase diff: %s", res.Sim.CoinbaseDiff)
+	}
+
+	// 50+30 of 95 gwei is more than 80%
+	if res.NumTxNeededFor80Percent != 2 {
+		t.Errorf("expected 2 tx for 80%%, got %d", res.NumTxNeededFor80Percent)
+	}
+
+	if !res.TxInclusionChecked || res.TxInclusionBlocks[100] != 1 || res.TxInclusionBlocks[101] != 1 || res.TxInclusionBlocks[0] != 2 {
+		t.Errorf("wrong inclusion blocks: %v", res.TxInclusionBlocks)
+	}
+	if res.Txs[0].IncludedIn != 100 || res.Txs[3].IncludedIn != 0 || res.Txs[3].IncludedInErr == nil {
+		t.Errorf("wrong inclusion of tx: %d, %d (%v)", res.Txs[0].IncludedIn, res.Txs[3].IncludedIn, res.Txs[3].IncludedInErr)
+	}
+
+	calls := server.Calls()
+	if len(calls) != 1 || calls[0].BlockNumber != "0x64" || calls[0].StateBlockNumber != block.ParentHash().Hex() {
+		t.Errorf("unexpected eth_callBundle calls: %+v", calls)
+	}
+}
+
+func TestSimulateBlockFixture(t *testing.T) {
+	server := startTestServer(t)
+	server.CallBundle = mevgethtest.FixtureResponses(map[string]flashbotsrpc.FlashbotsCallBundleResponse{
+		"0x64": mevgethtest.NewResponse([]flashbotsrpc.FlashbotsCallBundleResult{
+			{TxHash: "0xa", CoinbaseDiff: "100", GasFees: "100", EthSentToCoinbase: "0", GasUsed: 21000},
+			{TxHash: "0xb", CoinbaseDiff: "900", GasFees: "100", EthSentToCoinbase: "800", GasUsed: 100000},
+		}),
+	})
+
+	res, err := simulateBlock(newTestBlock(t, "", 1), nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.TxInclusionChecked || res.Txs[0].TxHash != "0xb" || res.NumTxNeededFor80Percent != 1 || res.Sim.EthSentToCoinbase != "800" {
+		t.Errorf("unexpected result: %+v", res)
+	}
+}
@@ -0,0 +1,185 @@
+// Fill, export and import the local block store used by history-check and the experiments (-db)
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metachris/flashbots/blockstore"
+	"github.com/metachris/flashbots/common"
+	"github.com/metachris/go-ethutils/utils"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] fetch|export|import\n\n", os.Args[0])
+	fmt.Fprintln(flag.CommandLine.Output(), "  fetch   download blocks, receipts and mev-blocks API data of -start ... -end into the store")
+	fmt.Fprintln(flag.CommandLine.Output(), "  export  write the stored data of -start ... -end to -file (JSON lines, gzipped if the name ends with .gz)")
+	fmt.Fprintln(flag.CommandLine.Output(), "  import  read an export -file into the store")
+	fmt.Fprintln(flag.CommandLine.Output())
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+
+	dbPath := flag.String("db", "", "block store directory")
+	ethUri := flag.String("eth", os.Getenv("ETH_NODE"), "Ethereum node URI (for fetch, and to resolve -start/-end for export if given)")
+	startSpec := flag.String("start", "", "start: "+common.BlockRangeHelp)
+	endSpec := flag.String("end", "", "end (optional, default latest block): same as start, or duration relative to start (eg. 1d12h)")
+	filename := flag.String("file", "", "export/import file")
+	concurrencyPtr := flag.Int("concurrency", 15, "number of blocks downloaded in parallel")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *dbPath == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	store, err := blockstore.Open(*dbPath)
+	utils.Perror(err)
+	defer store.Close()
+
+	switch cmd := flag.Arg(0); cmd {
+	case "fetch":
+		if *ethUri == "" {
+			log.Fatal("Missing eth node uri")
+		}
+		fetcher, err := common.DialBlockFetcher(*ethUri)
+		utils.Perror(err)
+		reader := &blockstore.Reader{Store: store, Client: fetcher.Client, FetchWithReceipts: fetcher.GetBlockWithTxReceipts}
+
+		startBlock, endBlock := resolveRange(reader, *startSpec, *endSpec)
+		fetch(reader, startBlock, endBlock, *concurrencyPtr)
+
+	case "export":
+		var reader common.HeaderByNumberReader = store
+		if *ethUri != "" {
+			fetcher, err := common.DialBlockFetcher(*ethUri)
+			utils.Perror(err)
+			reader = fetcher.Client
+		}
+		startBlock, endBlock := resolveRange(reader, *startSpec, *endSpec)
+
+		w := createFile(*filename)
+		numRecords, err := store.Export(w, startBlock, endBlock)
+		utils.Perror(err)
+		utils.Perror(w.Close())
+		fmt.Printf("Exported %d blocks to %s\n", numRecords, *filename)
+
+	case "import":
+		r := openFile(*filename)
+		numRecords, err := store.Import(r)
+		utils.Perror(err)
+		r.Close()
+		fmt.Printf("Imported %d blocks from %s\n", numRecords, *filename)
+
+	default:
+		log.Fatalf("Unknown command: %s", cmd)
+	}
+}
+
+func resolveRange(reader common.HeaderByNumberReader, startSpec string, endSpec string) (startBlock int64, endBlock int64) {
+	if startSpec == "" {
+		log.Fatal("Missing start")
+	}
+	startBlock, endBlock, err := common.ResolveBlockRange(context.Background(), reader, startSpec, endSpec)
+	utils.Perror(err)
+	fmt.Println("blocks", startBlock, "...", endBlock)
+	return startBlock, endBlock
+}
+
+// fetch downloads all blocks of the range that are not yet in the store
+func fetch(reader *blockstore.Reader, startBlock int64, endBlock int64, concurrency int) {
+	timeStart := time.Now()
+
+	fmt.Print("Fetching flashbots blocks... ")
+	if err := reader.CacheFlashbotsBlocks(startBlock, endBlock); err != nil {
+		fmt.Printf("error: %v\n", err)
+	} else {
+		fmt.Print("done\n")
+	}
+
+	stats := common.NewThroughputStats()
+	statsCtx, stopStats := context.WithCancel(context.Background())
+	go stats.Report(statsCtx, 30*time.Second, nil)
+
+	var wg sync.WaitGroup
+	heightChan := make(chan int64, 100)
+	failed := make(chan int64, endBlock-startBlock+1)
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for height := range heightChan {
+				block, err := reader.GetBlockWithTxReceipts(context.Background(), height)
+				if err != nil {
+					log.Printf("Error getting block %d: %v\n", height, err)
+					failed <- height
+					continue
+				}
+				stats.Add(1, len(block.Block.Transactions()))
+			}
+		}()
+	}
+
+	for height := startBlock; height <= endBlock; height++ {
+		heightChan <- height
+	}
+	close(heightChan)
+	wg.Wait()
+	stopStats()
+	close(failed)
+
+	fmt.Printf("Fetched %s in %.2fs\n", stats.String(), time.Since(timeStart).Seconds())
+	if len(failed) > 0 {
+		fmt.Printf("%d blocks could not be fetched, run again to retry them\n", len(failed))
+	}
+}
+
+func createFile(filename string) io.WriteCloser {
+	if filename == "" {
+		log.Fatal("Missing -file")
+	}
+	f, err := os.Create(filename)
+	utils.Perror(err)
+	if strings.HasSuffix(filename, ".gz") {
+		return &gzipFile{Writer: gzip.NewWriter(f), f: f}
+	}
+	return f
+}
+
+func openFile(filename string) io.ReadCloser {
+	if filename == "" {
+		log.Fatal("Missing -file")
+	}
+	f, err := os.Open(filename)
+	utils.Perror(err)
+	if strings.HasSuffix(filename, ".gz") {
+		r, err := gzip.NewReader(f)
+		utils.Perror(err)
+		return r // closing the file is left to the exit
+	}
+	return f
+}
+
+// gzipFile closes the gzip writer and the file
+type gzipFile struct {
+	*gzip.Writer
+	f *os.File
+}
+
+func (g *gzipFile) Close() error {
+	if err := g.Writer.Close(); err != nil {
+		return err
+	}
+	return g.f.Close()
+}
@@ -0,0 +1,216 @@
+// Simulates each Flashbots bundle of a block in isolation at the parent state (eth_callBundle at an mev-geth instance),
+// and compares the simulated coinbase diff and gas used with TotalMinerReward / TotalGasUsed of the mev-blocks API.
+//
+// Example arguments:
+//
+//	$ go run cmd/bundlesim/main.go -mevgeth http://xxx.xxx.xxx.xxx:8545 -number 13100622
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	flashbotsrpc "github.com/metachris/flashbots-rpc"
+	"github.com/metachris/flashbots/blockcheck"
+	fbcommon "github.com/metachris/flashbots/common"
+	"github.com/metachris/go-ethutils/utils"
+)
+
+var mevGethRpc *flashbotsrpc.FlashbotsRPC
+
+// Progress messages go to stderr with json output, to keep stdout parseable
+var progress io.Writer = os.Stdout
+
+// bundleSimResult compares the simulation of a bundle with the mev-blocks API data
+type bundleSimResult struct {
+	Bundle *fbcommon.Bundle
+	Sim    flashbotsrpc.FlashbotsCallBundleResponse
+	Err    error // the bundle could not be simulated
+
+	CoinbaseDiffDelta *big.Int // simulated - TotalMinerReward
+	GasUsedDelta      int64    // simulated - TotalGasUsed
+}
+
+func (r *bundleSimResult) Matches() bool {
+	return r.Err == nil && r.CoinbaseDiffDelta.Sign() == 0 && r.GasUsedDelta == 0
+}
+
+func main() {
+	mevGethUriPtr := flag.String("mevgeth", "", "mev-geth node URI")
+	blockNumber := flag.Int64("number", -1, "number of the block with the bundles")
+	outputPtr := flag.String("output", "text", "output format: text or json")
+	debugPtr := flag.Bool("debug", false, "print debug information")
+	flag.Parse()
+
+	if *mevGethUriPtr == "" {
+		log.Fatal("No mev geth URI provided")
+	}
+
+	if *blockNumber == -1 {
+		log.Fatal("No block number provided")
+	}
+
+	switch *outputPtr {
+	case "text":
+	case "json":
+		progress = os.Stderr
+		log.SetOutput(os.Stderr)
+	default:
+		log.Fatalf("Invalid output format: %s", *outputPtr)
+	}
+
+	client, err := ethclient.Dial(*mevGethUriPtr)
+	utils.Perror(err)
+	fmt.Fprintln(progress, "Connected to", *mevGethUriPtr)
+
+	mevGethRpc = flashbotsrpc.NewFlashbotsRPC(*mevGethUriPtr)
+	mevGethRpc.Debug = *debugPtr
+
+	block, err := client.BlockByNumber(context.Background(), big.NewInt(*blockNumber))
+	utils.Perror(err)
+
+	// Bundles from the mev-blocks API
+	check := &blockcheck.BlockCheck{Number: *blockNumber, EthBlock: block}
+	utils.Perror(check.QueryFlashbotsApi())
+	check.CreateBundles()
+	fmt.Fprintf(progress, "Block %d: %d tx, %d Flashbots bundles\n", block.NumberU64(), len(block.Transactions()), len(check.Bundles))
+
+	results := make([]*bundleSimResult, 0, len(check.Bundles))
+	for _, bundle := range check.Bundles {
+		results = append(results, simulateBundle(block, bundle))
+	}
+
+	if *outputPtr == "json" {
+		utils.Perror(writeJson(os.Stdout, block, results))
+	} else {
+		printResults(os.Stdout, results)
+	}
+}
+
+// simulateBundle simulates the transactions of the bundle at the state of the parent block
+func simulateBundle(block *types.Block, bundle *fbcommon.Bundle) *bundleSimResult {
+	res := &bundleSimResult{Bundle: bundle}
+
+	txs := make([]string, 0, len(bundle.Transactions))
+	for _, fbTx := range bundle.Transactions {
+		tx := block.Transaction(common.HexToHash(fbTx.Hash))
+		if tx == nil {
+			res.Err = fmt.Errorf("tx %s not in block", fbTx.Hash)
+			return res
+		}
+		rawTx, err := tx.MarshalBinary()
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		txs = append(txs, hexutil.Encode(rawTx))
+	}
+
+	params := flashbotsrpc.FlashbotsCallBundleParam{
+		Txs:              txs,
+		BlockNumber:      fmt.Sprintf("0x%x", block.Number()),
+		StateBlockNumber: block.ParentHash().Hex(),
+		Timestamp:        int64(block.Time()),
+		GasLimit:         block.GasLimit(),
+		Difficulty:       block.Difficulty().Uint64(),
+	}
+	if block.BaseFee() != nil {
+		params.BaseFee = block.BaseFee().Uint64()
+	}
+
+	privateKey, _ := crypto.GenerateKey()
+	res.Sim, res.Err = mevGethRpc.FlashbotsCallBundle(privateKey, params)
+	if res.Err != nil {
+		return res
+	}
+
+	res.CoinbaseDiffDelta = new(big.Int).Sub(fbcommon.StrToBigInt(res.Sim.CoinbaseDiff), bundle.TotalMinerReward)
+	res.GasUsedDelta = res.Sim.TotalGasUsed - bundle.TotalGasUsed.Int64()
+	return res
+}
+
+func weiToEthStr(wei *big.Int) string {
+	return utils.WeiBigIntToEthString(wei, 4)
+}
+
+func printResults(w io.Writer, results []*bundleSimResult) {
+	numMismatches := 0
+	for _, res := range results {
+		bundle := res.Bundle
+		fmt.Fprintf(w, "\nBundle %d: %d tx, api: minerReward=%s ETH, gasUsed=%d\n", bundle.Index, len(bundle.Transactions), weiToEthStr(bundle.TotalMinerReward), bundle.TotalGasUsed)
+		if res.Err != nil {
+			numMismatches += 1
+			fmt.Fprintf(w, "- simulation error: %v\n", res.Err)
+			continue
+		}
+
+		status := "ok"
+		if !res.Matches() {
+			numMismatches += 1
+			status = "MISMATCH"
+		}
+		fmt.Fprintf(w, "- simulated: cbD=%s ETH, gasUsed=%d \t diff: cbD=%s ETH, gasUsed=%d \t %s\n", weiToEthStr(fbcommon.StrToBigInt(res.Sim.CoinbaseDiff)), res.Sim.TotalGasUsed, weiToEthStr(res.CoinbaseDiffDelta), res.GasUsedDelta, status)
+		for _, tx := range res.Sim.Results {
+			fmt.Fprintf(w, "  %s \t cbD=%8s, gasUsed=%7d\n", tx.TxHash, weiToEthStr(fbcommon.StrToBigInt(tx.CoinbaseDiff)), tx.GasUsed)
+		}
+	}
+
+	fmt.Fprintf(w, "\n%d/%d bundles match the API data\n", len(results)-numMismatches, len(results))
+}
+
+type jsonBundleResult struct {
+	Index                 int64    `json:"bundle_index"`
+	TxHashes              []string `json:"tx_hashes"`
+	ApiTotalMinerReward   string   `json:"api_total_miner_reward"`
+	ApiTotalGasUsed       int64    `json:"api_total_gas_used"`
+	SimulatedCoinbaseDiff string   `json:"simulated_coinbase_diff,omitempty"`
+	SimulatedGasUsed      int64    `json:"simulated_gas_used,omitempty"`
+	CoinbaseDiffDelta     string   `json:"coinbase_diff_delta,omitempty"` // simulated - api
+	GasUsedDelta          int64    `json:"gas_used_delta"`
+	Matches               bool     `json:"matches"`
+	Error                 string   `json:"error,omitempty"`
+}
+
+func writeJson(w io.Writer, block *types.Block, results []*bundleSimResult) error {
+	out := struct {
+		BlockNumber uint64             `json:"block_number"`
+		BlockHash   string             `json:"block_hash"`
+		Bundles     []jsonBundleResult `json:"bundles"`
+	}{block.NumberU64(), block.Hash().Hex(), make([]jsonBundleResult, 0, len(results))}
+
+	for _, res := range results {
+		r := jsonBundleResult{
+			Index:               res.Bundle.Index,
+			ApiTotalMinerReward: res.Bundle.TotalMinerReward.String(),
+			ApiTotalGasUsed:     res.Bundle.TotalGasUsed.Int64(),
+			Matches:             res.Matches(),
+		}
+		for _, tx := range res.Bundle.Transactions {
+			r.TxHashes = append(r.TxHashes, tx.Hash)
+		}
+		if res.Err != nil {
+			r.Error = res.Err.Error()
+		} else {
+			r.SimulatedCoinbaseDiff = res.Sim.CoinbaseDiff
+			r.SimulatedGasUsed = res.Sim.TotalGasUsed
+			r.CoinbaseDiffDelta = res.CoinbaseDiffDelta.String()
+			r.GasUsedDelta = res.GasUsedDelta
+		}
+		out.Bundles = append(out.Bundles, r)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
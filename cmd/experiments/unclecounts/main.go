@@ -14,12 +14,14 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/metachris/flashbots/blockstore"
 	fbcommon "github.com/metachris/flashbots/common"
 	"github.com/metachris/go-ethutils/addresslookup"
 	"github.com/metachris/go-ethutils/utils"
 )
 
-var client *ethclient.Client
+var client blockstore.Client // eth node, or block store (which fetches missing blocks from the eth node)
+var offline bool
 var mevGethUri string
 
 var AddressLookup *addresslookup.AddressLookupService
@@ -37,54 +39,50 @@ func main() {
 	err = AddressLookup.AddAllAddresses()
 	utils.Perror(err)
 
-	mevGethUri = *flag.String("eth", os.Getenv("MEVGETH_NODE"), "mev-geth node URI")
-	startDate := flag.String("start", "", "time offset")
-	endDate := flag.String("end", "", "time offset")
-	// endDate := flag.String("end", "", "block number or time offset or date (yyyy-mm-dd) (optional)")
+	flag.StringVar(&mevGethUri, "eth", os.Getenv("MEVGETH_NODE"), "mev-geth node URI")
+	startSpec := flag.String("start", "", "start: "+fbcommon.BlockRangeHelp)
+	endSpec := flag.String("end", "", "end (optional, default latest block): same as start, or duration relative to start (eg. 1d12h)")
+	dbPath := flag.String("db", "", "local block store: read blocks from it first, and store downloaded blocks (without -eth: offline, only stored blocks)")
 	flag.Parse()
 
-	if mevGethUri == "" {
+	if mevGethUri == "" && *dbPath == "" {
 		log.Fatal("Missing eth node uri")
 	}
 
-	if *startDate == "" {
+	if *startSpec == "" {
 		log.Fatal("Missing start")
 	}
 
-	if *endDate == "" {
-		log.Fatal("Missing end")
+	var ethClient *ethclient.Client
+	if mevGethUri != "" {
+		fmt.Printf("Connecting to %s ... ", mevGethUri)
+		ethClient, err = ethclient.Dial(mevGethUri)
+		utils.Perror(err)
+		fmt.Printf("ok\n")
+		client = ethClient
 	}
 
-	fmt.Printf("Connecting to %s ... ", mevGethUri)
-	client, err = ethclient.Dial(mevGethUri)
-	utils.Perror(err)
-	fmt.Printf("ok\n")
-
-	// Find start block
-	startTime, err := utils.DateToTime(*startDate, 0, 0, 0)
-	utils.Perror(err)
-	// startTimeOffsetSec, err := fbcommon.TimeStringToSec(*startDate)
-	// utils.Perror(err)
-	// now := time.Now()
-	// startTime := now.Add(time.Duration(startTimeOffsetSec) * time.Second * -1)
-	startBlockHeader, err := utils.GetFirstBlockHeaderAtOrAfterTime(client, startTime)
-	utils.Perror(err)
-	tb1 := time.Unix(int64(startBlockHeader.Time), 0).UTC()
-	fmt.Println("first block:", startBlockHeader.Number, tb1)
+	if *dbPath != "" {
+		store, err := blockstore.Open(*dbPath)
+		utils.Perror(err)
+		defer store.Close()
 
-	// Find end block
-	endTime, err := utils.DateToTime(*endDate, 0, 0, 0)
-	utils.Perror(err)
+		reader := &blockstore.Reader{Store: store}
+		if ethClient != nil {
+			reader.Client = ethClient
+		} else {
+			offline = true
+		}
+		client = reader
+	}
 
-	// latestBlockHeader, err := client.HeaderByNumber(context.Background(), nil)
-	latestBlockHeader, err := utils.GetFirstBlockHeaderAtOrAfterTime(client, endTime)
+	// Find start and end block
+	startBlock, endBlock, err := fbcommon.ResolveBlockRange(context.Background(), client, *startSpec, *endSpec)
 	utils.Perror(err)
-	tb2 := time.Unix(int64(latestBlockHeader.Time), 0).UTC()
-	fmt.Println("last block: ", latestBlockHeader.Number, tb2)
+	fmt.Println("blocks", startBlock, "...", endBlock)
 
-	// fmt.Println("blocks", startBlock, "...", endBlock)
 	timeStartBlockProcessing := time.Now()
-	FindUncles(startBlockHeader.Number, latestBlockHeader.Number)
+	FindUncles(big.NewInt(startBlock), big.NewInt(endBlock))
 
 	// All done. Stop timer and print
 	timeNeededBlockProcessing := time.Since(timeStartBlockProcessing)
@@ -129,10 +127,19 @@ func FindUncles(startBlockNumber *big.Int, endBlockNumber *big.Int) {
 		}
 	}()
 
-	fbcommon.GetBlocks(blockChan, client, startBlockNumber.Int64(), endBlockNumber.Int64(), 15)
+	opts := fbcommon.DefaultGetBlocksOptions()
+	if offline {
+		opts.MaxRetries = 0 // missing blocks are not going to appear
+	}
+	result, err := fbcommon.GetBlocks(context.Background(), blockChan, client, startBlockNumber.Int64(), endBlockNumber.Int64(), opts)
+	utils.Perror(err)
 
 	close(blockChan)
 	analyzeLock.Lock() // wait until all blocks have been processed
+
+	if len(result.Failed) > 0 {
+		fmt.Printf("Warning: %d blocks could not be downloaded, the statistics are incomplete: %v\n", len(result.Failed), result.FailedHeights())
+	}
 }
 
 func PrintResult() {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -8,95 +9,195 @@ import (
 	"sync"
 	"time"
 
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/metachris/flashbots/blockcheck"
+	"github.com/metachris/flashbots/blockstore"
+	"github.com/metachris/flashbots/common"
+	"github.com/metachris/flashbots/nodepool"
+	"github.com/metachris/flashbots/resultsdb"
 	"github.com/metachris/go-ethutils/blockswithtx"
 	"github.com/metachris/go-ethutils/utils"
 )
 
-var errorSummary blockcheck.ErrorSummary = blockcheck.NewErrorSummary()
+var resultsDb *resultsdb.DB // optional, stores all check results
 
 func main() {
 	log.SetOutput(os.Stdout)
 
-	ethUri := flag.String("eth", os.Getenv("ETH_NODE"), "Ethereum node URI")
-	startDate := flag.String("start", "", "date (yyyy-mm-dd)")
-	endDate := flag.String("end", "", "date (yyyy-mm-dd)")
+	ethUri := flag.String("eth", os.Getenv("ETH_NODE"), "Ethereum node URI (or several, comma separated, for failover)")
+	ethCrossCheckPtr := flag.Bool("eth-crosscheck", false, "cross-check block hashes between the eth nodes")
+	startSpec := flag.String("start", "", "start: "+common.BlockRangeHelp)
+	endSpec := flag.String("end", "", "end (optional, default latest block): same as start, or duration relative to start (eg. 1d12h)")
+	stateFile := flag.String("state", "", "save checkpoints of processed blocks and the error summary to this file")
+	resumePtr := flag.Bool("resume", false, "resume the run saved in the -state file")
+	concurrencyPtr := flag.Int("concurrency", 15, "initial number of blocks downloaded in parallel")
+	maxConcurrencyPtr := flag.Int("max-concurrency", 50, "adapt the number of parallel downloads up to this, depending on node latency and errors (<= concurrency: fixed)")
+	maxRpsPtr := flag.Float64("max-rps", 0, "max block downloads per second (0 = no limit)")
+	mergePtr := flag.String("merge", "", "merge and print the summaries of state files of disjoint block ranges (comma separated)")
+	resultsDbPath := flag.String("results-db", "", "store the results of all checked blocks in this SQLite database")
+	dbPath := flag.String("db", "", "local block store: read blocks from it first, and store downloaded blocks (without -eth: offline, only stored blocks)")
 	flag.Parse()
 
-	if *startDate == "" || *endDate == "" {
-		log.Fatal("Missing date")
+	if *mergePtr != "" {
+		summary, err := mergeHistoryStates(parseFilenames(*mergePtr))
+		utils.Perror(err)
+		fmt.Println(summary.String())
+		return
 	}
 
-	if *ethUri == "" {
+	if *resumePtr && *stateFile == "" {
+		log.Fatal("-resume requires -state")
+	}
+
+	if *resumePtr && (*startSpec != "" || *endSpec != "") {
+		log.Fatal("-resume continues the block range of the -state file, it cannot be combined with -start or -end")
+	}
+
+	if !*resumePtr && *startSpec == "" {
+		log.Fatal("Missing start")
+	}
+
+	if *ethUri == "" && *dbPath == "" {
 		log.Fatal("Missing eth node uri")
 	}
 
-	fmt.Printf("Connecting to %s ... ", *ethUri)
-	client, err := ethclient.Dial(*ethUri)
-	utils.Perror(err)
-	fmt.Printf("ok\n")
+	// Blocks are fetched from the eth nodes and/or the block store
+	var err error
+	var headerReader common.HeaderByNumberReader
+	var fetcher common.BlockWithTxReceiptsReader
+	if *ethUri != "" {
+		uris := nodepool.ParseUris(*ethUri)
+		fmt.Printf("Connecting to %d eth node(s) ... ", len(uris))
+		pool, err := nodepool.Dial(uris)
+		utils.Perror(err)
+		fmt.Printf("ok\n")
+
+		if *ethCrossCheckPtr {
+			pool.CrossCheckConfirmations = 2
+		}
+		go pool.Run(context.Background(), 30*time.Second)
 
-	startTime, err := utils.DateToTime(*startDate, 0, 0, 0)
-	utils.Perror(err)
-	startBlockHeader, err := utils.GetFirstBlockHeaderAtOrAfterTime(client, startTime)
-	utils.Perror(err)
-	startBlock := startBlockHeader.Number.Int64()
+		headerReader = pool
+		fetcher = pool
+	}
 
-	endTime, err := utils.DateToTime(*endDate, 0, 0, 0)
-	// endTime, err := utils.DateToTime(*startDate, 5, 10, 0)
-	utils.Perror(err)
-	endBlockHeader, err := utils.GetFirstBlockHeaderAtOrAfterTime(client, endTime)
-	utils.Perror(err)
-	endBlock := endBlockHeader.Number.Int64()
+	var store *blockstore.Store
+	if *dbPath != "" {
+		store, err = blockstore.Open(*dbPath)
+		utils.Perror(err)
+		defer store.Close()
 
-	fmt.Println("blocks", startBlock, "...", endBlock)
+		reader := &blockstore.Reader{Store: store}
+		if fetcher != nil {
+			reader.FetchWithReceipts = fetcher.GetBlockWithTxReceipts
+		}
+		if headerReader == nil {
+			headerReader = store
+		}
+		fetcher = reader
+	}
+
+	if *resultsDbPath != "" {
+		resultsDb, err = resultsdb.Open(*resultsDbPath)
+		utils.Perror(err)
+		defer resultsDb.Close()
+	}
+
+	var state *historyState
+	if *resumePtr {
+		state, err = loadHistoryState(*stateFile)
+		utils.Perror(err)
+		fmt.Printf("Resuming blocks %d ... %d, processed until %d\n", state.StartBlock, state.EndBlock, state.ProcessedUntil)
+	} else {
+		if *stateFile != "" {
+			if _, err := os.Stat(*stateFile); err == nil {
+				log.Fatalf("State file %s exists, use -resume to continue it", *stateFile)
+			}
+		}
+
+		startBlock, endBlock, err := common.ResolveBlockRange(context.Background(), headerReader, *startSpec, *endSpec)
+		utils.Perror(err)
+		state = newHistoryState(startBlock, endBlock)
+	}
+
+	fmt.Println("blocks", state.StartBlock, "...", state.EndBlock)
 
 	timestampMainStart := time.Now() // for measuring execution time
 
 	// Prefetch Flashbots blocks
 	fmt.Print("Caching flashbots blocks... ")
-	blockcheck.CacheFlashbotsBlocks(startBlock, endBlock)
+	if store != nil {
+		err = (&blockstore.Reader{Store: store}).CacheFlashbotsBlocks(state.ProcessedUntil+1, state.EndBlock)
+	} else {
+		err = blockcheck.CacheFlashbotsBlocks(state.ProcessedUntil+1, state.EndBlock)
+	}
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
 	fmt.Print("done\n")
 
 	// Start fetching blocks
 	blockChan := make(chan *blockswithtx.BlockWithTxReceipts, 100) // channel for resulting BlockWithTxReceipt
 
-	// Start block processor
-	var numBlocksProcessed int
-	var numTxProcessed int
+	// Start block processor (the only goroutine that updates the state)
 	var analyzeLock sync.Mutex
 	go func() {
 		analyzeLock.Lock()
 		defer analyzeLock.Unlock() // we unlock when done
 
+		lastCheckpoint := time.Now()
 		for block := range blockChan {
-			numBlocksProcessed += 1
-			numTxProcessed += len(block.Block.Transactions())
-			processBlockWithReceipts(block, client)
+			processBlockWithReceipts(block, state)
+
+			if *stateFile != "" && time.Since(lastCheckpoint) > checkpointInterval {
+				if err := state.save(*stateFile); err != nil {
+					log.Println("Error saving checkpoint:", err)
+				}
+				lastCheckpoint = time.Now()
+			}
 		}
 	}()
 
 	// Start fetching and processing blocks
-	blockswithtx.GetBlocksWithTxReceipts(client, blockChan, startBlock, endBlock, 15)
+	fetchOpts := common.DefaultGetBlocksOptions()
+	fetchOpts.Concurrency = *concurrencyPtr
+	fetchOpts.MaxConcurrency = *maxConcurrencyPtr
+	fetchOpts.MaxRequestsPerSecond = *maxRpsPtr
+	_, err = common.GetBlocksWithTxReceipts(context.Background(), blockChan, fetcher, state.remainingBlocks(), fetchOpts)
+	utils.Perror(err)
 
 	// Wait for processing to finish
 	fmt.Println("Waiting for Analysis workers...")
 	close(blockChan)
 	analyzeLock.Lock() // wait until all blocks have been processed
 
-	fmt.Println(errorSummary.String())
+	if *stateFile != "" {
+		utils.Perror(state.save(*stateFile))
+	}
+
+	fmt.Println(state.ErrorSummary.String())
 
 	timeNeeded := time.Since(timestampMainStart)
-	fmt.Printf("Analysis of %s blocks, %s transactions finished in %.2fs\n", utils.NumberToHumanReadableString(numBlocksProcessed, 0), utils.NumberToHumanReadableString(numTxProcessed, 0), timeNeeded.Seconds())
+	fmt.Printf("Analysis of %s blocks, %s transactions finished in %.2fs\n", utils.NumberToHumanReadableString(state.NumBlocksProcessed, 0), utils.NumberToHumanReadableString(state.NumTxProcessed, 0), timeNeeded.Seconds())
+
+	if !state.isComplete() {
+		fmt.Printf("%d blocks could not be processed, run again with -resume to retry them\n", len(state.remainingBlocks()))
+	}
 }
 
-func processBlockWithReceipts(block *blockswithtx.BlockWithTxReceipts, client *ethclient.Client) {
+func processBlockWithReceipts(block *blockswithtx.BlockWithTxReceipts, state *historyState) {
 	utils.PrintBlock(block.Block)
 	check, err := blockcheck.CheckBlock(block, true)
 	utils.Perror(err)
 
+	if resultsDb != nil {
+		utils.Perror(resultsDb.SaveCheck(check))
+	}
+
 	if check.HasSeriousErrors() || check.HasLessSeriousErrors() { // update and print miner error count on serious and less-serious errors
-		errorSummary.AddCheckErrors(check)
+		state.ErrorSummary.AddCheckErrors(check)
 	}
+
+	state.NumBlocksProcessed += 1
+	state.NumTxProcessed += len(block.Block.Transactions())
+	state.setProcessed(block.Block.Number().Int64())
 }
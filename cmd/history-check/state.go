@@ -0,0 +1,136 @@
+// Checkpoints of a history-check run (-state), to resume after a crash (-resume) and to merge runs over disjoint
+// block ranges (-merge)
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/metachris/flashbots/blockcheck"
+	"github.com/metachris/flashbots/common"
+)
+
+const checkpointInterval = 30 * time.Second
+
+type historyState struct {
+	SavedAt    time.Time `json:"saved_at"`
+	StartBlock int64     `json:"start_block"`
+	EndBlock   int64     `json:"end_block"`
+
+	// All blocks from StartBlock up to ProcessedUntil are processed, and the blocks listed in Processed above that
+	ProcessedUntil int64   `json:"processed_until"`
+	Processed      []int64 `json:"processed"`
+
+	NumBlocksProcessed int                     `json:"num_blocks_processed"`
+	NumTxProcessed     int                     `json:"num_tx_processed"`
+	ErrorSummary       blockcheck.ErrorSummary `json:"error_summary"`
+}
+
+func newHistoryState(startBlock int64, endBlock int64) *historyState {
+	return &historyState{
+		StartBlock:     startBlock,
+		EndBlock:       endBlock,
+		ProcessedUntil: startBlock - 1,
+		Processed:      make([]int64, 0),
+		ErrorSummary:   blockcheck.NewErrorSummary(),
+	}
+}
+
+func loadHistoryState(filename string) (*historyState, error) {
+	state := &historyState{}
+	if err := common.ReadJsonFile(filename, state); err != nil {
+		return nil, err
+	}
+	if state.ErrorSummary.MinerErrors == nil {
+		state.ErrorSummary = blockcheck.NewErrorSummary()
+	}
+	return state, nil
+}
+
+func (s *historyState) save(filename string) error {
+	s.SavedAt = time.Now().UTC()
+	return common.WriteJsonFile(filename, s)
+}
+
+func (s *historyState) isProcessed(height int64) bool {
+	if height <= s.ProcessedUntil {
+		return true
+	}
+	i := sort.Search(len(s.Processed), func(i int) bool { return s.Processed[i] >= height })
+	return i < len(s.Processed) && s.Processed[i] == height
+}
+
+// setProcessed marks a block as processed, and advances ProcessedUntil as far as possible
+func (s *historyState) setProcessed(height int64) {
+	if s.isProcessed(height) {
+		return
+	}
+
+	i := sort.Search(len(s.Processed), func(i int) bool { return s.Processed[i] >= height })
+	s.Processed = append(s.Processed, 0)
+	copy(s.Processed[i+1:], s.Processed[i:])
+	s.Processed[i] = height
+
+	for len(s.Processed) > 0 && s.Processed[0] == s.ProcessedUntil+1 {
+		s.ProcessedUntil += 1
+		s.Processed = s.Processed[1:]
+	}
+}
+
+// remainingBlocks returns the heights of all blocks not yet processed
+func (s *historyState) remainingBlocks() []int64 {
+	res := make([]int64, 0)
+	for height := s.ProcessedUntil + 1; height <= s.EndBlock; height++ {
+		if !s.isProcessed(height) {
+			res = append(res, height)
+		}
+	}
+	return res
+}
+
+func (s *historyState) isComplete() bool {
+	return s.ProcessedUntil >= s.EndBlock
+}
+
+// mergeHistoryStates combines the summaries of runs over disjoint block ranges
+func mergeHistoryStates(filenames []string) (*blockcheck.ErrorSummary, error) {
+	states := make([]*historyState, 0, len(filenames))
+	for _, filename := range filenames {
+		state, err := loadHistoryState(filename)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		states = append(states, state)
+
+		status := "complete"
+		if !state.isComplete() {
+			status = fmt.Sprintf("incomplete, %d blocks remaining", len(state.remainingBlocks()))
+		}
+		fmt.Printf("%s: blocks %d ... %d, %s\n", filename, state.StartBlock, state.EndBlock, status)
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].StartBlock < states[j].StartBlock })
+	for i := 1; i < len(states); i++ {
+		if states[i].StartBlock <= states[i-1].EndBlock {
+			return nil, fmt.Errorf("block ranges %d ... %d and %d ... %d overlap", states[i-1].StartBlock, states[i-1].EndBlock, states[i].StartBlock, states[i].EndBlock)
+		}
+	}
+
+	summary := blockcheck.NewErrorSummary()
+	for _, state := range states {
+		summary.Merge(state.ErrorSummary)
+	}
+	return &summary, nil
+}
+
+func parseFilenames(s string) []string {
+	res := make([]string, 0)
+	for _, filename := range strings.Split(s, ",") {
+		if filename = strings.TrimSpace(filename); filename != "" {
+			res = append(res, filename)
+		}
+	}
+	return res
+}
@@ -0,0 +1,209 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+// Node support for eth_getBlockReceipts and batch requests, detected on first use
+const (
+	supportUnknown int32 = iota
+	supported
+	unsupported
+)
+
+// eth_getBlockReceipts is not used anymore after this many consecutive failures (eg. a node that serves it with
+// incomplete or wrong results)
+const maxBlockReceiptsFailures = 3
+
+// BlockFetcher loads a block with all tx receipts in two round trips: the block, and then the receipts with
+// eth_getBlockReceipts (if the node supports it) or with a JSON-RPC batch of eth_getTransactionReceipt calls. Falls
+// back to one request per receipt if the node does not support batches.
+type BlockFetcher struct {
+	Client    *ethclient.Client
+	rpc       *rpc.Client
+	BatchSize int // max receipts per batch request
+
+	blockReceipts         int32
+	blockReceiptsFailures int32 // consecutive failures of eth_getBlockReceipts
+	batch                 int32
+}
+
+func NewBlockFetcher(rpcClient *rpc.Client) *BlockFetcher {
+	return &BlockFetcher{
+		Client:    ethclient.NewClient(rpcClient),
+		rpc:       rpcClient,
+		BatchSize: 200,
+	}
+}
+
+func DialBlockFetcher(uri string) (*BlockFetcher, error) {
+	rpcClient, err := rpc.Dial(uri)
+	if err != nil {
+		return nil, err
+	}
+	return NewBlockFetcher(rpcClient), nil
+}
+
+// GetBlockWithTxReceipts is like blockswithtx.GetBlockWithTxReceipts, with batched receipt requests
+func (f *BlockFetcher) GetBlockWithTxReceipts(ctx context.Context, height int64) (*blockswithtx.BlockWithTxReceipts, error) {
+	block, err := f.Client.BlockByNumber(ctx, big.NewInt(height))
+	if err != nil {
+		return nil, err
+	}
+	return f.withReceipts(ctx, block)
+}
+
+// GetBlockWithTxReceiptsByHash fetches the block by hash, so the result is exactly the requested block even if the
+// canonical chain changes meanwhile
+func (f *BlockFetcher) GetBlockWithTxReceiptsByHash(ctx context.Context, hash ethcommon.Hash) (*blockswithtx.BlockWithTxReceipts, error) {
+	block, err := f.Client.BlockByHash(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	return f.withReceipts(ctx, block)
+}
+
+func (f *BlockFetcher) withReceipts(ctx context.Context, block *types.Block) (*blockswithtx.BlockWithTxReceipts, error) {
+	res := &blockswithtx.BlockWithTxReceipts{
+		Block:      block,
+		TxReceipts: make(map[ethcommon.Hash]*types.Receipt),
+	}
+
+	if len(block.Transactions()) == 0 {
+		return res, nil
+	}
+
+	if atomic.LoadInt32(&f.blockReceipts) != unsupported {
+		receipts, err := f.getBlockReceipts(ctx, block)
+		if err == nil {
+			for _, receipt := range receipts {
+				res.TxReceipts[receipt.TxHash] = receipt
+			}
+			return res, nil
+		} else if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+
+	receipts, err := f.getTransactionReceipts(ctx, block.Transactions())
+	if err != nil {
+		return nil, err
+	}
+	for _, receipt := range receipts {
+		res.TxReceipts[receipt.TxHash] = receipt
+	}
+	return res, nil
+}
+
+func isMethodNotFound(err error) bool {
+	var rpcErr rpc.Error
+	return errors.As(err, &rpcErr) && rpcErr.ErrorCode() == -32601
+}
+
+// getBlockReceipts uses eth_getBlockReceipts, and marks it unsupported if the node does not know the method or it
+// failed maxBlockReceiptsFailures times in a row
+func (f *BlockFetcher) getBlockReceipts(ctx context.Context, block *types.Block) ([]*types.Receipt, error) {
+	receipts, err := f.callBlockReceipts(ctx, block)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if isMethodNotFound(err) || atomic.AddInt32(&f.blockReceiptsFailures, 1) >= maxBlockReceiptsFailures {
+			atomic.StoreInt32(&f.blockReceipts, unsupported)
+		}
+		return nil, err
+	}
+
+	atomic.StoreInt32(&f.blockReceiptsFailures, 0)
+	atomic.StoreInt32(&f.blockReceipts, supported)
+	return receipts, nil
+}
+
+func (f *BlockFetcher) callBlockReceipts(ctx context.Context, block *types.Block) ([]*types.Receipt, error) {
+	var receipts []*types.Receipt
+	err := f.rpc.CallContext(ctx, &receipts, "eth_getBlockReceipts", block.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	if len(receipts) != len(block.Transactions()) {
+		return nil, fmt.Errorf("eth_getBlockReceipts returned %d receipts for %d transactions", len(receipts), len(block.Transactions()))
+	}
+	for _, receipt := range receipts {
+		if receipt == nil || receipt.BlockHash != block.Hash() {
+			return nil, errors.New("eth_getBlockReceipts returned receipts of another block")
+		}
+	}
+	return receipts, nil
+}
+
+// getTransactionReceipts gets the receipts in batches of eth_getTransactionReceipt calls (or one by one if the node
+// does not support batches). Transactions without receipt are skipped.
+func (f *BlockFetcher) getTransactionReceipts(ctx context.Context, txs types.Transactions) ([]*types.Receipt, error) {
+	res := make([]*types.Receipt, 0, len(txs))
+
+	if atomic.LoadInt32(&f.batch) != unsupported {
+		for start := 0; start < len(txs); start += f.BatchSize {
+			end := start + f.BatchSize
+			if end > len(txs) {
+				end = len(txs)
+			}
+
+			receipts := make([]*types.Receipt, end-start)
+			batch := make([]rpc.BatchElem, end-start)
+			for i, tx := range txs[start:end] {
+				batch[i] = rpc.BatchElem{Method: "eth_getTransactionReceipt", Args: []interface{}{tx.Hash()}, Result: &receipts[i]}
+			}
+
+			if err := f.rpc.BatchCallContext(ctx, batch); err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				res = res[:0]
+				break // retry without batches below
+			}
+
+			for i, elem := range batch {
+				if elem.Error != nil {
+					return nil, elem.Error
+				}
+				if receipts[i] != nil { // can apparently happen if 0 tx: https://etherscan.io/block/10102170
+					res = append(res, receipts[i])
+				}
+			}
+
+			if end == len(txs) {
+				atomic.StoreInt32(&f.batch, supported)
+				return res, nil
+			}
+		}
+	}
+
+	for _, tx := range txs {
+		receipt, err := f.Client.TransactionReceipt(ctx, tx.Hash())
+		if err != nil {
+			if errors.Is(err, ethereum.NotFound) {
+				continue
+			}
+			return nil, err
+		}
+		res = append(res, receipt)
+	}
+
+	// Batches failed, but single requests work
+	if atomic.LoadInt32(&f.batch) == supportUnknown {
+		atomic.StoreInt32(&f.batch, unsupported)
+	}
+	return res, nil
+}
@@ -0,0 +1,160 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+	"testing"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// testReceiptsService serves one block with receipts. Receipt of the last tx is missing (like for some old blocks).
+type testReceiptsService struct {
+	block          *types.Block
+	receipts       map[ethcommon.Hash]*types.Receipt
+	numReceiptReqs int32
+}
+
+func newTestReceiptsService(numTx int) *testReceiptsService {
+	txs := make([]*types.Transaction, numTx)
+	receipts := make([]*types.Receipt, numTx)
+	for i := range txs {
+		txs[i] = types.NewTransaction(uint64(i), ethcommon.Address{1}, big.NewInt(1), 21000, big.NewInt(1), nil)
+		receipts[i] = &types.Receipt{Status: 1, CumulativeGasUsed: uint64(21000 * (i + 1)), GasUsed: 21000, TxHash: txs[i].Hash(), Logs: []*types.Log{}}
+	}
+
+	header := &types.Header{Number: big.NewInt(100), Difficulty: big.NewInt(1)}
+	block := types.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil))
+
+	s := &testReceiptsService{block: block, receipts: make(map[ethcommon.Hash]*types.Receipt)}
+	for i, receipt := range receipts {
+		receipt.BlockHash = block.Hash()
+		receipt.BlockNumber = block.Number()
+		receipt.TransactionIndex = uint(i)
+		if i < numTx-1 {
+			s.receipts[receipt.TxHash] = receipt
+		}
+	}
+	return s
+}
+
+func (s *testReceiptsService) blockJson() (map[string]interface{}, error) {
+	var res map[string]interface{}
+	b, err := json.Marshal(s.block.Header())
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &res); err != nil {
+		return nil, err
+	}
+	res["transactions"] = s.block.Transactions()
+	res["uncles"] = []ethcommon.Hash{}
+	return res, nil
+}
+
+func (s *testReceiptsService) GetBlockByNumber(number string, full bool) (map[string]interface{}, error) {
+	return s.blockJson()
+}
+
+func (s *testReceiptsService) GetBlockByHash(hash ethcommon.Hash, full bool) (map[string]interface{}, error) {
+	if hash != s.block.Hash() {
+		return nil, nil
+	}
+	return s.blockJson()
+}
+
+func (s *testReceiptsService) GetTransactionReceipt(hash ethcommon.Hash) (*types.Receipt, error) {
+	atomic.AddInt32(&s.numReceiptReqs, 1)
+	return s.receipts[hash], nil
+}
+
+// testBlockReceiptsService also serves eth_getBlockReceipts
+type testBlockReceiptsService struct {
+	*testReceiptsService
+}
+
+func (s testBlockReceiptsService) GetBlockReceipts(hash ethcommon.Hash) ([]*types.Receipt, error) {
+	receipts := make([]*types.Receipt, 0)
+	for _, tx := range s.block.Transactions() {
+		if receipt, found := s.receipts[tx.Hash()]; found {
+			receipts = append(receipts, receipt)
+		}
+	}
+	return receipts, nil
+}
+
+func newTestFetcher(t *testing.T, service interface{}) *BlockFetcher {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", service); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	return NewBlockFetcher(rpc.DialInProc(server))
+}
+
+func TestBlockFetcherBatch(t *testing.T) {
+	service := newTestReceiptsService(25)
+	fetcher := newTestFetcher(t, service)
+	fetcher.BatchSize = 10
+
+	block, err := fetcher.GetBlockWithTxReceiptsByHash(context.Background(), service.block.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Block.Hash() != service.block.Hash() || len(block.TxReceipts) != 24 {
+		t.Fatalf("unexpected block %s with %d receipts", block.Block.Hash(), len(block.TxReceipts))
+	}
+	for _, tx := range service.block.Transactions()[:24] {
+		if receipt := block.TxReceipts[tx.Hash()]; receipt == nil || receipt.GasUsed != 21000 {
+			t.Errorf("wrong receipt for tx %s: %v", tx.Hash(), receipt)
+		}
+	}
+
+	// eth_getBlockReceipts is not available, and not tried again
+	if fetcher.blockReceipts != unsupported || fetcher.batch != supported {
+		t.Errorf("unexpected support flags: blockReceipts=%d batch=%d", fetcher.blockReceipts, fetcher.batch)
+	}
+	if service.numReceiptReqs != 25 {
+		t.Errorf("expected 25 receipt requests, got %d", service.numReceiptReqs)
+	}
+}
+
+func TestBlockFetcherBlockReceipts(t *testing.T) {
+	// Block without missing receipts: all receipts in a single request
+	service := newTestReceiptsService(5)
+	service.receipts[service.block.Transactions()[4].Hash()] = &types.Receipt{TxHash: service.block.Transactions()[4].Hash(), BlockHash: service.block.Hash(), Logs: []*types.Log{}}
+	fetcher := newTestFetcher(t, testBlockReceiptsService{service})
+
+	block, err := fetcher.GetBlockWithTxReceipts(context.Background(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block.TxReceipts) != 5 || service.numReceiptReqs != 0 || fetcher.blockReceipts != supported {
+		t.Errorf("expected 5 receipts from eth_getBlockReceipts, got %d receipts and %d eth_getTransactionReceipt requests", len(block.TxReceipts), service.numReceiptReqs)
+	}
+
+	// Incomplete eth_getBlockReceipts response: falls back to eth_getTransactionReceipt for this block
+	delete(service.receipts, service.block.Transactions()[4].Hash())
+	block, err = fetcher.GetBlockWithTxReceipts(context.Background(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block.TxReceipts) != 4 || service.numReceiptReqs != 5 || fetcher.blockReceipts != supported {
+		t.Errorf("expected 4 receipts after fallback, got %d receipts and %d eth_getTransactionReceipt requests", len(block.TxReceipts), service.numReceiptReqs)
+	}
+
+	// After repeated failures, eth_getBlockReceipts is not used anymore
+	for i := 1; i < maxBlockReceiptsFailures; i++ {
+		if _, err := fetcher.GetBlockWithTxReceipts(context.Background(), 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fetcher.blockReceipts != unsupported {
+		t.Errorf("expected eth_getBlockReceipts to be unsupported after %d failures", maxBlockReceiptsFailures)
+	}
+}
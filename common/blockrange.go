@@ -0,0 +1,202 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// BlockRangeHelp describes the formats accepted by ResolveBlockRange, for flag descriptions
+const BlockRangeHelp = "block number, date (yyyy-mm-dd), timestamp (yyyy-mm-ddThh:mm[:ss][Z]) or duration relative to now (-6h, -2d, -1d12h)"
+
+var durationPartRegex = regexp.MustCompile(`(\d+)([wdhms])`)
+var durationRegex = regexp.MustCompile(`^([+-]?)((\d+[wdhms])+)$`)
+
+var durationUnits = map[string]time.Duration{
+	"w": 7 * 24 * time.Hour,
+	"d": 24 * time.Hour,
+	"h": time.Hour,
+	"m": time.Minute,
+	"s": time.Second,
+}
+
+// ParseDuration parses durations like 6h, -2d or 1d12h30m (units w, d, h, m, s, with an optional sign)
+func ParseDuration(s string) (time.Duration, error) {
+	match := durationRegex.FindStringSubmatch(s)
+	if match == nil {
+		return 0, fmt.Errorf("invalid duration: %s", s)
+	}
+
+	var duration time.Duration
+	for _, part := range durationPartRegex.FindAllStringSubmatch(match[2], -1) {
+		n, err := strconv.Atoi(part[1])
+		if err != nil {
+			return 0, err
+		}
+		duration += time.Duration(n) * durationUnits[part[2]]
+	}
+
+	if match[1] == "-" {
+		duration *= -1
+	}
+	return duration, nil
+}
+
+// Timestamp formats accepted in block range specs (UTC unless a zone is given)
+var timestampLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// BlockSpec is one side of a block range: either a block number, an absolute time, or a duration (relative to now
+// if negative, else relative to the start of the range)
+type BlockSpec struct {
+	Number   int64
+	Time     time.Time
+	Duration time.Duration
+	IsNumber bool
+	IsTime   bool
+}
+
+func ParseBlockSpec(s string) (spec BlockSpec, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return spec, errors.New("empty block range spec")
+	}
+
+	if number, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if number < 0 {
+			return spec, fmt.Errorf("invalid block number: %s", s)
+		}
+		return BlockSpec{Number: number, IsNumber: true}, nil
+	}
+
+	for _, layout := range timestampLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return BlockSpec{Time: t, IsTime: true}, nil
+		}
+	}
+
+	duration, err := ParseDuration(s)
+	if err != nil {
+		return spec, fmt.Errorf("invalid block range spec '%s' (expected %s)", s, BlockRangeHelp)
+	}
+	return BlockSpec{Duration: duration}, nil
+}
+
+// HeaderByNumberReader is implemented by ethclient.Client and nodepool.Pool
+type HeaderByNumberReader interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// FindFirstBlockAtOrAfterTime returns the number of the first block with a timestamp at or after t, using binary
+// search on headers. Returns latest block number + 1 if t is after the latest block.
+func FindFirstBlockAtOrAfterTime(ctx context.Context, client HeaderByNumberReader, t time.Time) (int64, error) {
+	latest, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	target := uint64(t.Unix())
+	if latest.Time < target {
+		return latest.Number.Int64() + 1, nil
+	}
+
+	// Invariant: block hi is at or after t, and all blocks below lo are before t
+	lo, hi := int64(0), latest.Number.Int64()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		header, err := client.HeaderByNumber(ctx, big.NewInt(mid))
+		if err != nil {
+			return 0, err
+		}
+
+		if header.Time >= target {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return hi, nil
+}
+
+// ResolveBlockRange resolves start and end specs (see BlockRangeHelp) to block numbers. The end is inclusive for
+// block numbers and exclusive for times (the last block before the time). Additionally, the end can be a duration
+// relative to the start (eg. 1d12h), or empty for the latest block.
+func ResolveBlockRange(ctx context.Context, client HeaderByNumberReader, start string, end string) (startBlock int64, endBlock int64, err error) {
+	startSpec, err := ParseBlockSpec(start)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var startTime time.Time
+	switch {
+	case startSpec.IsNumber:
+		startBlock = startSpec.Number
+	case startSpec.IsTime:
+		startTime = startSpec.Time
+	case startSpec.Duration < 0:
+		startTime = time.Now().Add(startSpec.Duration)
+	default:
+		return 0, 0, fmt.Errorf("start duration must be negative (relative to now): %s", start)
+	}
+
+	if startSpec.IsNumber {
+		header, err := client.HeaderByNumber(ctx, big.NewInt(startBlock))
+		if err != nil {
+			return 0, 0, fmt.Errorf("start block %d: %w", startBlock, err)
+		}
+		startTime = time.Unix(int64(header.Time), 0)
+	} else {
+		startBlock, err = FindFirstBlockAtOrAfterTime(ctx, client, startTime)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	var endSpec BlockSpec
+	if strings.TrimSpace(end) != "" {
+		endSpec, err = ParseBlockSpec(end)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if endSpec.IsNumber {
+		endBlock = endSpec.Number
+	} else {
+		var endTime time.Time
+		switch {
+		case endSpec.IsTime:
+			endTime = endSpec.Time
+		case endSpec.Duration < 0:
+			endTime = time.Now().Add(endSpec.Duration)
+		case endSpec.Duration > 0:
+			endTime = startTime.Add(endSpec.Duration)
+		default: // no end: latest block
+			endTime = time.Now().Add(time.Hour)
+		}
+
+		firstBlockAfterEnd, err := FindFirstBlockAtOrAfterTime(ctx, client, endTime)
+		if err != nil {
+			return 0, 0, err
+		}
+		endBlock = firstBlockAfterEnd - 1
+	}
+
+	if endBlock < startBlock {
+		return 0, 0, fmt.Errorf("end block %d is before start block %d", endBlock, startBlock)
+	}
+	return startBlock, endBlock, nil
+}
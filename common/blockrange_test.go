@@ -0,0 +1,120 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"6h":      6 * time.Hour,
+		"-2d":     -48 * time.Hour,
+		"1d12h":   36 * time.Hour,
+		"+1w":     7 * 24 * time.Hour,
+		"-1h30m":  -90 * time.Minute,
+		"90s":     90 * time.Second,
+		"2d3h4m5": 0, // invalid
+		"h":       0, // invalid
+		"":        0, // invalid
+	}
+
+	for s, expected := range tests {
+		duration, err := ParseDuration(s)
+		if expected == 0 {
+			if err == nil {
+				t.Errorf("expected error for '%s', got %s", s, duration)
+			}
+			continue
+		}
+
+		if err != nil || duration != expected {
+			t.Errorf("ParseDuration(%s) = %s, %v - expected %s", s, duration, err, expected)
+		}
+	}
+
+	sec, err := TimeStringToSec("-1d1h")
+	if err != nil || sec != -25*60*60 {
+		t.Error("unexpected TimeStringToSec result:", sec, err)
+	}
+}
+
+func TestParseBlockSpec(t *testing.T) {
+	spec, err := ParseBlockSpec("12965000")
+	if err != nil || !spec.IsNumber || spec.Number != 12965000 {
+		t.Error("block number:", spec, err)
+	}
+
+	spec, err = ParseBlockSpec("2021-08-01")
+	if err != nil || !spec.IsTime || !spec.Time.Equal(time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("date:", spec, err)
+	}
+
+	spec, err = ParseBlockSpec("2021-08-01T12:30")
+	if err != nil || !spec.IsTime || !spec.Time.Equal(time.Date(2021, 8, 1, 12, 30, 0, 0, time.UTC)) {
+		t.Error("timestamp:", spec, err)
+	}
+
+	spec, err = ParseBlockSpec("2021-08-01T12:30:00+02:00")
+	if err != nil || !spec.IsTime || !spec.Time.Equal(time.Date(2021, 8, 1, 10, 30, 0, 0, time.UTC)) {
+		t.Error("timestamp with zone:", spec, err)
+	}
+
+	spec, err = ParseBlockSpec("-6h")
+	if err != nil || spec.IsNumber || spec.IsTime || spec.Duration != -6*time.Hour {
+		t.Error("relative time:", spec, err)
+	}
+
+	for _, invalid := range []string{"", "-5", "yesterday", "2021-13-01"} {
+		if _, err := ParseBlockSpec(invalid); err == nil {
+			t.Errorf("expected error for '%s'", invalid)
+		}
+	}
+}
+
+// testHeaders serves headers of a chain with one block every 10 seconds, starting at genesisTime
+type testHeaders struct {
+	latest      int64
+	genesisTime int64
+}
+
+func (h *testHeaders) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	n := h.latest
+	if number != nil {
+		n = number.Int64()
+	}
+	if n > h.latest {
+		return nil, errors.New("not found")
+	}
+	return &types.Header{Number: big.NewInt(n), Time: uint64(h.genesisTime + n*10)}, nil
+}
+
+func TestResolveBlockRange(t *testing.T) {
+	genesis := time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC)
+	headers := &testHeaders{latest: 100_000, genesisTime: genesis.Unix()}
+
+	check := func(start string, end string, expectedStart int64, expectedEnd int64) {
+		startBlock, endBlock, err := ResolveBlockRange(context.Background(), headers, start, end)
+		if err != nil || startBlock != expectedStart || endBlock != expectedEnd {
+			t.Errorf("ResolveBlockRange(%s, %s) = %d, %d, %v - expected %d, %d", start, end, startBlock, endBlock, err, expectedStart, expectedEnd)
+		}
+	}
+
+	check("100", "200", 100, 200)
+	check("2021-08-02", "2021-08-03", 8640, 17279)    // end time is exclusive
+	check("2021-08-01T00:00:05", "1h", 1, 360)        // end relative to start time
+	check("100", "1d12h", 100, 100+12960-1)           // end relative to start block time
+	check("2021-08-10", "", 77760, 100_000)           // no end: latest block
+	check("2021-08-10", "2030-01-01", 77760, 100_000) // end after latest block
+
+	if _, _, err := ResolveBlockRange(context.Background(), headers, "200", "100"); err == nil {
+		t.Error("expected error for end before start")
+	}
+	if _, _, err := ResolveBlockRange(context.Background(), headers, "6h", ""); err == nil {
+		t.Error("expected error for positive start duration")
+	}
+}
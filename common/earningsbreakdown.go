@@ -0,0 +1,191 @@
+package common
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+// EarningsBreakdown splits the coinbase balance diff of a block by source (amounts in wei). BalanceDiff is the sum of
+// all incoming amounts, minus OutgoingTransfers and OutgoingGasFees, plus Unexplained.
+type EarningsBreakdown struct {
+	BalanceDiff *big.Int // coinbase balance after the block - before the block
+
+	BlockReward           *big.Int // static block reward
+	UncleInclusionRewards *big.Int // 1/32 of the block reward per included uncle
+	UncleMinerRewards     *big.Int // rewards of included uncles that were mined by the same coinbase
+	PriorityFees          *big.Int // tips of all tx (including tx of the coinbase itself)
+	DirectTransfers       *big.Int // value of successful tx to the coinbase
+	InternalTransfers     *big.Int // value sent to the coinbase by contract calls, minus value sent by the coinbase in calls
+	OutgoingTransfers     *big.Int // value of successful tx from the coinbase
+	OutgoingGasFees       *big.Int // gas paid by tx from the coinbase (the tip of it goes back to the coinbase)
+	Unexplained           *big.Int // rest of the balance diff, 0 without traces
+
+	// InternalTransfers is from call traces. Without traces, InternalTransfers is the rest of the balance diff.
+	InternalTransfersFromTraces bool
+
+	BurntBaseFee *big.Int // base fee of all tx in the block, earned by nobody
+}
+
+// TxEarnings are the earnings from the transactions of the block (tips and transfers to the coinbase)
+func (b *EarningsBreakdown) TxEarnings() *big.Int {
+	res := new(big.Int).Add(b.PriorityFees, b.DirectTransfers)
+	return res.Add(res, b.InternalTransfers)
+}
+
+// Total are all earnings of the block, including block and uncle rewards (but without outgoing transfers and gas fees)
+func (b *EarningsBreakdown) Total() *big.Int {
+	res := new(big.Int).Add(b.TxEarnings(), b.BlockReward)
+	res.Add(res, b.UncleInclusionRewards)
+	return res.Add(res, b.UncleMinerRewards)
+}
+
+// callFrame is a call of the geth callTracer
+type callFrame struct {
+	Type  string       `json:"type"`
+	From  string       `json:"from"`
+	To    string       `json:"to"`
+	Value string       `json:"value"`
+	Error string       `json:"error"`
+	Calls []*callFrame `json:"calls"`
+}
+
+// internalTransfers returns the value sent to the address minus the value sent by the address in the calls below the
+// frame. Reverted calls are skipped.
+func (f *callFrame) internalTransfers(address common.Address) *big.Int {
+	res := new(big.Int)
+	for _, call := range f.Calls {
+		if call.Error != "" {
+			continue
+		}
+
+		if call.Type == "CALL" || call.Type == "SELFDESTRUCT" {
+			value, _ := hexutil.DecodeBig(call.Value) // 0 if empty
+			if value != nil && common.HexToAddress(call.To) == address {
+				res.Add(res, value)
+			}
+			if value != nil && common.HexToAddress(call.From) == address {
+				res.Sub(res, value)
+			}
+		}
+		res.Add(res, call.internalTransfers(address))
+	}
+	return res
+}
+
+// traceInternalTransfers gets the internal transfers of the coinbase from call traces of all tx of the block
+func (es *EarningsService) traceInternalTransfers(ctx context.Context, block *types.Block, receipts map[common.Hash]*types.Receipt) (*big.Int, error) {
+	var traces []struct {
+		Result *callFrame `json:"result"`
+		Error  string     `json:"error"`
+	}
+	err := es.TraceClient.CallContext(ctx, &traces, "debug_traceBlockByHash", block.Hash(), map[string]string{"tracer": "callTracer"})
+	if err != nil {
+		return nil, err
+	}
+
+	res := new(big.Int)
+	for i, trace := range traces {
+		if trace.Result == nil || i >= len(block.Transactions()) || receipts[block.Transactions()[i].Hash()].Status != types.ReceiptStatusSuccessful {
+			continue // nothing was transferred
+		}
+		res.Add(res, trace.Result.internalTransfers(block.Coinbase()))
+	}
+	return res, nil
+}
+
+// GetBlockCoinbaseEarningsBreakdown splits the coinbase balance diff of the block by source. Missing receipts are
+// fetched. Internal transfers are from call traces with TraceClient, if the node supports debug_traceBlockByHash with
+// the callTracer, else they are the rest of the balance diff.
+func (es *EarningsService) GetBlockCoinbaseEarningsBreakdown(ctx context.Context, blockWithReceipts *blockswithtx.BlockWithTxReceipts) (*EarningsBreakdown, error) {
+	block := blockWithReceipts.Block
+	header := block.Header()
+	coinbase := block.Coinbase()
+
+	balanceAfterBlock, err := es.client.BalanceAt(ctx, coinbase, block.Number())
+	if err != nil {
+		return nil, err
+	}
+	balanceBeforeBlock, err := es.client.BalanceAt(ctx, coinbase, new(big.Int).Sub(block.Number(), common.Big1))
+	if err != nil {
+		return nil, err
+	}
+
+	b := &EarningsBreakdown{
+		BalanceDiff:       new(big.Int).Sub(balanceAfterBlock, balanceBeforeBlock),
+		BlockReward:       new(big.Int).Set(BlockReward(block.Number())),
+		UncleMinerRewards: new(big.Int),
+		PriorityFees:      new(big.Int),
+		DirectTransfers:   new(big.Int),
+		InternalTransfers: new(big.Int),
+		OutgoingTransfers: new(big.Int),
+		OutgoingGasFees:   new(big.Int),
+		Unexplained:       new(big.Int),
+		BurntBaseFee:      new(big.Int),
+	}
+
+	var uncleMinerRewards map[common.Address]*big.Int
+	b.UncleInclusionRewards, uncleMinerRewards = UncleRewards(header, block.Uncles())
+	if reward, found := uncleMinerRewards[coinbase]; found {
+		b.UncleMinerRewards.Set(reward)
+	}
+
+	receipts := make(map[common.Hash]*types.Receipt)
+	for _, tx := range block.Transactions() {
+		receipt := blockWithReceipts.TxReceipts[tx.Hash()]
+		if receipt == nil {
+			receipt, err = es.client.TransactionReceipt(ctx, tx.Hash())
+			if err != nil {
+				return nil, err
+			}
+		}
+		receipts[tx.Hash()] = receipt
+		gasUsed := new(big.Int).SetUint64(receipt.GasUsed)
+
+		b.PriorityFees.Add(b.PriorityFees, new(big.Int).Mul(tx.EffectiveGasTipValue(header.BaseFee), gasUsed))
+		if header.BaseFee != nil {
+			b.BurntBaseFee.Add(b.BurntBaseFee, new(big.Int).Mul(header.BaseFee, gasUsed))
+		}
+
+		from, fromErr := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+		if fromErr == nil && from == coinbase {
+			gasPrice := new(big.Int).Set(tx.GasPrice())
+			if header.BaseFee != nil {
+				gasPrice.Add(header.BaseFee, tx.EffectiveGasTipValue(header.BaseFee))
+			}
+			b.OutgoingGasFees.Add(b.OutgoingGasFees, new(big.Int).Mul(gasPrice, gasUsed))
+			if receipt.Status == types.ReceiptStatusSuccessful {
+				b.OutgoingTransfers.Add(b.OutgoingTransfers, tx.Value())
+			}
+		}
+
+		if tx.To() != nil && *tx.To() == coinbase && receipt.Status == types.ReceiptStatusSuccessful {
+			b.DirectTransfers.Add(b.DirectTransfers, tx.Value())
+		}
+	}
+
+	explained := new(big.Int).Add(b.BlockReward, b.UncleInclusionRewards)
+	explained.Add(explained, b.UncleMinerRewards)
+	explained.Add(explained, b.PriorityFees)
+	explained.Add(explained, b.DirectTransfers)
+	explained.Sub(explained, b.OutgoingTransfers)
+	explained.Sub(explained, b.OutgoingGasFees)
+	rest := new(big.Int).Sub(b.BalanceDiff, explained)
+
+	if es.TraceClient != nil {
+		internalTransfers, err := es.traceInternalTransfers(ctx, block, receipts)
+		if err == nil {
+			b.InternalTransfers = internalTransfers
+			b.InternalTransfersFromTraces = true
+			b.Unexplained.Sub(rest, internalTransfers)
+			return b, nil
+		}
+	}
+
+	b.InternalTransfers = rest
+	return b, nil
+}
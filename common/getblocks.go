@@ -0,0 +1,292 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+// BlockByNumberReader is implemented by ethclient.Client
+type BlockByNumberReader interface {
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+}
+
+type GetBlocksOptions struct {
+	Concurrency          int           // initial number of requests in flight
+	MaxConcurrency       int           // adapt concurrency between 1 and MaxConcurrency to latency and errors (fixed if <= Concurrency)
+	MaxRequestsPerSecond float64       // 0 = no limit
+	MaxRetries           int           // retries per block before it is reported as failed
+	RetryBackoff         time.Duration // wait before the first retry, doubled for each further retry
+	Ordered              bool          // deliver blocks in height order
+	StatsInterval        time.Duration // log throughput stats at this interval (0 = never)
+}
+
+func DefaultGetBlocksOptions() GetBlocksOptions {
+	return GetBlocksOptions{
+		Concurrency:    15,
+		MaxConcurrency: 50,
+		MaxRetries:     5,
+		RetryBackoff:   time.Second,
+		StatsInterval:  30 * time.Second,
+	}
+}
+
+// NewLimiter returns a limiter for the concurrency and rate options
+func (opts GetBlocksOptions) NewLimiter() *AdaptiveLimiter {
+	if opts.MaxConcurrency <= opts.Concurrency {
+		return NewAdaptiveLimiter(opts.Concurrency, opts.Concurrency, opts.Concurrency, opts.MaxRequestsPerSecond)
+	}
+	return NewAdaptiveLimiter(opts.Concurrency, 1, opts.MaxConcurrency, opts.MaxRequestsPerSecond)
+}
+
+// BlockError is a block that could not be downloaded
+type BlockError struct {
+	Height int64
+	Err    error
+}
+
+func (e BlockError) Error() string {
+	return fmt.Sprintf("block %d: %v", e.Height, e.Err)
+}
+
+func (e BlockError) Unwrap() error {
+	return e.Err
+}
+
+type GetBlocksResult struct {
+	NumBlocks int          // number of delivered blocks
+	Failed    []BlockError // blocks that could not be downloaded after all retries, by height
+}
+
+func (r GetBlocksResult) FailedHeights() []int64 {
+	heights := make([]int64, len(r.Failed))
+	for i, blockErr := range r.Failed {
+		heights[i] = blockErr.Height
+	}
+	return heights
+}
+
+// BlockWithTxReceiptsReader is implemented by BlockFetcher and blockstore.Reader
+type BlockWithTxReceiptsReader interface {
+	GetBlockWithTxReceipts(ctx context.Context, height int64) (*blockswithtx.BlockWithTxReceipts, error)
+}
+
+type getBlockResult struct {
+	height int64
+	block  interface{} // *types.Block or *blockswithtx.BlockWithTxReceipts
+	numTx  int
+	err    error
+}
+
+// GetBlocks downloads the blocks from startBlock to endBlock (inclusive) with concurrent requests (adapted to the node,
+// see AdaptiveLimiter), and sends each to blockChan. Failed downloads are retried with exponential backoff, blocks that still fail are returned in the result
+// (and not sent to blockChan). Returns the context error if cancelled before all blocks were downloaded.
+func GetBlocks(ctx context.Context, blockChan chan<- *types.Block, client BlockByNumberReader, startBlock int64, endBlock int64, opts GetBlocksOptions) (result GetBlocksResult, err error) {
+	fetch := func(ctx context.Context, height int64) (interface{}, int, error) {
+		block, err := client.BlockByNumber(ctx, big.NewInt(height))
+		if err != nil {
+			return nil, 0, err
+		}
+		return block, len(block.Transactions()), nil
+	}
+
+	deliver := func(ctx context.Context, block interface{}) bool {
+		select {
+		case blockChan <- block.(*types.Block):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	return getBlocks(ctx, blockRange(startBlock, endBlock), fetch, deliver, false, opts)
+}
+
+// GetBlocksWithTxReceipts is like GetBlocks, for the given heights and with the tx receipts of each block
+func GetBlocksWithTxReceipts(ctx context.Context, blockChan chan<- *blockswithtx.BlockWithTxReceipts, client BlockWithTxReceiptsReader, heights []int64, opts GetBlocksOptions) (result GetBlocksResult, err error) {
+	fetch := func(ctx context.Context, height int64) (interface{}, int, error) {
+		block, err := client.GetBlockWithTxReceipts(ctx, height)
+		if err != nil {
+			return nil, 0, err
+		}
+		return block, len(block.Block.Transactions()), nil
+	}
+
+	deliver := func(ctx context.Context, block interface{}) bool {
+		select {
+		case blockChan <- block.(*blockswithtx.BlockWithTxReceipts):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	// Latency per block and receipt (the receipt requests are batched, but take longer with more receipts)
+	return getBlocks(ctx, heights, fetch, deliver, true, opts)
+}
+
+func blockRange(startBlock int64, endBlock int64) []int64 {
+	heights := make([]int64, 0, endBlock-startBlock+1)
+	for height := startBlock; height <= endBlock; height++ {
+		heights = append(heights, height)
+	}
+	return heights
+}
+
+// getBlocks is the worker pool of GetBlocks and GetBlocksWithTxReceipts: fetch returns a block and its number of
+// transactions, deliver passes it on (and returns false if cancelled). With latencyPerTx, the limiter adapts to the
+// latency divided by the number of transactions.
+func getBlocks(ctx context.Context, heights []int64, fetch func(ctx context.Context, height int64) (interface{}, int, error), deliver func(ctx context.Context, block interface{}) bool, latencyPerTx bool, opts GetBlocksOptions) (result GetBlocksResult, err error) {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	numWorkers := opts.Concurrency
+	if opts.MaxConcurrency > numWorkers {
+		numWorkers = opts.MaxConcurrency
+	}
+	limiter := opts.NewLimiter()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stats := NewThroughputStats()
+	if opts.StatsInterval > 0 {
+		go stats.Report(ctx, opts.StatsInterval, limiter)
+	}
+
+	// Limits how far downloads may run ahead of delivery (in ordered mode, a slow block holds back all later ones)
+	slots := make(chan struct{}, numWorkers*10)
+
+	blockHeightChan := make(chan int64)
+	resultChan := make(chan getBlockResult)
+
+	// Push block heights to the workers
+	go func() {
+		defer close(blockHeightChan)
+		for _, height := range heights {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case blockHeightChan <- height:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Workers download blocks (with retries, as many in parallel as the limiter allows) and send the results to the collector
+	var workerWg sync.WaitGroup
+	for w := 1; w <= numWorkers; w++ {
+		workerWg.Add(1)
+		go func() {
+			defer workerWg.Done()
+			for height := range blockHeightChan {
+				res := getBlockWithRetries(ctx, fetch, limiter, height, latencyPerTx, opts)
+				select {
+				case resultChan <- res:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		workerWg.Wait()
+		close(resultChan)
+	}()
+
+	// Collect results and deliver blocks (buffered until all lower heights are done in ordered mode)
+	pending := make(map[int64]getBlockResult)
+	nextIndex := 0
+	deliverResult := func(res getBlockResult) bool {
+		<-slots
+		if res.err != nil {
+			if ctx.Err() == nil {
+				log.Println("Error getting block:", res.height, res.err)
+				result.Failed = append(result.Failed, BlockError{Height: res.height, Err: res.err})
+			}
+			return true
+		}
+
+		if !deliver(ctx, res.block) {
+			return false
+		}
+		result.NumBlocks += 1
+		stats.Add(1, res.numTx)
+		return true
+	}
+
+	for res := range resultChan {
+		if !opts.Ordered {
+			if !deliverResult(res) {
+				break
+			}
+			continue
+		}
+
+		pending[res.height] = res
+		for nextIndex < len(heights) {
+			next, found := pending[heights[nextIndex]]
+			if !found {
+				break
+			}
+			delete(pending, heights[nextIndex])
+			nextIndex += 1
+			if !deliverResult(next) {
+				break
+			}
+		}
+	}
+
+	sort.Slice(result.Failed, func(i, j int) bool { return result.Failed[i].Height < result.Failed[j].Height })
+	return result, ctx.Err()
+}
+
+func getBlockWithRetries(ctx context.Context, fetch func(ctx context.Context, height int64) (interface{}, int, error), limiter *AdaptiveLimiter, height int64, latencyPerTx bool, opts GetBlocksOptions) (res getBlockResult) {
+	res.height = height
+	res.err = opts.Retry(ctx, func() (err error) {
+		if err := limiter.Acquire(ctx); err != nil {
+			return err
+		}
+		timeStart := time.Now()
+		res.block, res.numTx, err = fetch(ctx, height)
+		latency := time.Since(timeStart)
+		if latencyPerTx {
+			latency /= time.Duration(1 + res.numTx)
+		}
+		limiter.Release(latency, err)
+		return err
+	})
+	return res
+}
+
+// Retry calls fn until it succeeds, up to MaxRetries more times, waiting RetryBackoff before the first retry (doubled
+// for each further retry). Returns the last error.
+func (opts GetBlocksOptions) Retry(ctx context.Context, fn func() error) error {
+	backoff := opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= opts.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,168 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+// testBlocks fails the first failures[height] requests of a block (-1 = always)
+type testBlocks struct {
+	lock     sync.Mutex
+	failures map[int64]int
+	delay    map[int64]time.Duration
+}
+
+func (c *testBlocks) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	height := number.Int64()
+
+	c.lock.Lock()
+	delay := c.delay[height]
+	failures := c.failures[height]
+	if failures > 0 {
+		c.failures[height] -= 1
+	}
+	c.lock.Unlock()
+
+	time.Sleep(delay)
+	if failures != 0 {
+		return nil, errors.New("node error")
+	}
+	return types.NewBlockWithHeader(&types.Header{Number: number}), nil
+}
+
+func collectBlocks(blockChan chan *types.Block) (heights *[]int64, done chan struct{}) {
+	heights = &[]int64{}
+	done = make(chan struct{})
+	go func() {
+		for block := range blockChan {
+			*heights = append(*heights, block.Number().Int64())
+		}
+		close(done)
+	}()
+	return heights, done
+}
+
+func TestGetBlocksRetries(t *testing.T) {
+	client := &testBlocks{failures: map[int64]int{3: 2, 5: -1}}
+	opts := GetBlocksOptions{Concurrency: 3, MaxRetries: 3, RetryBackoff: time.Millisecond}
+
+	blockChan := make(chan *types.Block)
+	heights, done := collectBlocks(blockChan)
+	result, err := GetBlocks(context.Background(), blockChan, client, 1, 10, opts)
+	close(blockChan)
+	<-done
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.NumBlocks != 9 || len(*heights) != 9 {
+		t.Errorf("expected 9 blocks, got %d (%d received)", result.NumBlocks, len(*heights))
+	}
+	if failed := result.FailedHeights(); len(failed) != 1 || failed[0] != 5 {
+		t.Errorf("expected block 5 to fail, got %v", failed)
+	}
+}
+
+func TestGetBlocksOrdered(t *testing.T) {
+	client := &testBlocks{
+		failures: map[int64]int{4: 1, 7: -1},
+		delay:    map[int64]time.Duration{2: 20 * time.Millisecond},
+	}
+	opts := GetBlocksOptions{Concurrency: 4, MaxRetries: 1, RetryBackoff: time.Millisecond, Ordered: true}
+
+	blockChan := make(chan *types.Block)
+	heights, done := collectBlocks(blockChan)
+	result, err := GetBlocks(context.Background(), blockChan, client, 1, 20, opts)
+	close(blockChan)
+	<-done
+
+	if err != nil || len(result.Failed) != 1 {
+		t.Fatal("unexpected result:", result, err)
+	}
+
+	expected := int64(1)
+	for _, height := range *heights {
+		if expected == 7 {
+			expected += 1
+		}
+		if height != expected {
+			t.Fatalf("blocks not in order: %v", *heights)
+		}
+		expected += 1
+	}
+}
+
+func (c *testBlocks) GetBlockWithTxReceipts(ctx context.Context, height int64) (*blockswithtx.BlockWithTxReceipts, error) {
+	block, err := c.BlockByNumber(ctx, big.NewInt(height))
+	if err != nil {
+		return nil, err
+	}
+	return &blockswithtx.BlockWithTxReceipts{Block: block}, nil
+}
+
+func TestGetBlocksWithTxReceipts(t *testing.T) {
+	client := &testBlocks{
+		failures: map[int64]int{12: -1},
+		delay:    map[int64]time.Duration{3: 20 * time.Millisecond},
+	}
+	opts := GetBlocksOptions{Concurrency: 3, MaxRetries: 1, RetryBackoff: time.Millisecond, Ordered: true}
+
+	blockChan := make(chan *blockswithtx.BlockWithTxReceipts)
+	heights := []int64{}
+	done := make(chan struct{})
+	go func() {
+		for block := range blockChan {
+			heights = append(heights, block.Block.Number().Int64())
+		}
+		close(done)
+	}()
+
+	result, err := GetBlocksWithTxReceipts(context.Background(), blockChan, client, []int64{3, 5, 12, 13, 20}, opts)
+	close(blockChan)
+	<-done
+
+	if err != nil || result.NumBlocks != 4 {
+		t.Fatal("unexpected result:", result, err)
+	}
+	if failed := result.FailedHeights(); len(failed) != 1 || failed[0] != 12 {
+		t.Errorf("expected block 12 to fail, got %v", failed)
+	}
+	if len(heights) != 4 || heights[0] != 3 || heights[1] != 5 || heights[2] != 13 || heights[3] != 20 {
+		t.Errorf("unexpected blocks: %v", heights)
+	}
+}
+
+func TestGetBlocksCancel(t *testing.T) {
+	client := &testBlocks{delay: map[int64]time.Duration{}}
+	for h := int64(1); h <= 1000; h++ {
+		client.delay[h] = time.Millisecond
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	blockChan := make(chan *types.Block)
+	received := 0
+	go func() {
+		for range blockChan {
+			received += 1
+			if received == 10 {
+				cancel()
+			}
+		}
+	}()
+
+	result, err := GetBlocks(ctx, blockChan, client, 1, 1000, GetBlocksOptions{Concurrency: 2})
+	close(blockChan)
+	if !errors.Is(err, context.Canceled) {
+		t.Error("expected context.Canceled, got", err)
+	}
+	if result.NumBlocks >= 1000 || len(result.Failed) != 0 {
+		t.Error("unexpected result after cancel:", result.NumBlocks, len(result.Failed))
+	}
+}
@@ -0,0 +1,174 @@
+package common
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Latency above latencyTolerance * baseline latency is treated as a sign of an overloaded node
+const latencyTolerance = 2.0
+
+// AdaptiveLimiter limits the number of requests in flight, and adapts the limit to the node: it grows by one per
+// round of successful requests, and shrinks when requests fail (by half) or latency rises well above the lowest
+// observed latency (by 10%, at most once per latency period). Optionally also limits the requests per second.
+type AdaptiveLimiter struct {
+	Min int
+	Max int
+
+	lock           sync.Mutex
+	limit          float64
+	inFlight       int
+	changed        chan struct{} // closed and replaced when a slot is released
+	latencyEwma    time.Duration
+	latencyMin     time.Duration
+	lastDecrease   time.Time
+	rateInterval   time.Duration // min time between requests (0 = no limit)
+	nextRequest    time.Time
+	numRequests    int64
+	numErrors      int64
+	sumLatencyNsec int64
+}
+
+// NewAdaptiveLimiter returns a limiter starting at initial concurrency, adapting between min and max. maxRps limits
+// the requests per second (0 = no limit). With min = max the concurrency is fixed.
+func NewAdaptiveLimiter(initial int, min int, max int, maxRps float64) *AdaptiveLimiter {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	if initial < min {
+		initial = min
+	} else if initial > max {
+		initial = max
+	}
+
+	l := &AdaptiveLimiter{
+		Min:     min,
+		Max:     max,
+		limit:   float64(initial),
+		changed: make(chan struct{}),
+	}
+	if maxRps > 0 {
+		l.rateInterval = time.Duration(float64(time.Second) / maxRps)
+	}
+	return l
+}
+
+// Acquire waits for a free slot (and for the rate limit), until the context is cancelled
+func (l *AdaptiveLimiter) Acquire(ctx context.Context) error {
+	for {
+		l.lock.Lock()
+		if l.inFlight < int(l.limit) {
+			l.inFlight += 1
+
+			// Reserve the next request time
+			wait := time.Duration(0)
+			if l.rateInterval > 0 {
+				now := time.Now()
+				if l.nextRequest.Before(now) {
+					l.nextRequest = now
+				}
+				wait = l.nextRequest.Sub(now)
+				l.nextRequest = l.nextRequest.Add(l.rateInterval)
+			}
+			l.lock.Unlock()
+
+			if wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					l.Release(0, nil)
+					return ctx.Err()
+				}
+			}
+			return nil
+		}
+		changed := l.changed
+		l.lock.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Release frees the slot, and adapts the limit to the latency and result of the request (a zero latency is ignored)
+func (l *AdaptiveLimiter) Release(latency time.Duration, err error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.inFlight -= 1
+	close(l.changed)
+	l.changed = make(chan struct{})
+
+	if latency == 0 && err == nil {
+		return
+	}
+
+	l.numRequests += 1
+	if err != nil {
+		l.numErrors += 1
+		l.setLimit(l.limit / 2)
+		l.lastDecrease = time.Now()
+		return
+	}
+
+	l.sumLatencyNsec += latency.Nanoseconds()
+	if l.latencyEwma == 0 {
+		l.latencyEwma = latency
+	} else {
+		l.latencyEwma = (l.latencyEwma*9 + latency) / 10
+	}
+	if l.latencyMin == 0 || l.latencyEwma < l.latencyMin {
+		l.latencyMin = l.latencyEwma
+	}
+
+	if float64(l.latencyEwma) > latencyTolerance*float64(l.latencyMin) {
+		if time.Since(l.lastDecrease) > l.latencyEwma {
+			l.setLimit(l.limit * 0.9)
+			l.lastDecrease = time.Now()
+		}
+		return
+	}
+
+	l.setLimit(l.limit + 1/l.limit)
+}
+
+func (l *AdaptiveLimiter) setLimit(limit float64) {
+	if limit < float64(l.Min) {
+		limit = float64(l.Min)
+	} else if limit > float64(l.Max) {
+		limit = float64(l.Max)
+	}
+	l.limit = limit
+}
+
+// Limit returns the current concurrency limit
+func (l *AdaptiveLimiter) Limit() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return int(l.limit)
+}
+
+type LimiterStats struct {
+	Limit       int
+	NumRequests int64
+	NumErrors   int64
+	AvgLatency  time.Duration
+}
+
+func (l *AdaptiveLimiter) Stats() LimiterStats {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	stats := LimiterStats{Limit: int(l.limit), NumRequests: l.numRequests, NumErrors: l.numErrors}
+	if numSuccessful := l.numRequests - l.numErrors; numSuccessful > 0 {
+		stats.AvgLatency = time.Duration(l.sumLatencyNsec / numSuccessful)
+	}
+	return stats
+}
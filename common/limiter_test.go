@@ -0,0 +1,79 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	l := NewAdaptiveLimiter(4, 1, 10, 0)
+	ctx := context.Background()
+
+	// Successful requests with stable latency increase the limit
+	for i := 0; i < 100; i++ {
+		l.Acquire(ctx)
+		l.Release(10*time.Millisecond, nil)
+	}
+	if l.Limit() != 10 {
+		t.Error("limit should grow to max, is", l.Limit())
+	}
+
+	// Errors halve the limit
+	l.Acquire(ctx)
+	l.Release(0, errors.New("node error"))
+	if l.Limit() != 5 {
+		t.Error("limit should be halved, is", l.Limit())
+	}
+
+	// Rising latency decreases the limit
+	for i := 0; i < 50; i++ {
+		l.Acquire(ctx)
+		l.lastDecrease = time.Time{}
+		l.Release(100*time.Millisecond, nil)
+	}
+	if l.Limit() >= 5 {
+		t.Error("limit should decrease with rising latency, is", l.Limit())
+	}
+
+	stats := l.Stats()
+	if stats.NumRequests != 151 || stats.NumErrors != 1 {
+		t.Error("unexpected stats:", stats)
+	}
+}
+
+func TestAdaptiveLimiterBlocks(t *testing.T) {
+	l := NewAdaptiveLimiter(2, 2, 2, 0)
+	l.Acquire(context.Background())
+	l.Acquire(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Acquire(ctx); err == nil {
+		t.Fatal("third acquire should block until the context is done")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		l.Release(time.Millisecond, nil)
+	}()
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if l.Limit() != 2 {
+		t.Error("fixed limit should not change, is", l.Limit())
+	}
+}
+
+func TestAdaptiveLimiterRate(t *testing.T) {
+	l := NewAdaptiveLimiter(10, 10, 10, 100) // 10ms between requests
+	timeStart := time.Now()
+	for i := 0; i < 6; i++ {
+		l.Acquire(context.Background())
+		l.Release(0, nil)
+	}
+	if elapsed := time.Since(timeStart); elapsed < 50*time.Millisecond {
+		t.Error("rate limit not applied, 6 requests took", elapsed)
+	}
+}
@@ -2,32 +2,92 @@ package common
 
 import (
 	"context"
+	"errors"
 	"math/big"
+	"os"
+	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	lru "github.com/hashicorp/golang-lru"
 )
 
+// DefaultEarningsCacheSize is the number of blocks of which the EarningsService caches the earnings by default
+const DefaultEarningsCacheSize = 10_000
+
+// EarningsService gets the coinbase earnings of blocks from balance diffs. The earnings are cached by block hash
+// (LRU, safe for concurrent use), and optionally persisted to a file.
 type EarningsService struct {
-	client                   *ethclient.Client
-	MinerEarningsByBlockHash map[common.Hash]*big.Int
+	client *ethclient.Client
+	cache  *lru.Cache // block hash -> *big.Int
+
+	TraceClient *rpc.Client // optional, for internal transfers in GetBlockCoinbaseEarningsBreakdown
+
+	cacheFile     string
+	cacheFileLock sync.Mutex
 }
 
 func NewEarningsService(client *ethclient.Client) *EarningsService {
-	return &EarningsService{
-		client:                   client,
-		MinerEarningsByBlockHash: make(map[common.Hash]*big.Int),
+	es, _ := NewEarningsServiceWithCache(client, DefaultEarningsCacheSize, "") // no error without a cache file
+	return es
+}
+
+// NewEarningsServiceWithCache returns a service that caches the earnings of up to cacheSize blocks. With a cacheFile,
+// earlier saved earnings are loaded from it, and SaveCache writes the cache to it.
+func NewEarningsServiceWithCache(client *ethclient.Client, cacheSize int, cacheFile string) (*EarningsService, error) {
+	cache, err := lru.New(cacheSize)
+	if err != nil {
+		return nil, err
 	}
+
+	es := &EarningsService{client: client, cache: cache, cacheFile: cacheFile}
+	if cacheFile == "" {
+		return es, nil
+	}
+
+	earnings := make(map[common.Hash]*big.Int)
+	err = ReadJsonFile(cacheFile, &earnings)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for hash, blockEarnings := range earnings {
+		cache.Add(hash, blockEarnings)
+	}
+	return es, nil
 }
 
-func (es *EarningsService) GetBlockCoinbaseEarningsWithoutCache(block *types.Block) (*big.Int, error) {
-	balanceAfterBlock, err := es.client.BalanceAt(context.Background(), block.Coinbase(), block.Number())
+// SaveCache writes the cached earnings to a temporary file and renames it to the cache file, so that a crash never
+// leaves a half-written cache file behind (no-op without a cache file)
+func (es *EarningsService) SaveCache() error {
+	if es.cacheFile == "" {
+		return nil
+	}
+
+	earnings := make(map[common.Hash]*big.Int)
+	for _, key := range es.cache.Keys() {
+		if blockEarnings, found := es.cache.Peek(key); found {
+			earnings[key.(common.Hash)] = blockEarnings.(*big.Int)
+		}
+	}
+
+	es.cacheFileLock.Lock()
+	defer es.cacheFileLock.Unlock()
+	return WriteJsonFile(es.cacheFile, earnings)
+}
+
+// GetBlockCoinbaseEarningsWithoutCache returns the coinbase balance diff, without the value of tx to and from the
+// coinbase. See GetBlockCoinbaseEarningsBreakdown for the earnings by source.
+func (es *EarningsService) GetBlockCoinbaseEarningsWithoutCache(ctx context.Context, block *types.Block) (*big.Int, error) {
+	balanceAfterBlock, err := es.client.BalanceAt(ctx, block.Coinbase(), block.Number())
 	if err != nil {
 		return nil, err
 	}
 
-	balanceBeforeBlock, err := es.client.BalanceAt(context.Background(), block.Coinbase(), new(big.Int).Sub(block.Number(), common.Big1))
+	balanceBeforeBlock, err := es.client.BalanceAt(ctx, block.Coinbase(), new(big.Int).Sub(block.Number(), common.Big1))
 	if err != nil {
 		return nil, err
 	}
@@ -53,19 +113,47 @@ func (es *EarningsService) GetBlockCoinbaseEarningsWithoutCache(block *types.Blo
 	return earnings, nil
 }
 
-func (es *EarningsService) GetBlockCoinbaseEarnings(block *types.Block) (*big.Int, error) {
-	var err error
-
-	earnings, found := es.MinerEarningsByBlockHash[block.Hash()]
-	if found {
-		return earnings, nil
+func (es *EarningsService) GetBlockCoinbaseEarnings(ctx context.Context, block *types.Block) (*big.Int, error) {
+	if earnings, found := es.cache.Get(block.Hash()); found {
+		return new(big.Int).Set(earnings.(*big.Int)), nil // copy, callers may modify it
 	}
 
-	earnings, err = es.GetBlockCoinbaseEarningsWithoutCache(block)
+	earnings, err := es.GetBlockCoinbaseEarningsWithoutCache(ctx, block)
 	if err != nil {
 		return nil, err
 	}
 
-	es.MinerEarningsByBlockHash[block.Hash()] = earnings
+	es.cache.Add(block.Hash(), new(big.Int).Set(earnings))
 	return earnings, nil
 }
+
+// BlockReward returns the static mainnet block reward (without uncle inclusion rewards) at this height
+func BlockReward(number *big.Int) *big.Int {
+	if params.MainnetChainConfig.IsConstantinople(number) {
+		return ethash.ConstantinopleBlockReward
+	} else if params.MainnetChainConfig.IsByzantium(number) {
+		return ethash.ByzantiumBlockReward
+	}
+	return ethash.FrontierBlockReward
+}
+
+// UncleRewards returns the reward for the miner of the block for including the uncles, and the rewards of the uncle
+// miners by address (see ethash.accumulateRewards)
+func UncleRewards(header *types.Header, uncles []*types.Header) (inclusionReward *big.Int, uncleMinerRewards map[common.Address]*big.Int) {
+	blockReward := BlockReward(header.Number)
+	inclusionReward = new(big.Int)
+	uncleMinerRewards = make(map[common.Address]*big.Int)
+	for _, uncle := range uncles {
+		inclusionReward.Add(inclusionReward, new(big.Int).Div(blockReward, big.NewInt(32)))
+
+		r := new(big.Int).Add(uncle.Number, big.NewInt(8))
+		r.Sub(r, header.Number)
+		r.Mul(r, blockReward)
+		r.Div(r, big.NewInt(8))
+		if uncleMinerRewards[uncle.Coinbase] == nil {
+			uncleMinerRewards[uncle.Coinbase] = new(big.Int)
+		}
+		uncleMinerRewards[uncle.Coinbase].Add(uncleMinerRewards[uncle.Coinbase], r)
+	}
+	return inclusionReward, uncleMinerRewards
+}
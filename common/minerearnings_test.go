@@ -0,0 +1,234 @@
+package common
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+func TestUncleRewards(t *testing.T) {
+	header := &types.Header{Number: big.NewInt(13000000)}
+	uncles := []*types.Header{
+		{Number: big.NewInt(12999999), Coinbase: ethcommon.HexToAddress("0x01")},
+		{Number: big.NewInt(12999998), Coinbase: ethcommon.HexToAddress("0x01")},
+	}
+
+	inclusionReward, uncleMinerRewards := UncleRewards(header, uncles)
+	if inclusionReward.String() != "125000000000000000" { // 2 * 2 ETH / 32
+		t.Errorf("wrong inclusion reward: %s", inclusionReward)
+	}
+	if r := uncleMinerRewards[ethcommon.HexToAddress("0x01")]; r == nil || r.String() != "3250000000000000000" { // 7/8 + 6/8 of 2 ETH
+		t.Errorf("wrong uncle miner reward: %s", r)
+	}
+
+	if BlockReward(big.NewInt(5000000)).String() != "3000000000000000000" {
+		t.Errorf("wrong Byzantium block reward")
+	}
+}
+
+// testBalanceService returns the balances by block number (hex), or 1 ETH * block number
+type testBalanceService struct {
+	numRequests int32
+	balances    map[string]*big.Int
+}
+
+func (s *testBalanceService) GetBalance(address ethcommon.Address, number string) (*hexutil.Big, error) {
+	atomic.AddInt32(&s.numRequests, 1)
+	if balance, found := s.balances[number]; found {
+		return (*hexutil.Big)(balance), nil
+	}
+	n, err := hexutil.DecodeBig(number)
+	if err != nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(new(big.Int).Mul(n, big.NewInt(1e18))), nil
+}
+
+// testTraceService returns the call traces of debug_traceBlockByHash
+type testTraceService struct {
+	traces json.RawMessage
+}
+
+func (s *testTraceService) TraceBlockByHash(hash ethcommon.Hash, config map[string]string) (json.RawMessage, error) {
+	if config["tracer"] != "callTracer" {
+		return nil, fmt.Errorf("unexpected tracer %s", config["tracer"])
+	}
+	return s.traces, nil
+}
+
+func TestEarningsServiceCache(t *testing.T) {
+	service := &testBalanceService{}
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", service); err != nil {
+		t.Fatal(err)
+	}
+	client := ethclient.NewClient(rpc.DialInProc(server))
+
+	cacheFile := filepath.Join(t.TempDir(), "earnings.json")
+	es, err := NewEarningsServiceWithCache(client, 2, cacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := make([]*types.Block, 3)
+	for i := range blocks {
+		blocks[i] = types.NewBlockWithHeader(&types.Header{Number: big.NewInt(int64(100 + i))})
+	}
+
+	// Concurrent requests for the same block
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			earnings, err := es.GetBlockCoinbaseEarnings(context.Background(), blocks[0])
+			if err != nil || earnings.String() != "1000000000000000000" {
+				t.Errorf("wrong earnings: %s (%v)", earnings, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	numRequests := atomic.LoadInt32(&service.numRequests)
+	if _, err := es.GetBlockCoinbaseEarnings(context.Background(), blocks[0]); err != nil || atomic.LoadInt32(&service.numRequests) != numRequests {
+		t.Errorf("earnings should be cached (%v)", err)
+	}
+
+	// With a cache size of 2, the first block is evicted
+	for _, block := range blocks[1:] {
+		if _, err := es.GetBlockCoinbaseEarnings(context.Background(), block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if es.cache.Contains(blocks[0].Hash()) || es.cache.Len() != 2 {
+		t.Errorf("expected the first block to be evicted, cache has %d blocks", es.cache.Len())
+	}
+
+	// Persisted cache
+	if err := es.SaveCache(); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(cacheFile + "*"); len(files) != 1 {
+		t.Errorf("expected only the cache file, got %v", files)
+	}
+	es2, err := NewEarningsServiceWithCache(client, 2, cacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numRequests = atomic.LoadInt32(&service.numRequests)
+	for _, block := range blocks[1:] {
+		earnings, err := es2.GetBlockCoinbaseEarnings(context.Background(), block)
+		if err != nil || earnings.String() != fmt.Sprint(int64(1e18)) {
+			t.Errorf("wrong earnings from the cache file: %s (%v)", earnings, err)
+		}
+	}
+	if atomic.LoadInt32(&service.numRequests) != numRequests {
+		t.Errorf("earnings should be loaded from the cache file")
+	}
+}
+
+func TestEarningsBreakdown(t *testing.T) {
+	minerKey, _ := crypto.GenerateKey()
+	coinbase := crypto.PubkeyToAddress(minerKey.PublicKey)
+	userKey, _ := crypto.GenerateKey()
+	other := ethcommon.HexToAddress("0x01")
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+
+	// Base fee 10 wei: a tx with a tip, a transfer to the coinbase, and a transfer from the coinbase (no tip)
+	var txs []*types.Transaction
+	var receipts []*types.Receipt
+	for i, tx := range []struct {
+		key      *ecdsa.PrivateKey
+		to       ethcommon.Address
+		value    int64
+		gasPrice int64
+	}{
+		{userKey, other, 0, 15},
+		{userKey, coinbase, 1000, 12},
+		{minerKey, other, 500, 10},
+	} {
+		to := tx.to // the tx keeps the pointer
+		signed, err := types.SignNewTx(tx.key, signer, &types.LegacyTx{Nonce: uint64(i), To: &to, Value: big.NewInt(tx.value), Gas: 21000, GasPrice: big.NewInt(tx.gasPrice)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, signed)
+		receipts = append(receipts, &types.Receipt{Status: types.ReceiptStatusSuccessful, GasUsed: 21000, TxHash: signed.Hash(), Logs: []*types.Log{}})
+	}
+
+	header := &types.Header{Number: big.NewInt(13000000), Coinbase: coinbase, BaseFee: big.NewInt(10), GasUsed: 3 * 21000, Difficulty: big.NewInt(1)}
+	uncles := []*types.Header{{Number: big.NewInt(12999999), Coinbase: coinbase, Difficulty: big.NewInt(1)}}
+	block := types.NewBlock(header, txs, uncles, receipts, trie.NewStackTrie(nil))
+	blockWithReceipts := &blockswithtx.BlockWithTxReceipts{Block: block, TxReceipts: make(map[ethcommon.Hash]*types.Receipt)}
+	for _, receipt := range receipts[:2] { // the last one is fetched
+		blockWithReceipts.TxReceipts[receipt.TxHash] = receipt
+	}
+
+	// Block reward 2 ETH, uncle inclusion 1/32, uncle miner 7/8, tips 5*21000 + 2*21000, transfers in 1000 and 100
+	// (internal), out 500 and 10*21000 gas
+	expected := new(big.Int).Add(big.NewInt(2e18), big.NewInt(2e18/32))
+	expected.Add(expected, big.NewInt(2e18*7/8))
+	expected.Add(expected, big.NewInt(7*21000+1000+100-500-10*21000))
+
+	balances := &testBalanceService{balances: map[string]*big.Int{
+		hexutil.EncodeBig(big.NewInt(12999999)): big.NewInt(1e18),
+		hexutil.EncodeBig(big.NewInt(13000000)): new(big.Int).Add(big.NewInt(1e18), expected),
+	}}
+	traces := &testTraceService{traces: json.RawMessage(fmt.Sprintf(`[
+		{"result": {"type": "CALL", "from": "0x02", "to": "0x01", "calls": [
+			{"type": "CALL", "from": "0x01", "to": "%s", "value": "0x64"},
+			{"type": "CALL", "from": "0x01", "to": "%s", "value": "0x1000", "error": "execution reverted"}
+		]}},
+		{"result": {"type": "CALL", "from": "0x02", "to": "%s", "value": "0x3e8"}},
+		{"result": {"type": "CALL", "from": "%s", "to": "0x01", "value": "0x1f4"}}
+	]`, coinbase.Hex(), coinbase.Hex(), coinbase.Hex(), coinbase.Hex()))}
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", balances); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterName("eth", &testReceiptsService{receipts: map[ethcommon.Hash]*types.Receipt{receipts[2].TxHash: receipts[2]}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterName("debug", traces); err != nil {
+		t.Fatal(err)
+	}
+	rpcClient := rpc.DialInProc(server)
+
+	for _, withTraces := range []bool{true, false} {
+		es := NewEarningsService(ethclient.NewClient(rpcClient))
+		if withTraces {
+			es.TraceClient = rpcClient
+		}
+
+		b, err := es.GetBlockCoinbaseEarningsBreakdown(context.Background(), blockWithReceipts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.BalanceDiff.Cmp(expected) != 0 || b.PriorityFees.Int64() != 7*21000 || b.DirectTransfers.Int64() != 1000 || b.InternalTransfers.Int64() != 100 ||
+			b.OutgoingTransfers.Int64() != 500 || b.OutgoingGasFees.Int64() != 10*21000 || b.BurntBaseFee.Int64() != 3*10*21000 || b.Unexplained.Sign() != 0 {
+			t.Errorf("wrong breakdown (traces: %t): %+v", withTraces, b)
+		}
+		if b.InternalTransfersFromTraces != withTraces || b.UncleInclusionRewards.Int64() != 2e18/32 || b.UncleMinerRewards.Int64() != 2e18*7/8 {
+			t.Errorf("wrong breakdown (traces: %t): %+v", withTraces, b)
+		}
+		if b.TxEarnings().Int64() != 7*21000+1000+100 {
+			t.Errorf("wrong tx earnings: %s", b.TxEarnings())
+		}
+	}
+}
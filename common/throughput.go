@@ -0,0 +1,58 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// ThroughputStats counts processed blocks and transactions, for rate reports during long runs
+type ThroughputStats struct {
+	start     time.Time
+	numBlocks int64
+	numTx     int64
+}
+
+func NewThroughputStats() *ThroughputStats {
+	return &ThroughputStats{start: time.Now()}
+}
+
+func (s *ThroughputStats) Add(numBlocks int, numTx int) {
+	atomic.AddInt64(&s.numBlocks, int64(numBlocks))
+	atomic.AddInt64(&s.numTx, int64(numTx))
+}
+
+func (s *ThroughputStats) String() string {
+	numBlocks := atomic.LoadInt64(&s.numBlocks)
+	numTx := atomic.LoadInt64(&s.numTx)
+	sec := time.Since(s.start).Seconds()
+	return fmt.Sprintf("%d blocks (%.1f blocks/s), %d tx (%.1f tx/s) in %.0fs", numBlocks, float64(numBlocks)/sec, numTx, float64(numTx)/sec, sec)
+}
+
+// Report logs the throughput of the last interval and the overall throughput periodically, until the context is
+// cancelled. The optional limiter's concurrency and latency are included.
+func (s *ThroughputStats) Report(ctx context.Context, interval time.Duration, limiter *AdaptiveLimiter) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastBlocks, lastTx := int64(0), int64(0)
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		numBlocks := atomic.LoadInt64(&s.numBlocks)
+		numTx := atomic.LoadInt64(&s.numTx)
+		msg := fmt.Sprintf("throughput: %.1f blocks/s, %.1f tx/s - total %s", float64(numBlocks-lastBlocks)/interval.Seconds(), float64(numTx-lastTx)/interval.Seconds(), s.String())
+		if limiter != nil {
+			stats := limiter.Stats()
+			msg += fmt.Sprintf(" - concurrency %d, avg latency %s, %d/%d requests failed", stats.Limit, stats.AvgLatency.Round(time.Millisecond), stats.NumErrors, stats.NumRequests)
+		}
+		log.Println(msg)
+		lastBlocks, lastTx = numBlocks, numTx
+	}
+}
@@ -2,19 +2,12 @@ package common
 
 import (
 	"bytes"
-	"context"
-	"errors"
 	"fmt"
-	"log"
 	"math/big"
 	"os"
-	"strconv"
-	"strings"
-	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
 )
 
 func StrToBigInt(s string) *big.Int {
@@ -47,33 +40,10 @@ func BigIntToEString(i *big.Int, prec int) string {
 	return BigFloatToEString(f, prec)
 }
 
+// TimeStringToSec returns the number of seconds of a duration like 6h, -2d or 1d12h (see ParseDuration)
 func TimeStringToSec(s string) (timespanSec int, err error) {
-	isNegativeNumber := strings.HasPrefix(s, "-")
-	if isNegativeNumber {
-		s = s[1:]
-	}
-
-	var sec int = 0
-	switch {
-	case strings.HasSuffix(s, "s"):
-		sec, err = strconv.Atoi(strings.TrimSuffix(s, "s"))
-	case strings.HasSuffix(s, "m"):
-		sec, err = strconv.Atoi(strings.TrimSuffix(s, "m"))
-		sec *= 60
-	case strings.HasSuffix(s, "h"):
-		sec, err = strconv.Atoi(strings.TrimSuffix(s, "h"))
-		sec *= 60 * 60
-	case strings.HasSuffix(s, "d"):
-		sec, err = strconv.Atoi(strings.TrimSuffix(s, "d"))
-		sec *= 60 * 60 * 24
-	default:
-		err = errors.New("couldn't detect time format")
-	}
-	if isNegativeNumber {
-		sec *= -1 // make negative
-	}
-
-	return sec, err
+	duration, err := ParseDuration(s)
+	return int(duration.Seconds()), err
 }
 
 func TxToRlp(tx *types.Transaction) string {
@@ -96,39 +66,6 @@ func EnvStr(key string, defaultvalue string) string {
 	return defaultvalue
 }
 
-func GetBlocks(blockChan chan<- *types.Block, client *ethclient.Client, startBlock int64, endBlock int64, concurrency int) {
-	var blockWorkerWg sync.WaitGroup
-	blockHeightChan := make(chan int64, 100) // blockHeight to fetch with receipts
-
-	// Start eth client thread pool
-	for w := 1; w <= concurrency; w++ {
-		blockWorkerWg.Add(1)
-
-		// Worker gets a block height from blockHeightChan, downloads it, and puts it in the blockChan
-		go func() {
-			defer blockWorkerWg.Done()
-			for blockHeight := range blockHeightChan {
-				// fmt.Println(blockHeight)
-				block, err := client.BlockByNumber(context.Background(), big.NewInt(blockHeight))
-				if err != nil {
-					log.Println("Error getting block:", blockHeight, err)
-					continue
-				}
-				blockChan <- block
-			}
-		}()
-	}
-
-	// Push blocks into channel, for workers to pick up
-	for currentBlockNumber := startBlock; currentBlockNumber <= endBlock; currentBlockNumber++ {
-		blockHeightChan <- currentBlockNumber
-	}
-
-	// Close worker channel and wait for workers to finish
-	close(blockHeightChan)
-	blockWorkerWg.Wait()
-}
-
 func PrintBlock(block *types.Block) {
 	t := time.Unix(int64(block.Header().Time), 0).UTC()
 	unclesStr := ""
@@ -0,0 +1,176 @@
+// Package mevgethtest provides a fake mev-geth JSON-RPC server for tests, which answers eth_callBundle with
+// deterministic results (from fixtures, a scripted response function, or derived from the transactions), and
+// eth_getTransactionReceipt with preset receipts.
+package mevgethtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	flashbotsrpc "github.com/metachris/flashbots-rpc"
+	fbcommon "github.com/metachris/flashbots/common"
+)
+
+// CallBundleFunc returns the response to an eth_callBundle request
+type CallBundleFunc func(param flashbotsrpc.FlashbotsCallBundleParam) (flashbotsrpc.FlashbotsCallBundleResponse, error)
+
+// Server is a fake mev-geth node. Set CallBundle and Receipts before sending requests.
+type Server struct {
+	URL string
+
+	CallBundle CallBundleFunc                 // default: SimulateTxs
+	Receipts   map[common.Hash]*types.Receipt // for eth_getTransactionReceipt, tx without receipt are not found
+
+	httpServer *httptest.Server
+	callsLock  sync.Mutex
+	calls      []flashbotsrpc.FlashbotsCallBundleParam
+}
+
+// NewServer starts a fake mev-geth server, close it with Close
+func NewServer() *Server {
+	s := &Server{Receipts: make(map[common.Hash]*types.Receipt)}
+
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.RegisterName("eth", &ethService{s}); err != nil {
+		panic(err)
+	}
+	s.httpServer = httptest.NewServer(rpcServer)
+	s.URL = s.httpServer.URL
+	return s
+}
+
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+// Calls returns the parameters of all eth_callBundle requests so far
+func (s *Server) Calls() []flashbotsrpc.FlashbotsCallBundleParam {
+	s.callsLock.Lock()
+	defer s.callsLock.Unlock()
+	return append([]flashbotsrpc.FlashbotsCallBundleParam{}, s.calls...)
+}
+
+// AddReceipt makes eth_getTransactionReceipt return the tx as included in the block
+func (s *Server) AddReceipt(txHash common.Hash, blockNumber int64) {
+	s.Receipts[txHash] = &types.Receipt{
+		Status:      types.ReceiptStatusSuccessful,
+		TxHash:      txHash,
+		BlockNumber: big.NewInt(blockNumber),
+		Logs:        []*types.Log{},
+	}
+}
+
+type ethService struct {
+	s *Server
+}
+
+func (e *ethService) CallBundle(param flashbotsrpc.FlashbotsCallBundleParam) (*flashbotsrpc.FlashbotsCallBundleResponse, error) {
+	e.s.callsLock.Lock()
+	e.s.calls = append(e.s.calls, param)
+	e.s.callsLock.Unlock()
+
+	callBundle := e.s.CallBundle
+	if callBundle == nil {
+		callBundle = SimulateTxs
+	}
+	res, err := callBundle(param)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (e *ethService) GetTransactionReceipt(hash common.Hash) (*types.Receipt, error) {
+	return e.s.Receipts[hash], nil
+}
+
+// FixtureResponses answers by block number (hex, as in the request), with an error for unknown blocks
+func FixtureResponses(responses map[string]flashbotsrpc.FlashbotsCallBundleResponse) CallBundleFunc {
+	return func(param flashbotsrpc.FlashbotsCallBundleParam) (flashbotsrpc.FlashbotsCallBundleResponse, error) {
+		res, found := responses[param.BlockNumber]
+		if !found {
+			return res, fmt.Errorf("no fixture for block %s", param.BlockNumber)
+		}
+		return res, nil
+	}
+}
+
+// LoadFixtures reads eth_callBundle responses by block number (hex) from a JSON file, for FixtureResponses
+func LoadFixtures(path string) (map[string]flashbotsrpc.FlashbotsCallBundleResponse, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	responses := make(map[string]flashbotsrpc.FlashbotsCallBundleResponse)
+	err = json.NewDecoder(f).Decode(&responses)
+	return responses, err
+}
+
+// SimulateTxs answers with a result per tx: 21000 gas at the tx gas tip (the whole tip goes to the coinbase), and
+// the tx value as ETH sent to the coinbase.
+func SimulateTxs(param flashbotsrpc.FlashbotsCallBundleParam) (res flashbotsrpc.FlashbotsCallBundleResponse, err error) {
+	results := make([]flashbotsrpc.FlashbotsCallBundleResult, 0, len(param.Txs))
+	for _, rawTx := range param.Txs {
+		b, err := hexutil.Decode(rawTx)
+		if err != nil {
+			return res, err
+		}
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(b); err != nil {
+			return res, err
+		}
+
+		to := ""
+		if tx.To() != nil {
+			to = tx.To().Hex()
+		}
+		gasFees := new(big.Int).Mul(tx.GasTipCap(), big.NewInt(21000))
+		results = append(results, flashbotsrpc.FlashbotsCallBundleResult{
+			CoinbaseDiff:      new(big.Int).Add(gasFees, tx.Value()).String(),
+			EthSentToCoinbase: tx.Value().String(),
+			GasFees:           gasFees.String(),
+			GasPrice:          tx.GasTipCap().String(),
+			GasUsed:           21000,
+			ToAddress:         to,
+			TxHash:            tx.Hash().Hex(),
+			Value:             "0x",
+		})
+	}
+	return NewResponse(results), nil
+}
+
+// NewResponse returns a response with the totals of the results
+func NewResponse(results []flashbotsrpc.FlashbotsCallBundleResult) flashbotsrpc.FlashbotsCallBundleResponse {
+	coinbaseDiff, gasFees, ethSentToCoinbase := new(big.Int), new(big.Int), new(big.Int)
+	var gasUsed int64
+	for _, r := range results {
+		coinbaseDiff.Add(coinbaseDiff, fbcommon.StrToBigInt(r.CoinbaseDiff))
+		gasFees.Add(gasFees, fbcommon.StrToBigInt(r.GasFees))
+		ethSentToCoinbase.Add(ethSentToCoinbase, fbcommon.StrToBigInt(r.EthSentToCoinbase))
+		gasUsed += r.GasUsed
+	}
+
+	bundleGasPrice := new(big.Int)
+	if gasUsed > 0 {
+		bundleGasPrice.Div(coinbaseDiff, big.NewInt(gasUsed))
+	}
+
+	return flashbotsrpc.FlashbotsCallBundleResponse{
+		BundleGasPrice:    bundleGasPrice.String(),
+		CoinbaseDiff:      coinbaseDiff.String(),
+		EthSentToCoinbase: ethSentToCoinbase.String(),
+		GasFees:           gasFees.String(),
+		Results:           results,
+		TotalGasUsed:      gasUsed,
+	}
+}
@@ -0,0 +1,368 @@
+// Package nodepool manages connections to several Ethereum nodes: it health-checks them (head height, latency),
+// routes requests to the best healthy node and fails over to the next one on errors.
+package nodepool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	fbcommon "github.com/metachris/flashbots/common"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+const healthCheckTimeout = 5 * time.Second
+
+type Node struct {
+	Name    string // URI without path and credentials, for logging
+	Uri     string
+	Client  *ethclient.Client
+	Fetcher *fbcommon.BlockFetcher // batched block and receipt requests, over the same connection as Client
+
+	// Updated by health checks and failed requests
+	Healthy bool
+	Forked  bool // disagreed with the other nodes on a block hash in the last cross-check
+	Head    int64
+	Latency time.Duration
+	LastErr error
+}
+
+// Pool routes requests to the best node: healthy nodes whose head is at most MaxHeadLag behind the highest head,
+// by latency. Lagging and unhealthy nodes are only used when all better nodes fail.
+type Pool struct {
+	MaxHeadLag int64
+
+	// Number of confirmations for cross-checking block hashes between nodes in health checks (0 = disabled)
+	CrossCheckConfirmations int64
+
+	lock  sync.RWMutex
+	nodes []*Node
+}
+
+// ParseUris splits a comma separated list of node URIs
+func ParseUris(s string) []string {
+	uris := make([]string, 0)
+	for _, uri := range strings.Split(s, ",") {
+		if uri = strings.TrimSpace(uri); uri != "" {
+			uris = append(uris, uri)
+		}
+	}
+	return uris
+}
+
+func nodeName(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" {
+		return uri // ipc path
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// Dial connects to all nodes and runs a first health check. Nodes that cannot be connected to are skipped, an error
+// is only returned if no node can be connected to.
+func Dial(uris []string) (*Pool, error) {
+	pool := &Pool{MaxHeadLag: 3}
+	for _, uri := range uris {
+		fetcher, err := fbcommon.DialBlockFetcher(uri)
+		if err != nil {
+			log.Printf("Error connecting to %s: %v\n", nodeName(uri), err)
+			continue
+		}
+		pool.nodes = append(pool.nodes, &Node{Name: nodeName(uri), Uri: uri, Client: fetcher.Client, Fetcher: fetcher, Healthy: true})
+	}
+
+	if len(pool.nodes) == 0 {
+		return nil, fmt.Errorf("could not connect to any of %d eth nodes", len(uris))
+	}
+
+	pool.CheckHealth(context.Background())
+	return pool, nil
+}
+
+// Nodes returns a copy of the current node states
+func (p *Pool) Nodes() []Node {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	nodes := make([]Node, len(p.nodes))
+	for i, node := range p.nodes {
+		nodes[i] = *node
+	}
+	return nodes
+}
+
+// rank returns 0 for preferred nodes, 1 for lagging and 2 for unhealthy or forked nodes
+func (p *Pool) rank(node *Node, maxHead int64) int {
+	if !node.Healthy || node.Forked {
+		return 2
+	}
+	if maxHead-node.Head > p.MaxHeadLag {
+		return 1
+	}
+	return 0
+}
+
+// orderedNodes returns all nodes, best first
+func (p *Pool) orderedNodes() []*Node {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	var maxHead int64
+	for _, node := range p.nodes {
+		if node.Healthy && node.Head > maxHead {
+			maxHead = node.Head
+		}
+	}
+
+	nodes := append([]*Node{}, p.nodes...)
+	sort.SliceStable(nodes, func(i, j int) bool {
+		rankI, rankJ := p.rank(nodes[i], maxHead), p.rank(nodes[j], maxHead)
+		if rankI != rankJ {
+			return rankI < rankJ
+		}
+		return nodes[i].Latency < nodes[j].Latency
+	})
+	return nodes
+}
+
+// Client returns the client of the best node
+func (p *Pool) Client() *ethclient.Client {
+	return p.orderedNodes()[0].Client
+}
+
+// Do calls fn with the client of the best node, and with the next nodes as long as it returns an error. Nodes with
+// errors (other than not found) are marked unhealthy until the next successful health check. Once ctx is done, errors
+// are caused by the caller: nodes are not marked and there is no failover. Returns the last error.
+func (p *Pool) Do(ctx context.Context, fn func(client *ethclient.Client) error) error {
+	return p.DoNode(ctx, func(node *Node) error {
+		return fn(node.Client)
+	})
+}
+
+// DoNode is like Do, but passes the node (eg. to use its Fetcher)
+func (p *Pool) DoNode(ctx context.Context, fn func(node *Node) error) (err error) {
+	for i, node := range p.orderedNodes() {
+		if i > 0 {
+			log.Printf("Failover to eth node %s after error: %v\n", node.Name, err)
+		}
+
+		err = fn(node)
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return err
+		}
+
+		if !errors.Is(err, ethereum.NotFound) { // not found can be a lagging node, but does not make it unhealthy
+			p.markUnhealthy(node, err)
+		}
+	}
+	return err
+}
+
+func (p *Pool) markUnhealthy(node *Node, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	node.Healthy = false
+	node.LastErr = err
+}
+
+// CheckHealth updates head and latency of all nodes, and cross-checks block hashes if enabled
+func (p *Pool) CheckHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, node := range p.orderedNodes() {
+		wg.Add(1)
+		go func(node *Node) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			timeStart := time.Now()
+			header, err := node.Client.HeaderByNumber(ctx, nil)
+
+			p.lock.Lock()
+			defer p.lock.Unlock()
+			if err != nil {
+				if node.Healthy {
+					log.Printf("Eth node %s is unhealthy: %v\n", node.Name, err)
+				}
+				node.Healthy = false
+				node.LastErr = err
+				return
+			}
+
+			node.Healthy = true
+			node.LastErr = nil
+			node.Head = header.Number.Int64()
+			node.Latency = time.Since(timeStart)
+		}(node)
+	}
+	wg.Wait()
+
+	if p.CrossCheckConfirmations > 0 {
+		if err := p.CrossCheck(ctx, p.minHealthyHead()-p.CrossCheckConfirmations); err != nil {
+			log.Println("Eth node cross-check:", err)
+		}
+	}
+}
+
+// Run checks the health of all nodes at the given interval, until the context is cancelled
+func (p *Pool) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.CheckHealth(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *Pool) minHealthyHead() int64 {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	var minHead int64
+	for _, node := range p.nodes {
+		if node.Healthy && (minHead == 0 || node.Head < minHead) {
+			minHead = node.Head
+		}
+	}
+	return minHead
+}
+
+// CrossCheck compares the block hash at the given height between all healthy nodes. Nodes that disagree with the
+// majority are marked as forked (and only used if all other nodes fail) until the next cross-check.
+func (p *Pool) CrossCheck(ctx context.Context, number int64) error {
+	if number <= 0 {
+		return nil
+	}
+
+	p.lock.RLock()
+	healthyNodes := make([]*Node, 0)
+	for _, node := range p.nodes {
+		if node.Healthy {
+			healthyNodes = append(healthyNodes, node)
+		}
+	}
+	p.lock.RUnlock()
+
+	hashes := make(map[*Node]common.Hash)
+	failed := make([]*Node, 0)
+	votes := make(map[common.Hash]int)
+	for _, node := range healthyNodes {
+		ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		header, err := node.Client.HeaderByNumber(ctx, big.NewInt(number))
+		cancel()
+		if err != nil {
+			failed = append(failed, node)
+			continue
+		}
+		hashes[node] = header.Hash()
+		votes[header.Hash()] += 1
+	}
+
+	// Nodes are only marked as forked if there is a strict majority for another hash
+	var majorityHash common.Hash
+	isTie := false
+	for hash, count := range votes {
+		if count > votes[majorityHash] {
+			majorityHash = hash
+			isTie = false
+		} else if count == votes[majorityHash] {
+			isTie = true
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, node := range failed {
+		node.Forked = false // unknown
+	}
+
+	if isTie {
+		disagreement := make([]string, 0, len(hashes))
+		for node, hash := range hashes {
+			disagreement = append(disagreement, fmt.Sprintf("%s has %s", node.Name, hash.Hex()))
+		}
+		sort.Strings(disagreement)
+		return fmt.Errorf("block %d has no majority hash: %s", number, strings.Join(disagreement, ", "))
+	}
+
+	forked := make([]string, 0)
+	for node, hash := range hashes {
+		node.Forked = hash != majorityHash
+		if node.Forked {
+			forked = append(forked, fmt.Sprintf("%s has %s", node.Name, hash.Hex()))
+		}
+	}
+
+	if len(forked) > 0 {
+		sort.Strings(forked)
+		return fmt.Errorf("block %d has hash %s on %d nodes, but %s", number, majorityHash.Hex(), votes[majorityHash], strings.Join(forked, ", "))
+	}
+	return nil
+}
+
+// HeaderByHash implements watcher.HeaderReader
+func (p *Pool) HeaderByHash(ctx context.Context, hash common.Hash) (header *types.Header, err error) {
+	err = p.Do(ctx, func(client *ethclient.Client) error {
+		header, err = client.HeaderByHash(ctx, hash)
+		return err
+	})
+	return header, err
+}
+
+// HeaderByNumber implements watcher.HeaderReader
+func (p *Pool) HeaderByNumber(ctx context.Context, number *big.Int) (header *types.Header, err error) {
+	err = p.Do(ctx, func(client *ethclient.Client) error {
+		header, err = client.HeaderByNumber(ctx, number)
+		return err
+	})
+	return header, err
+}
+
+// GetBlockWithTxReceipts implements common.BlockWithTxReceiptsReader
+func (p *Pool) GetBlockWithTxReceipts(ctx context.Context, height int64) (block *blockswithtx.BlockWithTxReceipts, err error) {
+	err = p.DoNode(ctx, func(node *Node) error {
+		block, err = node.Fetcher.GetBlockWithTxReceipts(ctx, height)
+		return err
+	})
+	return block, err
+}
+
+// SubscribeNewHead implements watcher.HeadSubscriber, subscribing at the best node that accepts the subscription.
+// When the subscription fails, resubscribing picks the best node again. Nodes without subscription support (eg. HTTP)
+// are skipped, if no node supports subscriptions rpc.ErrNotificationsUnsupported is returned.
+func (p *Pool) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (sub ethereum.Subscription, err error) {
+	for _, node := range p.orderedNodes() {
+		sub, err = node.Client.SubscribeNewHead(ctx, ch)
+		if err == nil {
+			return sub, nil
+		}
+
+		if !errors.Is(err, rpc.ErrNotificationsUnsupported) && err.Error() != rpc.ErrNotificationsUnsupported.Error() {
+			p.markUnhealthy(node, err)
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,136 @@
+package nodepool
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	fbcommon "github.com/metachris/flashbots/common"
+)
+
+// testEthService serves eth_getBlockByNumber with a chain of the given height
+type testEthService struct {
+	head   int64
+	extra  byte // makes the hashes unique per node, to simulate a fork
+	broken bool
+}
+
+func (s *testEthService) GetBlockByNumber(number string, full bool) (*types.Header, error) {
+	if s.broken {
+		return nil, errors.New("node is broken")
+	}
+
+	height := s.head
+	if number != "latest" {
+		n, ok := new(big.Int).SetString(number[2:], 16)
+		if !ok {
+			return nil, errors.New("invalid block number")
+		}
+		height = n.Int64()
+	}
+	return &types.Header{Number: big.NewInt(height), Difficulty: big.NewInt(1), Extra: []byte{s.extra}}, nil
+}
+
+func newTestNode(t *testing.T, name string, service *testEthService) *Node {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", service); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	fetcher := fbcommon.NewBlockFetcher(rpc.DialInProc(server))
+	return &Node{Name: name, Client: fetcher.Client, Fetcher: fetcher, Healthy: true}
+}
+
+func TestPoolFailover(t *testing.T) {
+	broken := &testEthService{head: 100, broken: true}
+	lagging := &testEthService{head: 90}
+	good := &testEthService{head: 100}
+
+	pool := &Pool{MaxHeadLag: 3}
+	pool.nodes = []*Node{newTestNode(t, "broken", broken), newTestNode(t, "lagging", lagging), newTestNode(t, "good", good)}
+	pool.nodes[0].Latency = 1 // preferred before the health check
+
+	pool.CheckHealth(context.Background())
+	if order := pool.orderedNodes(); order[0].Name != "good" || order[1].Name != "lagging" || order[2].Name != "broken" {
+		t.Errorf("unexpected node order: %s, %s, %s", order[0].Name, order[1].Name, order[2].Name)
+	}
+
+	// Good node fails: requests go to the lagging node
+	good.broken = true
+	header, err := pool.HeaderByNumber(context.Background(), nil)
+	if err != nil || header.Number.Int64() != 90 {
+		t.Fatal("expected failover to the lagging node:", header, err)
+	}
+	if pool.Nodes()[2].Healthy {
+		t.Error("failed node should be marked unhealthy")
+	}
+
+	// Recovers with the next health check
+	good.broken = false
+	pool.CheckHealth(context.Background())
+	if pool.orderedNodes()[0].Name != "good" {
+		t.Error("good node should be preferred again")
+	}
+}
+
+func TestPoolCancelledContext(t *testing.T) {
+	pool := &Pool{MaxHeadLag: 3}
+	pool.nodes = []*Node{newTestNode(t, "a", &testEthService{head: 100}), newTestNode(t, "b", &testEthService{head: 100})}
+
+	// Errors of a cancelled request do not make the node unhealthy, and do not fail over
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pool.HeaderByNumber(ctx, nil); err == nil {
+		t.Fatal("expected error with cancelled context")
+	}
+	for _, node := range pool.Nodes() {
+		if !node.Healthy {
+			t.Errorf("node %s should still be healthy", node.Name)
+		}
+	}
+}
+
+func TestPoolCrossCheck(t *testing.T) {
+	pool := &Pool{MaxHeadLag: 3}
+	pool.nodes = []*Node{
+		newTestNode(t, "a", &testEthService{head: 100}),
+		newTestNode(t, "forked", &testEthService{head: 100, extra: 1}),
+		newTestNode(t, "b", &testEthService{head: 100}),
+	}
+
+	if err := pool.CrossCheck(context.Background(), 98); err == nil {
+		t.Error("expected cross-check error")
+	}
+	if !pool.Nodes()[1].Forked || pool.Nodes()[0].Forked || pool.orderedNodes()[2].Name != "forked" {
+		t.Error("forked node not detected")
+	}
+}
+
+func TestPoolCrossCheckTie(t *testing.T) {
+	broken := &testEthService{head: 100}
+	pool := &Pool{MaxHeadLag: 3}
+	pool.nodes = []*Node{
+		newTestNode(t, "a", &testEthService{head: 100}),
+		newTestNode(t, "b", &testEthService{head: 100, extra: 1}),
+		newTestNode(t, "broken", broken),
+	}
+	pool.nodes[1].Forked = true
+	pool.nodes[2].Forked = true
+
+	// 1-1 tie with a failed request: nobody is marked forked, the failed node is reset
+	broken.broken = true
+	if err := pool.CrossCheck(context.Background(), 98); err == nil {
+		t.Error("expected cross-check error")
+	}
+	for _, node := range pool.Nodes() {
+		if node.Name != "b" && node.Forked {
+			t.Errorf("node %s should not be forked", node.Name)
+		}
+	}
+	if !pool.Nodes()[1].Forked {
+		t.Error("forked state of b should be unchanged on a tie")
+	}
+}
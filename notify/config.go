@@ -0,0 +1,131 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// NotifierConfig is the configuration of a single notifier and its route. Which fields are used depends on Type.
+//
+// Example config file:
+//
+//	{
+//	  "notifiers": [
+//	    {"type": "discord", "url": "https://discord.com/api/webhooks/...", "min_severity": "critical"},
+//	    {"type": "slack", "url": "https://hooks.slack.com/services/...", "finding_types": ["failed_flashbots_tx"]},
+//	    {"type": "telegram", "bot_token": "123:abc", "chat_id": "-100123"},
+//	    {"type": "webhook", "url": "https://example.com/alerts", "headers": {"Authorization": "Bearer xyz"}},
+//	    {"type": "email", "smtp_host": "smtp.example.com", "smtp_port": 587, "username": "alerts", "password": "...",
+//	     "from": "alerts@example.com", "to": ["oncall@example.com"], "min_severity": "critical"}
+//	  ]
+//	}
+type NotifierConfig struct {
+	Type         string   `json:"type"` // discord, slack, telegram, webhook, email
+	Name         string   `json:"name"` // optional, defaults to type
+	MinSeverity  string   `json:"min_severity"`
+	FindingTypes []string `json:"finding_types"`
+
+	Url     string            `json:"url"` // discord, slack, webhook
+	Headers map[string]string `json:"headers"`
+
+	BotToken string `json:"bot_token"` // telegram
+	ChatId   string `json:"chat_id"`
+
+	SmtpHost string   `json:"smtp_host"` // email
+	SmtpPort int      `json:"smtp_port"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+type Config struct {
+	Notifiers []NotifierConfig `json:"notifiers"`
+}
+
+func LoadConfig(filename string) (config Config, err error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return config, err
+	}
+
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return config, fmt.Errorf("invalid notify config %s: %w", filename, err)
+	}
+	return config, nil
+}
+
+func NewNotifier(cfg NotifierConfig) (Notifier, error) {
+	name := cfg.Name
+	if name == "" {
+		name = cfg.Type
+	}
+
+	switch cfg.Type {
+	case "discord":
+		if cfg.Url == "" {
+			return nil, fmt.Errorf("notifier %s: missing url", name)
+		}
+		return NewDiscordNotifier(name, cfg.Url), nil
+	case "slack":
+		if cfg.Url == "" {
+			return nil, fmt.Errorf("notifier %s: missing url", name)
+		}
+		return NewSlackNotifier(name, cfg.Url), nil
+	case "telegram":
+		if cfg.BotToken == "" || cfg.ChatId == "" {
+			return nil, fmt.Errorf("notifier %s: missing bot_token or chat_id", name)
+		}
+		return NewTelegramNotifier(name, cfg.BotToken, cfg.ChatId), nil
+	case "webhook":
+		if cfg.Url == "" {
+			return nil, fmt.Errorf("notifier %s: missing url", name)
+		}
+		notifier := NewWebhookNotifier(name, cfg.Url)
+		notifier.Headers = cfg.Headers
+		return notifier, nil
+	case "email":
+		if cfg.SmtpHost == "" || cfg.From == "" || len(cfg.To) == 0 {
+			return nil, fmt.Errorf("notifier %s: missing smtp_host, from or to", name)
+		}
+		port := cfg.SmtpPort
+		if port == 0 {
+			port = 587
+		}
+		return &EmailNotifier{
+			name:     name,
+			Host:     cfg.SmtpHost,
+			Port:     port,
+			Username: cfg.Username,
+			Password: cfg.Password,
+			From:     cfg.From,
+			To:       cfg.To,
+		}, nil
+	}
+	return nil, fmt.Errorf("notifier %s: unknown type %s", name, cfg.Type)
+}
+
+// NewDispatcherFromConfig creates all notifiers of the config and routes them by severity and finding types
+func NewDispatcherFromConfig(config Config) (*Dispatcher, error) {
+	dispatcher := NewDispatcher()
+	for _, cfg := range config.Notifiers {
+		notifier, err := NewNotifier(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		minSeverity, err := ParseSeverity(cfg.MinSeverity)
+		if err != nil {
+			return nil, fmt.Errorf("notifier %s: %w", notifier.Name(), err)
+		}
+
+		dispatcher.AddRoute(&Route{
+			Notifier:     notifier,
+			MinSeverity:  minSeverity,
+			FindingTypes: cfg.FindingTypes,
+		})
+	}
+	return dispatcher, nil
+}
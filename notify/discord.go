@@ -0,0 +1,275 @@
+// Discord webhook notifier
+// https://discord.com/developers/docs/resources/webhook#execute-webhook
+// https://discord.com/developers/docs/topics/rate-limits
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Discord limits (in characters)
+const (
+	discordMaxContentLength     = 2000
+	discordMaxTitleLength       = 256
+	discordMaxDescriptionLength = 4096
+	discordMaxFieldNameLength   = 256
+	discordMaxFieldValueLength  = 1024
+	discordMaxFieldsPerEmbed    = 25
+	discordMaxEmbedsPerMessage  = 10
+	discordMaxEmbedsTotalLength = 6000
+)
+
+// Embed colors by severity
+var discordSeverityColors = map[Severity]int{
+	SeverityInfo:     0x3498db, // blue
+	SeverityWarning:  0xf39c12, // orange
+	SeverityCritical: 0xe74c3c, // red
+}
+
+type DiscordWebhookPayload struct {
+	Content string         `json:"content,omitempty"`
+	Embeds  []DiscordEmbed `json:"embeds,omitempty"`
+}
+
+type DiscordEmbed struct {
+	Title       string              `json:"title,omitempty"`
+	Url         string              `json:"url,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Color       int                 `json:"color,omitempty"`
+	Fields      []DiscordEmbedField `json:"fields,omitempty"`
+	Timestamp   string              `json:"timestamp,omitempty"`
+}
+
+type DiscordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+func (e DiscordEmbed) length() int {
+	n := utf8.RuneCountInString(e.Title) + utf8.RuneCountInString(e.Description)
+	for _, field := range e.Fields {
+		n += utf8.RuneCountInString(field.Name) + utf8.RuneCountInString(field.Value)
+	}
+	return n
+}
+
+type discordRateLimitResponse struct {
+	RetryAfter float64 `json:"retry_after"` // seconds
+	Global     bool    `json:"global"`
+}
+
+type discordDelivery struct {
+	payload DiscordWebhookPayload
+	result  chan error
+}
+
+// DiscordNotifier sends messages as embeds (or plain content). All deliveries go through one queue, which waits
+// when the webhook rate limit is exhausted and retries after a 429 response.
+type DiscordNotifier struct {
+	name       string
+	WebhookUrl string
+	UseEmbeds  bool
+	MaxRetries int // retries after 429 (rate limited) responses
+
+	queue     chan *discordDelivery
+	startOnce sync.Once
+
+	// Rate limit state from the last response (only used by the queue worker)
+	rateLimitRemaining int
+	rateLimitReset     time.Time
+}
+
+func NewDiscordNotifier(name string, webhookUrl string) *DiscordNotifier {
+	return &DiscordNotifier{
+		name:               name,
+		WebhookUrl:         webhookUrl,
+		UseEmbeds:          true,
+		MaxRetries:         5,
+		queue:              make(chan *discordDelivery, 100),
+		rateLimitRemaining: -1,
+	}
+}
+
+func (n *DiscordNotifier) Name() string {
+	return n.name
+}
+
+// Send queues the message and waits until it has been delivered, returning any delivery error
+func (n *DiscordNotifier) Send(msg Message) error {
+	var payloads []DiscordWebhookPayload
+	if n.UseEmbeds {
+		payloads = discordEmbedPayloads(msg)
+	} else {
+		payloads = discordContentPayloads(fullText(msg))
+	}
+
+	n.startOnce.Do(func() { go n.worker() })
+
+	for _, payload := range payloads {
+		delivery := &discordDelivery{payload: payload, result: make(chan error, 1)}
+		n.queue <- delivery
+		if err := <-delivery.result; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *DiscordNotifier) worker() {
+	for delivery := range n.queue {
+		delivery.result <- n.deliver(delivery.payload)
+	}
+}
+
+// deliver posts the payload, waiting for the rate limit to reset if necessary and retrying on 429 responses
+func (n *DiscordNotifier) deliver(payload DiscordWebhookPayload) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		if n.rateLimitRemaining == 0 && time.Now().Before(n.rateLimitReset) {
+			time.Sleep(time.Until(n.rateLimitReset))
+		}
+
+		res, err := httpClient.Post(n.WebhookUrl, "application/json", bytes.NewBuffer(payloadBytes))
+		if err != nil {
+			return err
+		}
+
+		bodyBytes, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		n.updateRateLimit(res.Header)
+
+		if res.StatusCode == http.StatusTooManyRequests {
+			if attempt >= n.MaxRetries {
+				return fmt.Errorf("discord rate limit: giving up after %d retries", attempt)
+			}
+			time.Sleep(discordRetryAfter(res.Header, bodyBytes))
+			continue
+		}
+
+		if res.StatusCode >= 300 {
+			return fmt.Errorf("discord response status %s: %s", res.Status, string(bodyBytes))
+		}
+		return nil
+	}
+}
+
+func (n *DiscordNotifier) updateRateLimit(header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+
+	resetAfter, err := strconv.ParseFloat(header.Get("X-RateLimit-Reset-After"), 64)
+	if err != nil {
+		return
+	}
+
+	n.rateLimitRemaining = remaining
+	n.rateLimitReset = time.Now().Add(time.Duration(resetAfter * float64(time.Second)))
+}
+
+// discordRetryAfter returns how long to wait after a 429 response (from the JSON body, or the Retry-After header)
+func discordRetryAfter(header http.Header, body []byte) time.Duration {
+	var rateLimitResponse discordRateLimitResponse
+	if err := json.Unmarshal(body, &rateLimitResponse); err == nil && rateLimitResponse.RetryAfter > 0 {
+		return time.Duration(rateLimitResponse.RetryAfter * float64(time.Second))
+	}
+
+	if sec, err := strconv.ParseFloat(header.Get("Retry-After"), 64); err == nil {
+		return time.Duration(sec * float64(time.Second))
+	}
+	return time.Second
+}
+
+// splitMarkdown splits the text into chunks at line boundaries. Code blocks that span multiple chunks are closed at
+// the end of one chunk and reopened in the next.
+func splitMarkdown(text string, maxLen int) []string {
+	chunks := make([]string, 0)
+	reopenCodeBlock := false
+	for _, chunk := range splitLines(text, maxLen-6) {
+		if reopenCodeBlock {
+			chunk = "```" + chunk
+		}
+
+		reopenCodeBlock = strings.Count(chunk, "```")%2 == 1
+		if reopenCodeBlock {
+			chunk += "```"
+		}
+
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func discordContentPayloads(text string) []DiscordWebhookPayload {
+	payloads := make([]DiscordWebhookPayload, 0)
+	for _, chunk := range splitMarkdown(text, discordMaxContentLength) {
+		payloads = append(payloads, DiscordWebhookPayload{Content: chunk})
+	}
+	return payloads
+}
+
+// discordEmbedPayloads creates embeds for the message (text as description, one embed field per message field),
+// and distributes them over as many webhook messages as needed to stay within the Discord limits.
+func discordEmbedPayloads(msg Message) []DiscordWebhookPayload {
+	color := discordSeverityColors[msg.Severity]
+	embeds := make([]DiscordEmbed, 0)
+
+	// Description, split into multiple embeds if necessary
+	for _, chunk := range splitMarkdown(msg.Text, discordMaxDescriptionLength) {
+		embeds = append(embeds, DiscordEmbed{Description: chunk, Color: color})
+	}
+	if len(embeds) == 0 {
+		embeds = append(embeds, DiscordEmbed{Color: color})
+	}
+
+	// Fields, up to 25 per embed
+	for _, field := range msg.Fields {
+		embedField := DiscordEmbedField{
+			Name:  truncate(field.Name, discordMaxFieldNameLength),
+			Value: truncate(field.Value, discordMaxFieldValueLength),
+		}
+		fieldLen := utf8.RuneCountInString(embedField.Name) + utf8.RuneCountInString(embedField.Value)
+
+		embed := &embeds[len(embeds)-1]
+		if len(embed.Fields) == discordMaxFieldsPerEmbed || embed.length()+fieldLen > discordMaxEmbedsTotalLength-discordMaxTitleLength {
+			embeds = append(embeds, DiscordEmbed{Color: color})
+			embed = &embeds[len(embeds)-1]
+		}
+		embed.Fields = append(embed.Fields, embedField)
+	}
+
+	embeds[0].Title = truncate(msg.Title, discordMaxTitleLength)
+	embeds[0].Url = msg.Url
+	embeds[len(embeds)-1].Timestamp = time.Now().UTC().Format(time.RFC3339)
+
+	// Distribute embeds over messages (max 10 embeds and 6000 characters per message)
+	payloads := make([]DiscordWebhookPayload, 0)
+	current := DiscordWebhookPayload{}
+	currentLen := 0
+	for _, embed := range embeds {
+		embedLen := embed.length()
+		if len(current.Embeds) == discordMaxEmbedsPerMessage || (len(current.Embeds) > 0 && currentLen+embedLen > discordMaxEmbedsTotalLength) {
+			payloads = append(payloads, current)
+			current = DiscordWebhookPayload{}
+			currentLen = 0
+		}
+		current.Embeds = append(current.Embeds, embed)
+		currentLen += embedLen
+	}
+	return append(payloads, current)
+}
@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitLines(t *testing.T) {
+	chunks := splitLines("aaa\nbbb\nccc\n", 8)
+	if len(chunks) != 2 || chunks[0] != "aaa\nbbb\n" || chunks[1] != "ccc\n" {
+		t.Error("Unexpected chunks:", chunks)
+	}
+
+	// Long lines are split at character boundaries, never inside a multi-byte character
+	chunks = splitLines(strings.Repeat("ä", 10), 4)
+	if len(chunks) != 3 {
+		t.Fatal("Unexpected number of chunks:", len(chunks))
+	}
+	for _, chunk := range chunks {
+		if !utf8.ValidString(chunk) {
+			t.Error("Invalid UTF-8 in chunk:", chunk)
+		}
+	}
+}
+
+func TestSplitMarkdownCodeBlock(t *testing.T) {
+	text := "header\n```\n" + strings.Repeat("line\n", 10) + "```"
+	chunks := splitMarkdown(text, 30)
+	if len(chunks) < 2 {
+		t.Fatal("Expected multiple chunks, got", chunks)
+	}
+	for _, chunk := range chunks {
+		if strings.Count(chunk, "```")%2 != 0 {
+			t.Error("Unbalanced code block in chunk:", chunk)
+		}
+		if utf8.RuneCountInString(chunk) > 30 {
+			t.Error("Chunk too long:", chunk)
+		}
+	}
+}
+
+func TestDiscordEmbedPayloads(t *testing.T) {
+	msg := Message{Title: "Block 123", Text: "errors", Severity: SeverityCritical}
+	for i := 0; i < 30; i++ {
+		msg.Fields = append(msg.Fields, Field{Name: "Bundle", Value: strings.Repeat("x", 2000)})
+	}
+
+	payloads := discordEmbedPayloads(msg)
+	numFields := 0
+	for _, payload := range payloads {
+		if len(payload.Embeds) > discordMaxEmbedsPerMessage {
+			t.Error("Too many embeds:", len(payload.Embeds))
+		}
+
+		totalLen := 0
+		for _, embed := range payload.Embeds {
+			totalLen += embed.length()
+			numFields += len(embed.Fields)
+			if embed.Color != discordSeverityColors[SeverityCritical] {
+				t.Error("Wrong color:", embed.Color)
+			}
+			for _, field := range embed.Fields {
+				if utf8.RuneCountInString(field.Value) > discordMaxFieldValueLength {
+					t.Error("Field value too long")
+				}
+			}
+		}
+		if totalLen > discordMaxEmbedsTotalLength {
+			t.Error("Message too long:", totalLen)
+		}
+	}
+
+	if numFields != 30 {
+		t.Error("Wrong number of fields:", numFields)
+	}
+	if payloads[0].Embeds[0].Title != "Block 123" {
+		t.Error("Wrong title:", payloads[0].Embeds[0].Title)
+	}
+}
+
+func TestDiscordRateLimitRetry(t *testing.T) {
+	numRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		numRequests += 1
+		if numRequests == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(discordRateLimitResponse{RetryAfter: 0.01})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	err := NewDiscordNotifier("discord", server.URL).Send(Message{Text: "x"})
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if numRequests != 2 {
+		t.Error("Expected a retry, requests:", numRequests)
+	}
+}
+
+func TestDiscordRateLimitGivesUp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	notifier := NewDiscordNotifier("discord", server.URL)
+	notifier.MaxRetries = 2
+	if err := notifier.Send(Message{Text: "x"}); err == nil {
+		t.Error("Expected error")
+	}
+}
@@ -0,0 +1,80 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Route sends messages to a notifier if they match its minimum severity and finding types
+type Route struct {
+	Notifier     Notifier
+	MinSeverity  Severity
+	FindingTypes []string // if empty, all finding types are sent
+}
+
+func (r *Route) Matches(msg Message) bool {
+	if msg.Severity < r.MinSeverity {
+		return false
+	}
+
+	if len(r.FindingTypes) == 0 {
+		return true
+	}
+
+	for _, routeType := range r.FindingTypes {
+		for _, msgType := range msg.FindingTypes {
+			if routeType == msgType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Dispatcher sends each message to all notifiers with a matching route
+type Dispatcher struct {
+	Routes []*Route
+
+	// OnError is called for every failed delivery (optional)
+	OnError func(notifier string, err error)
+}
+
+func NewDispatcher(routes ...*Route) *Dispatcher {
+	return &Dispatcher{Routes: routes}
+}
+
+func (d *Dispatcher) AddRoute(route *Route) {
+	d.Routes = append(d.Routes, route)
+}
+
+func (d *Dispatcher) HasRoutes() bool {
+	return d != nil && len(d.Routes) > 0
+}
+
+// Send delivers the message to all matching notifiers. Delivery continues if a notifier fails, and all errors
+// are returned combined.
+func (d *Dispatcher) Send(msg Message) error {
+	if d == nil {
+		return nil
+	}
+
+	errs := make([]string, 0)
+	for _, route := range d.Routes {
+		if !route.Matches(msg) {
+			continue
+		}
+
+		err := route.Notifier.Send(msg)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", route.Notifier.Name(), err))
+			if d.OnError != nil {
+				d.OnError(route.Notifier.Name(), err)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("notify error: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
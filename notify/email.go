@@ -0,0 +1,51 @@
+// SMTP email notifier
+package notify
+
+import (
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+type EmailNotifier struct {
+	name     string
+	Host     string
+	Port     int
+	Username string // no authentication if empty
+	Password string
+	From     string
+	To       []string
+}
+
+func (n *EmailNotifier) Name() string {
+	return n.name
+}
+
+func (n *EmailNotifier) Send(msg Message) error {
+	subject := msg.Title
+	if subject == "" {
+		subject = fmt.Sprintf("[%s] Flashbots alert", msg.Severity)
+	}
+
+	data := "From: " + n.From + "\r\n" +
+		"To: " + strings.Join(n.To, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		strings.ReplaceAll(plainText(body(msg)), "\n", "\r\n")
+
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+
+	addr := fmt.Sprintf("%s:%d", n.Host, n.Port)
+	return smtp.SendMail(addr, auth, n.From, n.To, []byte(data))
+}
+
+// body returns text and fields of the message (the title is used as subject)
+func body(msg Message) string {
+	msg.Title = ""
+	return fullText(msg)
+}
@@ -0,0 +1,396 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sender is implemented by Dispatcher and all notifiers
+type Sender interface {
+	Send(msg Message) error
+}
+
+type AlertManagerConfig struct {
+	DedupWindow        time.Duration // identical findings (same block, block hash, miner and finding types) within this window are dropped
+	GroupInterval      time.Duration // further alerts of a miner with an open incident are sent as one update per interval
+	ResolveAfterBlocks int           // an incident is resolved after this many consecutive clean blocks by the miner
+
+	MinerRateLimit  int // max messages per miner in RateInterval (0 = unlimited)
+	GlobalRateLimit int // max messages in total in RateInterval (0 = unlimited)
+	RateInterval    time.Duration
+}
+
+func DefaultAlertManagerConfig() AlertManagerConfig {
+	return AlertManagerConfig{
+		DedupWindow:        24 * time.Hour,
+		GroupInterval:      10 * time.Minute,
+		ResolveAfterBlocks: 5,
+		MinerRateLimit:     6,
+		GlobalRateLimit:    30,
+		RateInterval:       time.Hour,
+	}
+}
+
+// incident collects the alerts of one miner, from the first alert until the miner had enough clean blocks
+type incident struct {
+	miner        string
+	minerName    string
+	started      time.Time
+	lastSent     time.Time
+	blocks       []int64
+	findingTypes map[string]int // number of blocks per finding type
+	maxSeverity  Severity
+	cleanBlocks  int
+
+	pending []Message // alerts not yet sent (grouped or rate limited)
+}
+
+func (i *incident) addFindingTypes(msg Message) {
+	for _, findingType := range msg.FindingTypes {
+		i.findingTypes[findingType] += 1
+	}
+}
+
+func (i *incident) sortedFindingTypes() []string {
+	res := make([]string, 0, len(i.findingTypes))
+	for findingType := range i.findingTypes {
+		res = append(res, findingType)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (i *incident) minerId() string {
+	if i.minerName != "" {
+		return i.minerName
+	}
+	return i.miner
+}
+
+// AlertManager sits between block checks and the notifiers: it drops duplicate findings, groups the alerts of a
+// misbehaving miner into one incident, enforces per-miner and global rate limits and sends a resolution message
+// once the miner has been clean for ResolveAfterBlocks blocks.
+type AlertManager struct {
+	Config AlertManagerConfig
+	sender Sender
+	now    func() time.Time
+
+	lock      sync.Mutex
+	incidents map[string]*incident // by miner
+	seen      map[string]time.Time // finding fingerprint -> time first seen
+	sentTimes map[string][]time.Time
+}
+
+func NewAlertManager(sender Sender, config AlertManagerConfig) *AlertManager {
+	return &AlertManager{
+		Config:    config,
+		sender:    sender,
+		now:       time.Now,
+		incidents: make(map[string]*incident),
+		seen:      make(map[string]time.Time),
+		sentTimes: make(map[string][]time.Time),
+	}
+}
+
+func fingerprint(msg Message) string {
+	findingTypes := append([]string{}, msg.FindingTypes...)
+	sort.Strings(findingTypes)
+	return fmt.Sprintf("%d/%s/%s/%s", msg.Block, msg.BlockHash, strings.ToLower(msg.Miner), strings.Join(findingTypes, ","))
+}
+
+// Alert handles a block with findings
+func (m *AlertManager) Alert(msg Message) error {
+	m.lock.Lock()
+	outbox := m.alert(msg)
+	m.lock.Unlock()
+	return m.send(outbox)
+}
+
+func (m *AlertManager) alert(msg Message) []Message {
+	now := m.now()
+	m.expireSeen(now)
+
+	key := fingerprint(msg)
+	if _, isDuplicate := m.seen[key]; isDuplicate {
+		return nil
+	}
+	m.seen[key] = now
+
+	minerKey := strings.ToLower(msg.Miner)
+	inc, isOpen := m.incidents[minerKey]
+	if !isOpen {
+		inc = &incident{
+			miner:        msg.Miner,
+			minerName:    msg.MinerName,
+			started:      now,
+			findingTypes: make(map[string]int),
+		}
+		m.incidents[minerKey] = inc
+	}
+
+	inc.cleanBlocks = 0
+	inc.blocks = append(inc.blocks, msg.Block)
+	inc.addFindingTypes(msg)
+	if msg.Severity > inc.maxSeverity {
+		inc.maxSeverity = msg.Severity
+	}
+
+	if !isOpen && m.allow(minerKey, now) {
+		// First alert of the incident is sent right away
+		inc.lastSent = now
+		m.markSent(minerKey, now)
+		return []Message{msg}
+	}
+
+	inc.pending = append(inc.pending, msg)
+	return m.flush(minerKey, inc, now, false)
+}
+
+// Retract handles a block with an alert that was reorged out. If the alert is still pending, it is dropped without a
+// message, else the retraction is sent (deduplicated and rate limited like alerts, dropped if rate limited).
+func (m *AlertManager) Retract(msg Message) error {
+	m.lock.Lock()
+	outbox := m.retract(msg)
+	m.lock.Unlock()
+	return m.send(outbox)
+}
+
+func (m *AlertManager) retract(msg Message) []Message {
+	now := m.now()
+	m.expireSeen(now)
+
+	key := "retracted/" + fingerprint(msg)
+	if _, isDuplicate := m.seen[key]; isDuplicate {
+		return nil
+	}
+	m.seen[key] = now
+
+	minerKey := strings.ToLower(msg.Miner)
+	if inc, isOpen := m.incidents[minerKey]; isOpen {
+		for i, pending := range inc.pending {
+			if pending.BlockHash == msg.BlockHash {
+				inc.pending = append(inc.pending[:i], inc.pending[i+1:]...)
+				return nil
+			}
+		}
+	}
+
+	if !m.allow(minerKey, now) {
+		return nil
+	}
+	m.markSent(minerKey, now)
+	return []Message{msg}
+}
+
+// Clean handles a block of the miner without findings, and resolves an open incident after enough clean blocks
+func (m *AlertManager) Clean(miner string, block int64) error {
+	m.lock.Lock()
+	outbox := m.clean(miner, block)
+	m.lock.Unlock()
+	return m.send(outbox)
+}
+
+func (m *AlertManager) clean(miner string, block int64) []Message {
+	minerKey := strings.ToLower(miner)
+	inc, isOpen := m.incidents[minerKey]
+	if !isOpen {
+		return nil
+	}
+
+	now := m.now()
+	inc.cleanBlocks += 1
+	if inc.cleanBlocks < m.Config.ResolveAfterBlocks {
+		return m.flush(minerKey, inc, now, false)
+	}
+
+	delete(m.incidents, minerKey)
+	return m.resolve(minerKey, inc, now, block)
+}
+
+// Flush sends pending grouped alerts of all incidents whose group interval has passed (can be called periodically)
+func (m *AlertManager) Flush() error {
+	return m.flushAll(false)
+}
+
+// FlushAll sends the pending alerts of all incidents right away, ignoring group interval and rate limits (eg. before
+// shutting down)
+func (m *AlertManager) FlushAll() error {
+	return m.flushAll(true)
+}
+
+func (m *AlertManager) flushAll(force bool) error {
+	m.lock.Lock()
+	outbox := make([]Message, 0)
+	now := m.now()
+	for minerKey, inc := range m.incidents {
+		outbox = append(outbox, m.flush(minerKey, inc, now, force)...)
+	}
+	m.lock.Unlock()
+
+	if err := m.send(outbox); err != nil {
+		return fmt.Errorf("alert manager flush: %w", err)
+	}
+	return nil
+}
+
+// NumOpenIncidents returns the number of miners with an open incident
+func (m *AlertManager) NumOpenIncidents() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.incidents)
+}
+
+// flush returns the pending alerts of the incident as one update message to send, if the group interval has passed
+// (or force)
+func (m *AlertManager) flush(minerKey string, inc *incident, now time.Time, force bool) []Message {
+	if len(inc.pending) == 0 {
+		return nil
+	}
+
+	if !force && now.Sub(inc.lastSent) < m.Config.GroupInterval {
+		return nil
+	}
+
+	if !force && !m.allow(minerKey, now) {
+		return nil
+	}
+
+	msg := groupMessage(inc)
+	inc.pending = nil
+	inc.lastSent = now
+	m.markSent(minerKey, now)
+	return []Message{msg}
+}
+
+// resolve returns what is still pending of the incident and the resolution message to send
+func (m *AlertManager) resolve(minerKey string, inc *incident, now time.Time, block int64) []Message {
+	// Resolutions are not rate limited
+	outbox := m.flush(minerKey, inc, now, true)
+
+	findingCounts := make([]string, 0, len(inc.findingTypes))
+	for _, findingType := range inc.sortedFindingTypes() {
+		findingCounts = append(findingCounts, fmt.Sprintf("%s: %d", findingType, inc.findingTypes[findingType]))
+	}
+
+	msg := Message{
+		Title:        fmt.Sprintf("Resolved: miner %s clean for %d blocks", inc.minerId(), inc.cleanBlocks),
+		Text:         fmt.Sprintf("Incident started %s (%s ago), %d blocks with findings: %s\nLast clean block: %d", inc.started.UTC().Format(time.RFC3339), now.Sub(inc.started).Round(time.Second), len(inc.blocks), strings.Join(findingCounts, ", "), block),
+		Severity:     inc.maxSeverity, // same routes as the alerts of the incident
+		FindingTypes: inc.sortedFindingTypes(),
+		Block:        block,
+		Miner:        inc.miner,
+		MinerName:    inc.minerName,
+	}
+	m.markSent(minerKey, now)
+	return append(outbox, msg)
+}
+
+// groupMessage combines the pending alerts of an incident into one message
+func groupMessage(inc *incident) Message {
+	const maxListedBlocks = 20
+
+	msg := Message{
+		Title:     fmt.Sprintf("Miner %s: %d more blocks with findings (%d in this incident)", inc.minerId(), len(inc.pending), len(inc.blocks)),
+		Miner:     inc.miner,
+		MinerName: inc.minerName,
+	}
+
+	findingTypes := make(map[string]bool)
+	lines := make([]string, 0)
+	for i, pending := range inc.pending {
+		if pending.Severity > msg.Severity {
+			msg.Severity = pending.Severity
+		}
+		for _, findingType := range pending.FindingTypes {
+			findingTypes[findingType] = true
+		}
+		msg.Block = pending.Block
+
+		if i < maxListedBlocks {
+			lines = append(lines, fmt.Sprintf("- block [%d](<https://etherscan.io/block/%d>): %s", pending.Block, pending.Block, strings.Join(pending.FindingTypes, ", ")))
+		}
+	}
+
+	if len(inc.pending) > maxListedBlocks {
+		lines = append(lines, fmt.Sprintf("- ... and %d more", len(inc.pending)-maxListedBlocks))
+	}
+
+	for findingType := range findingTypes {
+		msg.FindingTypes = append(msg.FindingTypes, findingType)
+	}
+	sort.Strings(msg.FindingTypes)
+	msg.Text = strings.Join(lines, "\n")
+	return msg
+}
+
+// allow returns true if neither the miner nor the global rate limit is exhausted
+func (m *AlertManager) allow(minerKey string, now time.Time) bool {
+	if m.Config.MinerRateLimit > 0 && m.countSent(minerKey, now) >= m.Config.MinerRateLimit {
+		return false
+	}
+	if m.Config.GlobalRateLimit > 0 && m.countSent("", now) >= m.Config.GlobalRateLimit {
+		return false
+	}
+	return true
+}
+
+// countSent returns the number of messages sent within RateInterval (key "" is the global count)
+func (m *AlertManager) countSent(key string, now time.Time) int {
+	times := m.sentTimes[key]
+	i := 0
+	for i < len(times) && now.Sub(times[i]) >= m.Config.RateInterval {
+		i++
+	}
+	m.sentTimes[key] = times[i:]
+	return len(times) - i
+}
+
+func (m *AlertManager) markSent(minerKey string, now time.Time) {
+	m.sentTimes[minerKey] = append(m.sentTimes[minerKey], now)
+	m.sentTimes[""] = append(m.sentTimes[""], now)
+}
+
+// send delivers the messages, without holding the lock (notifiers do network I/O)
+func (m *AlertManager) send(outbox []Message) error {
+	errs := make([]string, 0)
+	for _, msg := range outbox {
+		if err := m.sender.Send(msg); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// expireSeen drops fingerprints and send times older than the dedup window (send times are kept for at least
+// RateInterval, to count them for the rate limits)
+func (m *AlertManager) expireSeen(now time.Time) {
+	for key, t := range m.seen {
+		if now.Sub(t) > m.Config.DedupWindow {
+			delete(m.seen, key)
+		}
+	}
+
+	maxAge := m.Config.DedupWindow
+	if m.Config.RateInterval > maxAge {
+		maxAge = m.Config.RateInterval
+	}
+	for key, times := range m.sentTimes {
+		i := 0
+		for i < len(times) && now.Sub(times[i]) > maxAge {
+			i++
+		}
+		if i == len(times) {
+			delete(m.sentTimes, key)
+		} else {
+			m.sentTimes[key] = times[i:]
+		}
+	}
+}
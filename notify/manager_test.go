@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time {
+	return c.t
+}
+
+func newTestAlertManager(config AlertManagerConfig) (*AlertManager, *testNotifier, *testClock) {
+	sender := &testNotifier{}
+	clock := &testClock{t: time.Unix(1630000000, 0)}
+	m := NewAlertManager(sender, config)
+	m.now = clock.now
+	return m, sender, clock
+}
+
+func alertMsg(miner string, block int64) Message {
+	return Message{Miner: miner, Block: block, Severity: SeverityCritical, FindingTypes: []string{"failed_flashbots_tx"}}
+}
+
+func TestAlertManagerDedup(t *testing.T) {
+	m, sender, _ := newTestAlertManager(DefaultAlertManagerConfig())
+	m.Alert(alertMsg("0xA", 1))
+	m.Alert(alertMsg("0xa", 1))
+	if len(sender.messages) != 1 {
+		t.Error("Duplicate should be dropped, messages:", len(sender.messages))
+	}
+	if len(m.incidents["0xa"].blocks) != 1 {
+		t.Error("Duplicate should not be added to incident")
+	}
+}
+
+func TestAlertManagerGroupAndResolve(t *testing.T) {
+	config := DefaultAlertManagerConfig()
+	config.ResolveAfterBlocks = 2
+	m, sender, clock := newTestAlertManager(config)
+
+	// First alert is sent, following ones are grouped until the group interval has passed
+	m.Alert(alertMsg("0xa", 1))
+	m.Alert(alertMsg("0xa", 2))
+	m.Alert(alertMsg("0xa", 3))
+	if len(sender.messages) != 1 {
+		t.Fatal("Expected 1 message, got", len(sender.messages))
+	}
+
+	clock.t = clock.t.Add(config.GroupInterval)
+	m.Flush()
+	if len(sender.messages) != 2 || !strings.Contains(sender.messages[1].Title, "2 more blocks") {
+		t.Fatal("Expected grouped message, got", sender.messages)
+	}
+
+	// Clean blocks resolve the incident
+	m.Alert(alertMsg("0xa", 4))
+	m.Clean("0xa", 5)
+	if m.NumOpenIncidents() != 1 {
+		t.Error("Incident should still be open")
+	}
+	m.Clean("0xa", 6)
+	if m.NumOpenIncidents() != 0 {
+		t.Error("Incident should be resolved")
+	}
+
+	// Pending alert of block 4 and the resolution
+	last := sender.messages[len(sender.messages)-1]
+	if len(sender.messages) != 4 || !strings.HasPrefix(last.Title, "Resolved") || last.Severity != SeverityCritical {
+		t.Error("Expected pending and resolution message, got", sender.messages)
+	}
+}
+
+func TestAlertManagerRateLimit(t *testing.T) {
+	config := DefaultAlertManagerConfig()
+	config.GlobalRateLimit = 2
+	m, sender, clock := newTestAlertManager(config)
+
+	m.Alert(alertMsg("0xa", 1))
+	m.Alert(alertMsg("0xb", 2))
+	m.Alert(alertMsg("0xc", 3)) // rate limited, kept as pending
+	if len(sender.messages) != 2 {
+		t.Fatal("Expected 2 messages, got", len(sender.messages))
+	}
+
+	clock.t = clock.t.Add(config.RateInterval)
+	m.Flush()
+	if len(sender.messages) != 3 || sender.messages[2].Block != 3 {
+		t.Error("Expected rate limited alert after interval, got", sender.messages)
+	}
+}
+
+func TestAlertManagerRetract(t *testing.T) {
+	m, sender, _ := newTestAlertManager(DefaultAlertManagerConfig())
+
+	// Pending alert is dropped without a message
+	m.Alert(alertMsg("0xa", 1))
+	pending := alertMsg("0xa", 2)
+	pending.BlockHash = "0x2"
+	m.Alert(pending)
+	m.Retract(pending)
+	if len(sender.messages) != 1 || len(m.incidents["0xa"].pending) != 0 {
+		t.Fatal("Pending alert should be dropped, messages:", len(sender.messages))
+	}
+
+	// Sent alert is retracted once
+	m.Retract(alertMsg("0xa", 1))
+	m.Retract(alertMsg("0xa", 1))
+	if len(sender.messages) != 2 {
+		t.Error("Expected 1 retraction, messages:", len(sender.messages))
+	}
+}
+
+func TestAlertManagerExpireSentTimes(t *testing.T) {
+	m, _, clock := newTestAlertManager(DefaultAlertManagerConfig())
+	m.Alert(alertMsg("0xa", 1))
+	if len(m.sentTimes) != 2 {
+		t.Fatal("Expected send times of the miner and global, got", m.sentTimes)
+	}
+
+	clock.t = clock.t.Add(m.Config.DedupWindow + time.Second)
+	m.Alert(alertMsg("0xb", 2))
+	if _, found := m.sentTimes["0xa"]; found || len(m.sentTimes[""]) != 1 {
+		t.Error("Old send times should be removed, got", m.sentTimes)
+	}
+}
+
+// senderFunc calls the function for each message
+type senderFunc func(msg Message) error
+
+func (f senderFunc) Send(msg Message) error {
+	return f(msg)
+}
+
+func TestAlertManagerSendsWithoutLock(t *testing.T) {
+	var m *AlertManager
+	numOpenIncidents := -1
+	m = NewAlertManager(senderFunc(func(msg Message) error {
+		numOpenIncidents = m.NumOpenIncidents() // deadlocks if Send is called with the lock held
+		return nil
+	}), DefaultAlertManagerConfig())
+
+	m.Alert(alertMsg("0xa", 1))
+	if numOpenIncidents != 1 {
+		t.Error("Expected 1 open incident while sending, got", numOpenIncidents)
+	}
+}
@@ -0,0 +1,138 @@
+// Package notify delivers alerts to Discord, Slack, Telegram, generic JSON webhooks and email.
+package notify
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityCritical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityCritical:
+		return "critical"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+func ParseSeverity(s string) (Severity, error) {
+	switch strings.ToLower(s) {
+	case "", "info":
+		return SeverityInfo, nil
+	case "warning":
+		return SeverityWarning, nil
+	case "critical":
+		return SeverityCritical, nil
+	}
+	return SeverityInfo, fmt.Errorf("invalid severity: %s", s)
+}
+
+// TypeSummary is the finding type of daily / weekly summary messages
+const TypeSummary = "summary"
+
+// Message is a single alert. Text may contain Discord-flavoured markdown (links as [text](<url>) and ``` code blocks),
+// which notifiers without markdown support convert to plain text.
+type Message struct {
+	Title        string
+	Url          string // optional link for the title
+	Text         string
+	Fields       []Field // optional structured details (eg. one per bundle)
+	Severity     Severity
+	FindingTypes []string // blockcheck finding types (or TypeSummary), used for routing
+
+	// Optional details about the block, used by notifiers that support structured data
+	Block     int64
+	BlockHash string
+	Miner     string
+	MinerName string
+}
+
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Notifier delivers messages to one destination
+type Notifier interface {
+	Name() string
+	Send(msg Message) error
+}
+
+var markdownLinkRegex = regexp.MustCompile(`\[([^\]]*)\]\(<?([^)>]*)>?\)`)
+
+// plainText converts markdown links to "text (url)" and removes code block markers
+func plainText(s string) string {
+	s = markdownLinkRegex.ReplaceAllString(s, "$1 ($2)")
+	return strings.ReplaceAll(s, "```", "\n")
+}
+
+// fullText returns title, text and fields of the message as one string
+func fullText(msg Message) string {
+	parts := make([]string, 0, 2+len(msg.Fields))
+	if msg.Title != "" {
+		parts = append(parts, msg.Title)
+	}
+	if msg.Text != "" {
+		parts = append(parts, strings.TrimRight(msg.Text, "\n"))
+	}
+	for _, field := range msg.Fields {
+		parts = append(parts, field.Name+": "+field.Value)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// truncate shortens s to at most maxLen characters (not bytes, to avoid breaking UTF-8)
+func truncate(s string, maxLen int) string {
+	runes := []rune(s)
+	if len(runes) <= maxLen {
+		return s
+	}
+	return string(runes[:maxLen-1]) + "…"
+}
+
+// splitLines splits s into chunks of at most maxLen characters, at line boundaries where possible
+func splitLines(s string, maxLen int) []string {
+	chunks := make([]string, 0)
+	current := ""
+	currentLen := 0
+	for _, line := range strings.SplitAfter(s, "\n") {
+		lineLen := utf8.RuneCountInString(line)
+		if currentLen+lineLen <= maxLen {
+			current += line
+			currentLen += lineLen
+			continue
+		}
+
+		if currentLen > 0 {
+			chunks = append(chunks, current)
+			current, currentLen = "", 0
+		}
+
+		// Line is too long for a single chunk: split at character boundaries
+		runes := []rune(line)
+		for len(runes) > maxLen {
+			chunks = append(chunks, string(runes[:maxLen]))
+			runes = runes[maxLen:]
+		}
+		current = string(runes)
+		currentLen = len(runes)
+	}
+
+	if currentLen > 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
@@ -0,0 +1,127 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testNotifier struct {
+	messages []Message
+	err      error
+}
+
+func (n *testNotifier) Name() string {
+	return "test"
+}
+
+func (n *testNotifier) Send(msg Message) error {
+	n.messages = append(n.messages, msg)
+	return n.err
+}
+
+func TestRouteMatches(t *testing.T) {
+	route := Route{MinSeverity: SeverityWarning, FindingTypes: []string{"failed_flashbots_tx"}}
+
+	if route.Matches(Message{Severity: SeverityInfo, FindingTypes: []string{"failed_flashbots_tx"}}) {
+		t.Error("Should not match lower severity")
+	}
+	if route.Matches(Message{Severity: SeverityCritical, FindingTypes: []string{"bundle_0_fee"}}) {
+		t.Error("Should not match other finding type")
+	}
+	if !route.Matches(Message{Severity: SeverityCritical, FindingTypes: []string{"bundle_0_fee", "failed_flashbots_tx"}}) {
+		t.Error("Should match")
+	}
+
+	route = Route{MinSeverity: SeverityInfo}
+	if !route.Matches(Message{Severity: SeverityInfo, FindingTypes: []string{TypeSummary}}) {
+		t.Error("Route without finding types should match all")
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	n1 := &testNotifier{}
+	n2 := &testNotifier{err: errors.New("xxx")}
+	numErrors := 0
+
+	d := NewDispatcher(&Route{Notifier: n1, MinSeverity: SeverityInfo}, &Route{Notifier: n2, MinSeverity: SeverityCritical})
+	d.OnError = func(notifier string, err error) { numErrors += 1 }
+
+	if err := d.Send(Message{Severity: SeverityWarning}); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if err := d.Send(Message{Severity: SeverityCritical}); err == nil {
+		t.Error("Expected error")
+	}
+
+	if len(n1.messages) != 2 || len(n2.messages) != 1 || numErrors != 1 {
+		t.Error("Wrong number of messages or errors:", len(n1.messages), len(n2.messages), numErrors)
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	s := plainText("Block [123](<https://etherscan.io/block/123>) ```x```")
+	if s != "Block 123 (https://etherscan.io/block/123) \nx\n" {
+		t.Error("Unexpected plainText result:", s)
+	}
+}
+
+func TestSlackAndWebhookNotifier(t *testing.T) {
+	var body map[string]interface{}
+	var authHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer server.Close()
+
+	err := NewSlackNotifier("slack", server.URL).Send(Message{Text: "see [tx](<https://etherscan.io/tx/0x1>)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body["text"] != "see <https://etherscan.io/tx/0x1|tx>" {
+		t.Error("Unexpected slack text:", body["text"])
+	}
+
+	webhook := NewWebhookNotifier("webhook", server.URL)
+	webhook.Headers = map[string]string{"Authorization": "Bearer xyz"}
+	err = webhook.Send(Message{Title: "title", Severity: SeverityCritical, Block: 123})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body["severity"] != "critical" || body["block"] != float64(123) || authHeader != "Bearer xyz" {
+		t.Error("Unexpected webhook payload:", body, authHeader)
+	}
+}
+
+func TestPostJsonStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	if err := NewDiscordNotifier("discord", server.URL).Send(Message{Text: "x"}); err == nil {
+		t.Error("Expected error on status 400")
+	}
+}
+
+func TestNewDispatcherFromConfig(t *testing.T) {
+	config := Config{Notifiers: []NotifierConfig{
+		{Type: "discord", Url: "http://localhost", MinSeverity: "critical"},
+		{Type: "telegram", BotToken: "x", ChatId: "y", FindingTypes: []string{"failed_0gas_tx"}},
+	}}
+	d, err := NewDispatcherFromConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Routes) != 2 || d.Routes[0].MinSeverity != SeverityCritical || d.Routes[1].Notifier.Name() != "telegram" {
+		t.Error("Unexpected routes:", d.Routes)
+	}
+
+	_, err = NewDispatcherFromConfig(Config{Notifiers: []NotifierConfig{{Type: "xxx"}}})
+	if err == nil {
+		t.Error("Expected error for unknown notifier type")
+	}
+}
@@ -0,0 +1,31 @@
+// Slack incoming webhook notifier
+// https://api.slack.com/messaging/webhooks
+package notify
+
+type SlackWebhookPayload struct {
+	Text string `json:"text"`
+}
+
+type SlackNotifier struct {
+	name       string
+	WebhookUrl string
+}
+
+func NewSlackNotifier(name string, webhookUrl string) *SlackNotifier {
+	return &SlackNotifier{name: name, WebhookUrl: webhookUrl}
+}
+
+func (n *SlackNotifier) Name() string {
+	return n.name
+}
+
+func (n *SlackNotifier) Send(msg Message) error {
+	text := fullText(msg)
+	if text == "" {
+		return nil
+	}
+
+	// Slack mrkdwn links are <url|text>
+	text = markdownLinkRegex.ReplaceAllString(text, "<$2|$1>")
+	return postJson(n.WebhookUrl, SlackWebhookPayload{Text: text}, nil)
+}
@@ -0,0 +1,51 @@
+// Telegram bot API notifier
+// https://core.telegram.org/bots/api#sendmessage
+package notify
+
+import "fmt"
+
+// Telegram messages can have at most 4096 characters
+const telegramMaxMessageLength = 4096
+
+type TelegramSendMessagePayload struct {
+	ChatId                string `json:"chat_id"`
+	Text                  string `json:"text"`
+	DisableWebPagePreview bool   `json:"disable_web_page_preview"`
+}
+
+type TelegramNotifier struct {
+	name     string
+	BotToken string
+	ChatId   string
+	ApiUrl   string // defaults to https://api.telegram.org
+}
+
+func NewTelegramNotifier(name string, botToken string, chatId string) *TelegramNotifier {
+	return &TelegramNotifier{
+		name:     name,
+		BotToken: botToken,
+		ChatId:   chatId,
+		ApiUrl:   "https://api.telegram.org",
+	}
+}
+
+func (n *TelegramNotifier) Name() string {
+	return n.name
+}
+
+func (n *TelegramNotifier) Send(msg Message) error {
+	text := plainText(fullText(msg))
+	if text == "" {
+		return nil
+	}
+
+	text = truncate(text, telegramMaxMessageLength)
+
+	url := fmt.Sprintf("%s/bot%s/sendMessage", n.ApiUrl, n.BotToken)
+	payload := TelegramSendMessagePayload{
+		ChatId:                n.ChatId,
+		Text:                  text,
+		DisableWebPagePreview: true,
+	}
+	return postJson(url, payload, nil)
+}
@@ -0,0 +1,50 @@
+// Generic JSON webhook notifier
+package notify
+
+import "time"
+
+// WebhookPayload is the JSON body posted to generic webhooks
+type WebhookPayload struct {
+	Title        string    `json:"title"`
+	Url          string    `json:"url,omitempty"`
+	Text         string    `json:"text"`
+	Fields       []Field   `json:"fields,omitempty"`
+	Severity     string    `json:"severity"`
+	FindingTypes []string  `json:"finding_types"`
+	Block        int64     `json:"block,omitempty"`
+	BlockHash    string    `json:"block_hash,omitempty"`
+	Miner        string    `json:"miner,omitempty"`
+	MinerName    string    `json:"miner_name,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+type WebhookNotifier struct {
+	name    string
+	Url     string
+	Headers map[string]string
+}
+
+func NewWebhookNotifier(name string, url string) *WebhookNotifier {
+	return &WebhookNotifier{name: name, Url: url}
+}
+
+func (n *WebhookNotifier) Name() string {
+	return n.name
+}
+
+func (n *WebhookNotifier) Send(msg Message) error {
+	payload := WebhookPayload{
+		Title:        msg.Title,
+		Url:          msg.Url,
+		Text:         plainText(msg.Text),
+		Fields:       msg.Fields,
+		Severity:     msg.Severity.String(),
+		FindingTypes: msg.FindingTypes,
+		Block:        msg.Block,
+		BlockHash:    msg.BlockHash,
+		Miner:        msg.Miner,
+		MinerName:    msg.MinerName,
+		Time:         time.Now().UTC(),
+	}
+	return postJson(n.Url, payload, n.Headers)
+}
@@ -0,0 +1,229 @@
+// Package resultsdb persists block check results (blocks, bundles, failed transactions and findings) in a SQLite
+// database, for ad-hoc SQL analysis of miner behaviour. The schema is documented in README.md.
+package resultsdb
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/metachris/flashbots/blockcheck"
+)
+
+// migrations are applied in order, the number of applied migrations is stored as SQLite user_version. Only ever append
+// new migrations, never change existing ones.
+var migrations = []string{
+	// 1: initial schema
+	`CREATE TABLE blocks (
+		hash                TEXT PRIMARY KEY,
+		number              INTEGER NOT NULL,
+		timestamp           INTEGER NOT NULL,
+		miner               TEXT NOT NULL,
+		miner_name          TEXT NOT NULL,
+		num_tx              INTEGER NOT NULL,
+		gas_used            INTEGER NOT NULL,
+		is_flashbots_block  INTEGER NOT NULL,
+		num_bundles         INTEGER NOT NULL,
+		has_serious_errors  INTEGER NOT NULL,
+		has_less_serious_errors INTEGER NOT NULL,
+		errors              TEXT NOT NULL,
+		orphaned            INTEGER NOT NULL DEFAULT 0,
+		checked_at          INTEGER NOT NULL
+	);
+	CREATE INDEX blocks_number ON blocks (number);
+	CREATE INDEX blocks_miner ON blocks (miner);
+
+	CREATE TABLE bundles (
+		block_hash                  TEXT NOT NULL REFERENCES blocks (hash) ON DELETE CASCADE,
+		bundle_index                INTEGER NOT NULL,
+		num_tx                      INTEGER NOT NULL,
+		total_miner_reward          TEXT NOT NULL,
+		total_coinbase_transfer     TEXT NOT NULL,
+		total_gas_used              INTEGER NOT NULL,
+		coinbase_div_gas_used       TEXT NOT NULL,
+		reward_div_gas_used         TEXT NOT NULL,
+		total_miner_reward_eth      REAL NOT NULL,
+		coinbase_div_gas_used_gwei  REAL NOT NULL,
+		reward_div_gas_used_gwei    REAL NOT NULL,
+		percent_price_diff          REAL NOT NULL,
+		is_out_of_order             INTEGER NOT NULL,
+		is_paying_less_than_lowest_tx INTEGER NOT NULL,
+		is_0_effective_gas_price    INTEGER NOT NULL,
+		is_negative_effective_gas_price INTEGER NOT NULL,
+		PRIMARY KEY (block_hash, bundle_index)
+	);
+
+	CREATE TABLE failed_tx (
+		hash          TEXT NOT NULL,
+		block_hash    TEXT NOT NULL REFERENCES blocks (hash) ON DELETE CASCADE,
+		block_number  INTEGER NOT NULL,
+		is_flashbots  INTEGER NOT NULL,
+		from_address  TEXT NOT NULL,
+		to_address    TEXT NOT NULL,
+		PRIMARY KEY (block_hash, hash)
+	);
+
+	CREATE TABLE findings (
+		block_hash  TEXT NOT NULL REFERENCES blocks (hash) ON DELETE CASCADE,
+		type        TEXT NOT NULL,
+		count       INTEGER NOT NULL,
+		PRIMARY KEY (block_hash, type)
+	);
+	CREATE INDEX findings_type ON findings (type);`,
+}
+
+type DB struct {
+	db *sql.DB
+}
+
+// Open opens (or creates) the database, and migrates it to the latest schema
+func Open(path string) (*DB, error) {
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=1&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1) // SQLite allows only one writer
+
+	res := &DB{db: db}
+	if err := res.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return res, nil
+}
+
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// SchemaVersion returns the number of applied migrations
+func (d *DB) SchemaVersion() (version int, err error) {
+	err = d.db.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+func (d *DB) migrate() error {
+	version, err := d.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this program (%d)", version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := d.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// weiToFloat converts wei to units of 10^decimals wei (eg. 18 for ETH, 9 for gwei)
+func weiToFloat(wei *big.Int, decimals int) float64 {
+	f, _ := new(big.Float).Quo(new(big.Float).SetInt(wei), big.NewFloat(math.Pow10(decimals))).Float64()
+	return f
+}
+
+// SaveCheck stores the check result of a block, replacing an earlier result of the same block
+func (d *DB) SaveCheck(check *blockcheck.BlockCheck) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	block := check.EthBlock
+	hash := block.Hash().Hex()
+
+	errorLines := make([]string, len(check.Errors))
+	for i, err := range check.Errors {
+		errorLines[i] = strings.TrimSpace(err)
+	}
+
+	// Deleting the block also deletes its rows in the other tables
+	if _, err = tx.Exec(`DELETE FROM blocks WHERE hash = ?`, hash); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO blocks (hash, number, timestamp, miner, miner_name, num_tx, gas_used,
+		is_flashbots_block, num_bundles, has_serious_errors, has_less_serious_errors, errors, checked_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		hash, check.Number, block.Time(), check.Miner, check.MinerName, len(block.Transactions()), block.GasUsed(),
+		boolToInt(len(check.FlashbotsTransactions) > 0), len(check.Bundles), boolToInt(check.HasSeriousErrors()),
+		boolToInt(check.HasLessSeriousErrors()), strings.Join(errorLines, "\n"), time.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	for _, bundle := range check.Bundles {
+		percentPriceDiff, _ := bundle.PercentPriceDiff.Float64()
+		_, err = tx.Exec(`INSERT INTO bundles (block_hash, bundle_index, num_tx, total_miner_reward, total_coinbase_transfer,
+			total_gas_used, coinbase_div_gas_used, reward_div_gas_used, total_miner_reward_eth, coinbase_div_gas_used_gwei,
+			reward_div_gas_used_gwei, percent_price_diff, is_out_of_order, is_paying_less_than_lowest_tx,
+			is_0_effective_gas_price, is_negative_effective_gas_price)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			hash, bundle.Index, len(bundle.Transactions), bundle.TotalMinerReward.String(), bundle.TotalCoinbaseTransfer.String(),
+			bundle.TotalGasUsed.Int64(), bundle.CoinbaseDivGasUsed.String(), bundle.RewardDivGasUsed.String(),
+			weiToFloat(bundle.TotalMinerReward, 18), weiToFloat(bundle.CoinbaseDivGasUsed, 9), weiToFloat(bundle.RewardDivGasUsed, 9),
+			percentPriceDiff, boolToInt(bundle.IsOutOfOrder), boolToInt(bundle.IsPayingLessThanLowestTx),
+			boolToInt(bundle.Is0EffectiveGasPrice), boolToInt(bundle.IsNegativeEffectiveGasPrice))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, failedTx := range check.FailedTx {
+		_, err = tx.Exec(`INSERT INTO failed_tx (hash, block_hash, block_number, is_flashbots, from_address, to_address)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			failedTx.Hash, hash, check.Number, boolToInt(failedTx.IsFlashbots), failedTx.From, failedTx.To)
+		if err != nil {
+			return err
+		}
+	}
+
+	for findingType, count := range check.ErrorCounter.ByType() {
+		if count == 0 {
+			continue
+		}
+		if _, err = tx.Exec(`INSERT INTO findings (block_hash, type, count) VALUES (?, ?, ?)`, hash, findingType, count); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// MarkOrphaned flags a block that was reorged out (its rows are kept)
+func (d *DB) MarkOrphaned(blockHash string) error {
+	_, err := d.db.Exec(`UPDATE blocks SET orphaned = 1 WHERE hash = ?`, blockHash)
+	return err
+}
+
+// Query runs a read query, for tools on top of the database
+func (d *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return d.db.Query(query, args...)
+}
@@ -0,0 +1,90 @@
+package resultsdb
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/flashbots/api"
+	"github.com/metachris/flashbots/blockcheck"
+	"github.com/metachris/flashbots/common"
+)
+
+func newTestCheck() *blockcheck.BlockCheck {
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(100), Time: 1630000000, GasUsed: 1000000})
+	check := &blockcheck.BlockCheck{
+		Number:                100,
+		Miner:                 "0x01",
+		MinerName:             "miner",
+		EthBlock:              block,
+		FlashbotsTransactions: []api.FlashbotsTransaction{{Hash: "0xa"}, {Hash: "0xb"}},
+		FailedTx:              map[string]*blockcheck.FailedTx{"0xb": {Hash: "0xb", IsFlashbots: true, From: "0x02", To: "0x03"}},
+		Errors:                []string{"bundle 1 pays 50.00% more than previous bundle\n", "failed Flashbots tx 0xb"},
+		ErrorCounter:          blockcheck.ErrorCounts{BundlePaysMoreThanPrevBundle: 1, FailedFlashbotsTx: 1},
+	}
+
+	for i := int64(0); i < 2; i++ {
+		bundle := common.NewBundle()
+		bundle.Index = i
+		bundle.Transactions = check.FlashbotsTransactions[i : i+1]
+		bundle.TotalMinerReward = big.NewInt(21000 * 40e9 * (i + 1))
+		bundle.TotalGasUsed = big.NewInt(21000)
+		bundle.RewardDivGasUsed = big.NewInt(40e9 * (i + 1))
+		bundle.IsOutOfOrder = i == 1
+		check.AddBundle(bundle)
+	}
+	check.Bundles[1].PercentPriceDiff = big.NewFloat(100)
+	return check
+}
+
+func TestSaveCheck(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "results.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if version, err := db.SchemaVersion(); err != nil || version != len(migrations) {
+		t.Fatalf("expected schema version %d, got %d (%v)", len(migrations), version, err)
+	}
+
+	// Saving twice replaces the first result
+	check := newTestCheck()
+	for i := 0; i < 2; i++ {
+		if err := db.SaveCheck(check); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var numBundles, numOutOfOrder int
+	var rewardGwei float64
+	err = db.db.QueryRow(`SELECT COUNT(*), SUM(is_out_of_order), MAX(reward_div_gas_used_gwei) FROM bundles`).Scan(&numBundles, &numOutOfOrder, &rewardGwei)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numBundles != 2 || numOutOfOrder != 1 || rewardGwei != 80 {
+		t.Errorf("unexpected bundles: %d bundles, %d out of order, max %f gwei", numBundles, numOutOfOrder, rewardGwei)
+	}
+
+	var miner, errors string
+	var numFailedTx, numFindings int
+	err = db.db.QueryRow(`SELECT b.miner_name, b.errors, (SELECT COUNT(*) FROM failed_tx), (SELECT SUM(count) FROM findings) FROM blocks b WHERE b.is_flashbots_block = 1 AND b.has_serious_errors = 1`).Scan(&miner, &errors, &numFailedTx, &numFindings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if miner != "miner" || numFailedTx != 1 || numFindings != 2 {
+		t.Errorf("unexpected block: miner %s, %d failed tx, %d findings", miner, numFailedTx, numFindings)
+	}
+	if errors != "bundle 1 pays 50.00% more than previous bundle\nfailed Flashbots tx 0xb" {
+		t.Errorf("errors should be one per line: %q", errors)
+	}
+
+	if err := db.MarkOrphaned(check.EthBlock.Hash().Hex()); err != nil {
+		t.Fatal(err)
+	}
+	var orphaned bool
+	if err := db.db.QueryRow(`SELECT orphaned FROM blocks`).Scan(&orphaned); err != nil || !orphaned {
+		t.Errorf("block should be orphaned (%v)", err)
+	}
+}
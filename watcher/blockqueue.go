@@ -0,0 +1,195 @@
+package watcher
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+// How often the queue checks for expired blocks and retries failed blocks (if not woken up before)
+const queueCheckInterval = 5 * time.Second
+
+type QueuedBlock struct {
+	Block    *blockswithtx.BlockWithTxReceipts
+	Added    time.Time
+	Attempts int
+}
+
+func (b *QueuedBlock) Number() int64 {
+	return b.Block.Block.Number().Int64()
+}
+
+// BlockQueue holds blocks until they are ready to be processed (eg. until the mev-blocks API has caught up), and
+// processes ready blocks in height order on its own goroutine (Run). A block whose processing fails stays in the
+// queue and is retried later, without holding up the other ready blocks. Blocks waiting longer than MaxWait are dropped.
+type BlockQueue struct {
+	MaxWait time.Duration // 0 = no limit
+	Process func(block *blockswithtx.BlockWithTxReceipts) error
+	OnError func(block *QueuedBlock, err error) // optional, called when Process fails
+	OnDrop  func(block *QueuedBlock)            // optional, called when a block is dropped after MaxWait
+
+	lock        sync.Mutex
+	blocks      map[common.Hash]*QueuedBlock
+	readyHeight int64
+	wake        chan struct{}
+	now         func() time.Time
+}
+
+func NewBlockQueue(maxWait time.Duration, process func(block *blockswithtx.BlockWithTxReceipts) error) *BlockQueue {
+	return &BlockQueue{
+		MaxWait: maxWait,
+		Process: process,
+		blocks:  make(map[common.Hash]*QueuedBlock),
+		wake:    make(chan struct{}, 1),
+		now:     time.Now,
+	}
+}
+
+// Add queues a block (a block that is already queued is not added again)
+func (q *BlockQueue) Add(block *blockswithtx.BlockWithTxReceipts) {
+	q.lock.Lock()
+	hash := block.Block.Hash()
+	if _, found := q.blocks[hash]; !found {
+		q.blocks[hash] = &QueuedBlock{Block: block, Added: q.now()}
+	}
+	q.lock.Unlock()
+	q.notify()
+}
+
+// Remove removes a block from the queue (eg. after it was reorged out), returns false if it was not queued
+func (q *BlockQueue) Remove(hash common.Hash) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	_, found := q.blocks[hash]
+	delete(q.blocks, hash)
+	return found
+}
+
+// SetReadyHeight sets the highest block number that can be processed
+func (q *BlockQueue) SetReadyHeight(height int64) {
+	q.lock.Lock()
+	q.readyHeight = height
+	q.lock.Unlock()
+	q.notify()
+}
+
+// Len returns the number of queued blocks
+func (q *BlockQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.blocks)
+}
+
+func (q *BlockQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default: // already woken up
+	}
+}
+
+// Refs returns the queued blocks, sorted by height
+func (q *BlockQueue) Refs() []BlockRef {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	refs := make([]BlockRef, 0, len(q.blocks))
+	for hash, block := range q.blocks {
+		refs = append(refs, BlockRef{Number: block.Number(), Hash: hash})
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Number < refs[j].Number })
+	return refs
+}
+
+// Run processes ready blocks until the context is cancelled. A block that is being processed when the context is
+// cancelled is finished before Run returns.
+func (q *BlockQueue) Run(ctx context.Context) {
+	ticker := time.NewTicker(queueCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		q.ProcessReady(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessReady drops expired blocks and processes all ready blocks in height order, until the context is cancelled.
+// Returns the number of successfully processed blocks.
+func (q *BlockQueue) ProcessReady(ctx context.Context) int {
+	ready, dropped := q.takeReady()
+	if q.OnDrop != nil {
+		for _, block := range dropped {
+			q.OnDrop(block)
+		}
+	}
+
+	numProcessed := 0
+	for _, block := range ready {
+		if ctx.Err() != nil {
+			break
+		}
+
+		// Skip blocks that were removed in the meantime
+		q.lock.Lock()
+		_, stillQueued := q.blocks[block.Block.Block.Hash()]
+		q.lock.Unlock()
+		if !stillQueued {
+			continue
+		}
+
+		err := q.Process(block.Block)
+
+		q.lock.Lock()
+		block.Attempts += 1
+		if err == nil {
+			delete(q.blocks, block.Block.Block.Hash())
+		}
+		q.lock.Unlock()
+
+		if err != nil {
+			if q.OnError != nil {
+				q.OnError(block, err)
+			}
+			continue
+		}
+		numProcessed += 1
+	}
+	return numProcessed
+}
+
+// takeReady removes and returns expired blocks, and returns the ready blocks sorted by height (and time added)
+func (q *BlockQueue) takeReady() (ready []*QueuedBlock, dropped []*QueuedBlock) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	now := q.now()
+	for hash, block := range q.blocks {
+		if q.MaxWait > 0 && now.Sub(block.Added) > q.MaxWait {
+			delete(q.blocks, hash)
+			dropped = append(dropped, block)
+			continue
+		}
+
+		if block.Number() <= q.readyHeight {
+			ready = append(ready, block)
+		}
+	}
+
+	sort.Slice(ready, func(i, j int) bool {
+		if ready[i].Number() != ready[j].Number() {
+			return ready[i].Number() < ready[j].Number()
+		}
+		return ready[i].Added.Before(ready[j].Added)
+	})
+	return ready, dropped
+}
@@ -0,0 +1,88 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+func newTestBlock(number int64) *blockswithtx.BlockWithTxReceipts {
+	header := &types.Header{Number: big.NewInt(number), Difficulty: big.NewInt(1)}
+	return &blockswithtx.BlockWithTxReceipts{Block: types.NewBlockWithHeader(header)}
+}
+
+func TestBlockQueueOrder(t *testing.T) {
+	processed := make([]int64, 0)
+	failNext := map[int64]bool{12: true}
+	q := NewBlockQueue(0, func(block *blockswithtx.BlockWithTxReceipts) error {
+		number := block.Block.Number().Int64()
+		if failNext[number] {
+			failNext[number] = false
+			return errors.New("api error")
+		}
+		processed = append(processed, number)
+		return nil
+	})
+
+	for _, number := range []int64{14, 11, 13, 12, 15} {
+		q.Add(newTestBlock(number))
+	}
+
+	q.SetReadyHeight(14)
+	if n := q.ProcessReady(context.Background()); n != 3 {
+		t.Errorf("processed %d blocks, expected 3", n)
+	}
+
+	// 12 failed and must not hold up 13 and 14
+	expected := []int64{11, 13, 14}
+	if len(processed) != len(expected) {
+		t.Fatalf("processed %v, expected %v", processed, expected)
+	}
+	for i := range expected {
+		if processed[i] != expected[i] {
+			t.Fatalf("processed %v, expected %v", processed, expected)
+		}
+	}
+
+	if q.Len() != 2 {
+		t.Errorf("queue len %d, expected 2", q.Len())
+	}
+
+	// retry 12, then 15 after the ready height advanced
+	q.SetReadyHeight(15)
+	q.ProcessReady(context.Background())
+	if q.Len() != 0 || processed[3] != 12 || processed[4] != 15 {
+		t.Errorf("unexpected processing after retry: %v", processed)
+	}
+}
+
+func TestBlockQueueMaxWait(t *testing.T) {
+	now := time.Now()
+	q := NewBlockQueue(time.Minute, func(block *blockswithtx.BlockWithTxReceipts) error { return nil })
+	q.now = func() time.Time { return now }
+
+	dropped := make([]int64, 0)
+	q.OnDrop = func(block *QueuedBlock) { dropped = append(dropped, block.Number()) }
+
+	q.Add(newTestBlock(1))
+	now = now.Add(30 * time.Second)
+	q.Add(newTestBlock(2))
+	now = now.Add(45 * time.Second)
+
+	q.ProcessReady(context.Background()) // nothing ready, but block 1 waited too long
+	if len(dropped) != 1 || dropped[0] != 1 {
+		t.Errorf("dropped %v, expected [1]", dropped)
+	}
+	if q.Len() != 1 {
+		t.Errorf("queue len %d, expected 1", q.Len())
+	}
+
+	if !q.Remove(newTestBlock(2).Block.Hash()) || q.Len() != 0 {
+		t.Error("remove failed")
+	}
+}
@@ -0,0 +1,176 @@
+// Package watcher contains the building blocks for processing new blocks as they arrive (reorg detection, ordered
+// processing queue, ...)
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// HeaderReader is implemented by ethclient.Client
+type HeaderReader interface {
+	HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+type BlockRef struct {
+	Number int64       `json:"number"`
+	Hash   common.Hash `json:"hash"`
+}
+
+func (b BlockRef) String() string {
+	return fmt.Sprintf("%d (%s)", b.Number, b.Hash.Hex())
+}
+
+// ChainUpdate is the result of adding a new head to the ChainTracker
+type ChainUpdate struct {
+	NewBlocks      []BlockRef // blocks that became canonical, in height order (includes the new head)
+	ReplacedBlocks []BlockRef // previously canonical blocks that were reorged out
+
+	// Missed heights that were not filled in, because the gap was larger than MaxGap (0 if none)
+	SkippedFrom int64
+	SkippedTo   int64
+}
+
+func (u ChainUpdate) IsReorg() bool {
+	return len(u.ReplacedBlocks) > 0
+}
+
+// ChainTracker keeps the hashes of the most recent canonical blocks. When a new head does not extend the tracked
+// chain, the new branch is followed back via parent hashes to the common ancestor, and the replaced blocks are reported.
+// When a new head skips heights, the missed blocks are filled in the same way (up to MaxGap blocks, or all blocks
+// since a restored head).
+type ChainTracker struct {
+	client HeaderReader
+	depth  int64 // number of heights kept (and max reorg depth that can be detected)
+	MaxGap int64 // max number of missed blocks (eg. during a disconnect) that are filled in when a new head skips heights
+
+	updateLock sync.Mutex   // serializes AddHeader and Restore, which can read the state without lock
+	lock       sync.RWMutex // for writing the state, not held during requests
+	hashes     map[int64]common.Hash
+	head       int64
+	restored   bool // the head was restored, fill in all blocks since then regardless of MaxGap
+}
+
+func NewChainTracker(client HeaderReader, depth int64) *ChainTracker {
+	return &ChainTracker{
+		client: client,
+		depth:  depth,
+		MaxGap: 1000,
+		hashes: make(map[int64]common.Hash),
+	}
+}
+
+// Head returns the height of the latest tracked block (0 if nothing tracked yet)
+func (t *ChainTracker) Head() int64 {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.head
+}
+
+// HeadBlock returns the latest tracked block (zero value if nothing tracked yet)
+func (t *ChainTracker) HeadBlock() BlockRef {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return BlockRef{Number: t.head, Hash: t.hashes[t.head]}
+}
+
+// Restore sets the head of an empty tracker (eg. from a checkpoint), so that the next header fills in all blocks
+// since then (without the MaxGap limit)
+func (t *ChainTracker) Restore(head BlockRef) {
+	t.updateLock.Lock()
+	defer t.updateLock.Unlock()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.hashes = map[int64]common.Hash{head.Number: head.Hash}
+	t.head = head.Number
+	t.restored = true
+}
+
+// IsCanonical returns true if the block is part of the tracked canonical chain. Blocks older than the tracked window
+// are assumed canonical.
+func (t *ChainTracker) IsCanonical(number int64, hash common.Hash) bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	knownHash, found := t.hashes[number]
+	return !found || knownHash == hash
+}
+
+// AddHeader adds a new chain head. Missing parents are requested without holding the lock, so Head and IsCanonical
+// are not blocked by the requests.
+func (t *ChainTracker) AddHeader(ctx context.Context, header *types.Header) (update ChainUpdate, err error) {
+	t.updateLock.Lock()
+	defer t.updateLock.Unlock()
+
+	height := header.Number.Int64()
+	if knownHash, found := t.hashes[height]; found && knownHash == header.Hash() {
+		return update, nil // already known
+	}
+
+	// Walk back the new branch until it connects to the tracked chain (filling in skipped heights)
+	newBranch := []BlockRef{{Number: height, Hash: header.Hash()}}
+	current := header
+	for {
+		parentHeight := current.Number.Int64() - 1
+		knownHash, found := t.hashes[parentHeight]
+		if found && knownHash == current.ParentHash {
+			break
+		}
+
+		if !found {
+			isGap := t.head > 0 && parentHeight > t.head
+			if !isGap {
+				break
+			}
+			if !t.restored && height-parentHeight > t.MaxGap {
+				update.SkippedFrom, update.SkippedTo = t.head+1, parentHeight
+				break
+			}
+		} else if t.head-parentHeight >= t.depth {
+			return update, fmt.Errorf("reorg deeper than %d blocks at height %d", t.depth, height)
+		}
+
+		parentHash := current.ParentHash
+		current, err = t.client.HeaderByHash(ctx, parentHash)
+		if err != nil {
+			return update, fmt.Errorf("reorg detection: error getting parent %s: %w", parentHash.Hex(), err)
+		}
+		newBranch = append([]BlockRef{{Number: parentHeight, Hash: current.Hash()}}, newBranch...)
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// All tracked blocks from the start of the new branch which are not part of it were replaced
+	firstNewHeight := newBranch[0].Number
+	for h := firstNewHeight; h <= t.head; h++ {
+		if oldHash, found := t.hashes[h]; found {
+			if h > height || oldHash != newBranch[h-firstNewHeight].Hash {
+				update.ReplacedBlocks = append(update.ReplacedBlocks, BlockRef{Number: h, Hash: oldHash})
+			}
+			delete(t.hashes, h)
+		}
+	}
+
+	for _, block := range newBranch {
+		t.hashes[block.Number] = block.Hash
+	}
+	update.NewBlocks = newBranch
+	t.head = height
+	t.restored = false
+
+	// Forget blocks outside of the window
+	for h := range t.hashes {
+		if h <= height-t.depth {
+			delete(t.hashes, h)
+		}
+	}
+	return update, nil
+}
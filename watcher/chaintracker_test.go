@@ -0,0 +1,178 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// testChain serves headers by hash, like a node that knows all branches
+type testChain struct {
+	headers   map[common.Hash]*types.Header
+	onRequest func() // called on each request (optional)
+}
+
+func newTestChain() *testChain {
+	return &testChain{headers: make(map[common.Hash]*types.Header)}
+}
+
+// add creates a header on top of parent (nil for genesis). branch makes the hash unique per branch.
+func (c *testChain) add(parent *types.Header, branch byte) *types.Header {
+	header := &types.Header{Number: big.NewInt(1), Extra: []byte{branch}, Difficulty: big.NewInt(1)}
+	if parent != nil {
+		header.Number = new(big.Int).Add(parent.Number, common.Big1)
+		header.ParentHash = parent.Hash()
+	}
+	c.headers[header.Hash()] = header
+	return header
+}
+
+func (c *testChain) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	if c.onRequest != nil {
+		c.onRequest()
+	}
+	if header, found := c.headers[hash]; found {
+		return header, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (c *testChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestChainTrackerReorg(t *testing.T) {
+	chain := newTestChain()
+	tracker := NewChainTracker(chain, 10)
+
+	b1 := chain.add(nil, 0)
+	b2 := chain.add(b1, 0)
+	b3 := chain.add(b2, 0)
+	for _, header := range []*types.Header{b1, b2, b3} {
+		update, err := tracker.AddHeader(context.Background(), header)
+		if err != nil || update.IsReorg() || len(update.NewBlocks) != 1 {
+			t.Fatal("Unexpected update:", update, err)
+		}
+	}
+
+	// Alternative branch replaces blocks 2 and 3
+	b2x := chain.add(b1, 1)
+	b3x := chain.add(b2x, 1)
+	b4x := chain.add(b3x, 1)
+	update, err := tracker.AddHeader(context.Background(), b4x)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(update.ReplacedBlocks) != 2 || update.ReplacedBlocks[0].Hash != b2.Hash() || update.ReplacedBlocks[1].Hash != b3.Hash() {
+		t.Error("Unexpected replaced blocks:", update.ReplacedBlocks)
+	}
+	if len(update.NewBlocks) != 3 || update.NewBlocks[0].Hash != b2x.Hash() || update.NewBlocks[2].Hash != b4x.Hash() {
+		t.Error("Unexpected new blocks:", update.NewBlocks)
+	}
+
+	if tracker.IsCanonical(3, b3.Hash()) || !tracker.IsCanonical(3, b3x.Hash()) {
+		t.Error("Wrong canonical block at height 3")
+	}
+	if tracker.Head() != 4 {
+		t.Error("Wrong head:", tracker.Head())
+	}
+}
+
+func TestChainTrackerSibling(t *testing.T) {
+	chain := newTestChain()
+	tracker := NewChainTracker(chain, 10)
+
+	b1 := chain.add(nil, 0)
+	b2 := chain.add(b1, 0)
+	b2x := chain.add(b1, 1)
+	tracker.AddHeader(context.Background(), b1)
+	tracker.AddHeader(context.Background(), b2)
+
+	update, err := tracker.AddHeader(context.Background(), b2x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(update.ReplacedBlocks) != 1 || update.ReplacedBlocks[0].Hash != b2.Hash() {
+		t.Error("Unexpected replaced blocks:", update.ReplacedBlocks)
+	}
+
+	// Same header again is ignored
+	update, _ = tracker.AddHeader(context.Background(), b2x)
+	if len(update.NewBlocks) != 0 {
+		t.Error("Known header should be ignored:", update)
+	}
+}
+
+func TestChainTrackerGap(t *testing.T) {
+	chain := newTestChain()
+	tracker := NewChainTracker(chain, 10)
+
+	b1 := chain.add(nil, 0)
+	b2 := chain.add(b1, 0)
+	b3 := chain.add(b2, 0)
+	b4 := chain.add(b3, 0)
+	tracker.AddHeader(context.Background(), b1)
+
+	// Heads 2 and 3 were missed (eg. during a disconnect)
+	update, err := tracker.AddHeader(context.Background(), b4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update.IsReorg() || len(update.NewBlocks) != 3 || update.NewBlocks[0].Hash != b2.Hash() || update.NewBlocks[2].Hash != b4.Hash() {
+		t.Error("Unexpected update:", update)
+	}
+
+	// Gaps larger than MaxGap are only filled partially
+	tracker.MaxGap = 2
+	b5 := chain.add(b4, 0)
+	b6 := chain.add(b5, 0)
+	b7 := chain.add(b6, 0)
+	b8 := chain.add(b7, 0)
+	update, err = tracker.AddHeader(context.Background(), b8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(update.NewBlocks) != 3 || update.NewBlocks[0].Hash != b6.Hash() || update.SkippedFrom != 5 || update.SkippedTo != 5 {
+		t.Error("Unexpected update:", update)
+	}
+
+	// After a restore, the whole gap is filled in
+	tracker.Restore(BlockRef{Number: 4, Hash: b4.Hash()})
+	update, err = tracker.AddHeader(context.Background(), b8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(update.NewBlocks) != 4 || update.NewBlocks[0].Hash != b5.Hash() || update.SkippedTo != 0 {
+		t.Error("Unexpected update after restore:", update)
+	}
+}
+
+func TestChainTrackerReadsDuringRequests(t *testing.T) {
+	chain := newTestChain()
+	tracker := NewChainTracker(chain, 10)
+
+	b1 := chain.add(nil, 0)
+	b2 := chain.add(b1, 0)
+	b3 := chain.add(b2, 0)
+	tracker.AddHeader(context.Background(), b1)
+
+	// Reads while the missing parent is requested (deadlocks if the lock is held during requests)
+	numRequests := 0
+	chain.onRequest = func() {
+		numRequests += 1
+		if tracker.Head() != 1 || !tracker.IsCanonical(1, b1.Hash()) {
+			t.Error("Unexpected state during request")
+		}
+	}
+	if _, err := tracker.AddHeader(context.Background(), b3); err != nil {
+		t.Fatal(err)
+	}
+	if numRequests != 1 || tracker.Head() != 3 {
+		t.Errorf("Expected 1 request and head 3, got %d and %d", numRequests, tracker.Head())
+	}
+}
@@ -0,0 +1,127 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Backoff between resubscription attempts after a subscription error
+const (
+	resubscribeMinBackoff = time.Second
+	resubscribeMaxBackoff = time.Minute
+)
+
+// HeadSource delivers new chain heads. Run blocks until the context is cancelled, and handles connection errors itself.
+type HeadSource interface {
+	Run(ctx context.Context, heads chan<- *types.Header)
+}
+
+// HeadSubscriber is implemented by ethclient.Client
+type HeadSubscriber interface {
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+}
+
+// SubscriptionHeadSource receives new heads via a websocket (or IPC) subscription, and resubscribes with exponential
+// backoff if the subscription fails.
+type SubscriptionHeadSource struct {
+	client HeadSubscriber
+}
+
+func NewSubscriptionHeadSource(client HeadSubscriber) *SubscriptionHeadSource {
+	return &SubscriptionHeadSource{client: client}
+}
+
+func (s *SubscriptionHeadSource) Run(ctx context.Context, heads chan<- *types.Header) {
+	backoff := resubscribeMinBackoff
+	for {
+		sub, err := s.client.SubscribeNewHead(ctx, heads)
+		if err == nil {
+			backoff = resubscribeMinBackoff
+			select {
+			case err = <-sub.Err():
+				log.Println("Head subscription error:", err)
+			case <-ctx.Done():
+				sub.Unsubscribe()
+				return
+			}
+		} else {
+			log.Println("Head subscription failed:", err)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+
+		backoff *= 2
+		if backoff > resubscribeMaxBackoff {
+			backoff = resubscribeMaxBackoff
+		}
+	}
+}
+
+// PollingHeadSource polls the latest header at a fixed interval, for nodes without subscription support (eg. HTTP).
+// Heights skipped between polls are filled in by the ChainTracker.
+type PollingHeadSource struct {
+	client   HeaderReader
+	Interval time.Duration
+}
+
+func NewPollingHeadSource(client HeaderReader, interval time.Duration) *PollingHeadSource {
+	return &PollingHeadSource{client: client, Interval: interval}
+}
+
+func (s *PollingHeadSource) Run(ctx context.Context, heads chan<- *types.Header) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	var lastHead *types.Header
+	for {
+		header, err := s.client.HeaderByNumber(ctx, nil)
+		if err != nil {
+			log.Println("Polling latest header failed:", err)
+		} else if lastHead == nil || header.Hash() != lastHead.Hash() {
+			lastHead = header
+			select {
+			case heads <- header:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// HeadClient is implemented by ethclient.Client
+type HeadClient interface {
+	HeaderReader
+	HeadSubscriber
+}
+
+// NewHeadSource returns a SubscriptionHeadSource if the node supports subscriptions, else a PollingHeadSource
+func NewHeadSource(ctx context.Context, client HeadClient, pollInterval time.Duration) HeadSource {
+	// HTTP clients return the error directly, websocket nodes without subscription support as error response
+	sub, err := client.SubscribeNewHead(ctx, make(chan *types.Header))
+	if err != nil && (errors.Is(err, rpc.ErrNotificationsUnsupported) || err.Error() == rpc.ErrNotificationsUnsupported.Error()) {
+		log.Printf("Node does not support subscriptions, polling for new blocks every %s\n", pollInterval)
+		return NewPollingHeadSource(client, pollInterval)
+	}
+
+	// Other errors are handled by resubscribing
+	if err == nil {
+		sub.Unsubscribe()
+	}
+	return NewSubscriptionHeadSource(client)
+}